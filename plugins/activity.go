@@ -0,0 +1,174 @@
+package magic
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/driveactivity/v2"
+)
+
+// ActivityScope must be granted, in addition to the Drive scope, for
+// ItemActivity to work.
+const ActivityScope = driveactivity.DriveActivityReadonlyScope
+
+// Activity is one entry of an item's Drive history.
+type Activity struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"` // create, edit, rename, move, share, ...
+	Actors []string  `json:"actors"`
+	Target string    `json:"target"` // the item's title
+	Detail string    `json:"detail,omitempty"`
+}
+
+// ItemActivity returns the history of the Drive item id, newest first, at
+// most limit entries (0 for all). With recursive set and id a folder, the
+// activity of everything below it is included.
+func ItemActivity(ctx context.Context, client *http.Client, id string, recursive bool, limit int) ([]Activity, error) {
+	srv, err := driveactivity.New(client)
+	if err != nil {
+		return nil, err
+	}
+	req := &driveactivity.QueryDriveActivityRequest{PageSize: 100}
+	if recursive {
+		req.AncestorName = "items/" + id
+	} else {
+		req.ItemName = "items/" + id
+	}
+	var out []Activity
+	for {
+		res, err := srv.Activity.Query(req).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range res.Activities {
+			out = append(out, activityOf(a))
+			if limit > 0 && len(out) == limit {
+				return out, nil
+			}
+		}
+		if res.NextPageToken == "" {
+			return out, nil
+		}
+		req.PageToken = res.NextPageToken
+	}
+}
+
+func activityOf(a *driveactivity.DriveActivity) Activity {
+	act := Activity{}
+	ts := a.Timestamp
+	if ts == "" && a.TimeRange != nil {
+		ts = a.TimeRange.EndTime
+	}
+	act.Time, _ = time.Parse(time.RFC3339, ts)
+	for _, actor := range a.Actors {
+		act.Actors = append(act.Actors, actorName(actor))
+	}
+	for _, t := range a.Targets {
+		if t.DriveItem != nil {
+			act.Target = t.DriveItem.Title
+			break
+		}
+	}
+	d := a.PrimaryActionDetail
+	switch {
+	case d == nil:
+		act.Action = "unknown"
+	case d.Create != nil:
+		act.Action = "create"
+	case d.Edit != nil:
+		act.Action = "edit"
+	case d.Rename != nil:
+		act.Action = "rename"
+		act.Detail = d.Rename.OldTitle + " -> " + d.Rename.NewTitle
+	case d.Move != nil:
+		act.Action = "move"
+		act.Detail = "to " + refTitles(d.Move.AddedParents) + " from " + refTitles(d.Move.RemovedParents)
+	case d.PermissionChange != nil:
+		act.Action = "share"
+		var parts []string
+		for _, p := range d.PermissionChange.AddedPermissions {
+			parts = append(parts, "+"+p.Role+" "+grantee(p))
+		}
+		for _, p := range d.PermissionChange.RemovedPermissions {
+			parts = append(parts, "-"+p.Role+" "+grantee(p))
+		}
+		act.Detail = strings.Join(parts, ", ")
+	case d.Delete != nil:
+		act.Action = "delete"
+		act.Detail = strings.ToLower(d.Delete.Type)
+	case d.Restore != nil:
+		act.Action = "restore"
+	case d.Comment != nil:
+		act.Action = "comment"
+	case d.DlpChange != nil:
+		act.Action = "dlp"
+	case d.Reference != nil:
+		act.Action = "reference"
+	case d.SettingsChange != nil:
+		act.Action = "settings"
+	case d.AppliedLabelChange != nil:
+		act.Action = "label"
+	default:
+		act.Action = "other"
+	}
+	return act
+}
+
+func actorName(a *driveactivity.Actor) string {
+	switch {
+	case a.User != nil:
+		return userName(a.User)
+	case a.Administrator != nil:
+		return "administrator"
+	case a.Anonymous != nil:
+		return "anonymous"
+	case a.Impersonation != nil:
+		return "impersonation"
+	case a.System != nil:
+		return "system"
+	}
+	return "unknown"
+}
+
+// userName is "me" or the person resource name; resolving people needs
+// the People API.
+func userName(u *driveactivity.User) string {
+	switch {
+	case u.KnownUser != nil && u.KnownUser.IsCurrentUser:
+		return "me"
+	case u.KnownUser != nil:
+		return u.KnownUser.PersonName
+	case u.DeletedUser != nil:
+		return "deleted user"
+	}
+	return "unknown user"
+}
+
+func grantee(p *driveactivity.Permission) string {
+	switch {
+	case p.Anyone != nil:
+		return "anyone"
+	case p.Domain != nil:
+		return p.Domain.Name
+	case p.Group != nil:
+		return p.Group.Email
+	case p.User != nil:
+		return userName(p.User)
+	}
+	return "?"
+}
+
+func refTitles(refs []*driveactivity.TargetReference) string {
+	var t []string
+	for _, r := range refs {
+		if r.DriveItem != nil {
+			t = append(t, r.DriveItem.Title)
+		}
+	}
+	if len(t) == 0 {
+		return "-"
+	}
+	return strings.Join(t, ", ")
+}
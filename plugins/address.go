@@ -0,0 +1,157 @@
+package magic
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// DrivePrefix starts the remote paths taken wherever file IDs are, as in
+// drive:/Backups/2024/db.sql.gz, from the root of My Drive.
+const DrivePrefix = "drive:"
+
+// PathCacheTTL is how long ResolveDrivePath trusts a name it looked up.
+var PathCacheTTL = time.Minute
+
+var pathCache = struct {
+	sync.Mutex
+	m map[string]cachedPath // by parent ID and name
+}{m: map[string]cachedPath{}}
+
+type cachedPath struct {
+	f    *drive.File
+	when time.Time
+}
+
+// IsDrivePath reports whether s is a drive: path rather than an ID.
+func IsDrivePath(s string) bool {
+	return strings.HasPrefix(s, DrivePrefix)
+}
+
+// ResolveID returns s, or the ID of the file s names when it is a drive:
+// path.
+func ResolveID(ctx context.Context, srv *drive.Service, s string) (string, error) {
+	if !IsDrivePath(s) {
+		return s, nil
+	}
+	f, err := ResolveDrivePath(ctx, srv, s)
+	if err != nil {
+		return "", err
+	}
+	return f.Id, nil
+}
+
+// ResolveDrivePath returns the file the drive: path p names, walking the
+// names down from My Drive. A name shared by several files in a folder is
+// an error, as no one of them is meant more than the others.
+func ResolveDrivePath(ctx context.Context, srv *drive.Service, p string) (*drive.File, error) {
+	clean := strings.Trim(path.Clean("/"+strings.TrimPrefix(p, DrivePrefix)), "/")
+	f, err := lookupName(ctx, srv, "", "root")
+	if err != nil {
+		return nil, err
+	}
+	if clean == "" {
+		return f, nil
+	}
+	for _, name := range strings.Split(clean, "/") {
+		if f.MimeType != FolderMime {
+			return nil, fmt.Errorf("%s: %w", p, ErrNoPath)
+		}
+		if f, err = lookupName(ctx, srv, f.Id, name); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return f, nil
+}
+
+// lookupName finds the file called name in the folder parent, or the
+// file with the ID name when parent is empty.
+func lookupName(ctx context.Context, srv *drive.Service, parent string, name string) (*drive.File, error) {
+	key := parent + "/" + name
+	pathCache.Lock()
+	c, ok := pathCache.m[key]
+	pathCache.Unlock()
+	if ok && time.Since(c.when) < PathCacheTTL {
+		return c.f, nil
+	}
+	var f *drive.File
+	if parent == "" {
+		var err error
+		if f, err = srv.Files.Get(name).Fields(ListFields).Context(ctx).Do(); err != nil {
+			return nil, Classify(err)
+		}
+	} else {
+		enc := QueryString(Names.Encrypt(name))
+		q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", enc, parent)
+		r, err := srv.Files.List().Q(q).Fields("files(" + ListFields + ")").PageSize(2).Context(ctx).Do()
+		if err != nil {
+			return nil, Classify(err)
+		}
+		switch len(r.Files) {
+		case 0:
+			return nil, ErrNoPath
+		case 1:
+			f = r.Files[0]
+		default:
+			return nil, fmt.Errorf("several files are called %q; use an ID or run dedupe-names", name)
+		}
+	}
+	pathCache.Lock()
+	pathCache.m[key] = cachedPath{f: f, when: time.Now()}
+	pathCache.Unlock()
+	return f, nil
+}
+
+// ResolveAll returns every file at the drive: path p: where several
+// folders on the way share a name, each of them is looked into. A path
+// without the drive: prefix is taken from My Drive as well.
+func ResolveAll(ctx context.Context, srv *drive.Service, p string) ([]*drive.File, error) {
+	clean := strings.Trim(path.Clean("/"+strings.TrimPrefix(p, DrivePrefix)), "/")
+	root, err := lookupName(ctx, srv, "", "root")
+	if err != nil {
+		return nil, err
+	}
+	found := []*drive.File{root}
+	if clean == "" {
+		return found, nil
+	}
+	for _, name := range strings.Split(clean, "/") {
+		enc := QueryString(Names.Encrypt(name))
+		var next []*drive.File
+		for _, f := range found {
+			if f.MimeType != FolderMime {
+				continue
+			}
+			it := ListIter(ctx, srv, fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", enc, f.Id))
+			for it.Next() {
+				next = append(next, it.File())
+			}
+			if err := it.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if found = next; len(found) == 0 {
+			return nil, fmt.Errorf("%s: %w", p, ErrNoPath)
+		}
+	}
+	return found, nil
+}
+
+// DrivePaths returns the paths of the Drive file id as Paths does, those
+// in My Drive written as drive: paths that ResolveID takes back.
+func DrivePaths(ctx context.Context, srv *drive.Service, id string) ([]string, error) {
+	paths, err := Paths(ctx, srv, id)
+	for i, p := range paths {
+		if p == MyDrive {
+			paths[i] = DrivePrefix + "/"
+		} else if strings.HasPrefix(p, MyDrive+"/") {
+			paths[i] = DrivePrefix + strings.TrimPrefix(p, MyDrive)
+		}
+	}
+	return paths, err
+}
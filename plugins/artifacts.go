@@ -0,0 +1,257 @@
+package magic
+
+import (
+	"encoding/binary"
+	"errors"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Route maps a URL path of the artifact site to Drive. A route with File
+// serves that file at exactly Path; a route with Folder serves the files
+// below the folder under the Path prefix, "/releases/v1.2.3/tool" being
+// "v1.2.3/tool" inside it.
+type Route struct {
+	Path   string `yaml:"path"`
+	File   string `yaml:"file,omitempty"`
+	Folder string `yaml:"folder,omitempty"`
+}
+
+var downloadsBucket = []byte("downloads")
+
+// ErrNoRoute is returned by Resolve for paths no route or file matches.
+var ErrNoRoute = errors.New("no artifact at this path")
+
+// Artifacts resolves clean URL paths to Drive files through Routes,
+// caching the answers for TTL, and keeps ArtifactStats per path in
+// StateDB.
+type Artifacts struct {
+	srv    *drive.Service
+	routes []Route
+	ttl    time.Duration
+
+	mu     sync.Mutex
+	cache  map[string]cachedArtifact
+	counts map[string]ArtifactStats // not yet flushed
+}
+
+// artifactCacheMax bounds the paths Artifacts remembers, as clients pick
+// the paths.
+const artifactCacheMax = 10000
+
+type cachedArtifact struct {
+	file    *drive.File
+	expires time.Time
+}
+
+func NewArtifacts(srv *drive.Service, routes []Route, ttl time.Duration) *Artifacts {
+	return &Artifacts{srv: srv, routes: routes, ttl: ttl, cache: map[string]cachedArtifact{}, counts: map[string]ArtifactStats{}}
+}
+
+// SetRoutes replaces the routes, forgetting what the old ones resolved to.
+func (a *Artifacts) SetRoutes(routes []Route) {
+	a.mu.Lock()
+	a.routes = routes
+	a.cache = map[string]cachedArtifact{}
+	a.mu.Unlock()
+}
+
+// Resolve returns the Drive file served at the URL path p.
+func (a *Artifacts) Resolve(ctx context.Context, p string) (*drive.File, error) {
+	p = path.Clean("/" + p)
+	a.mu.Lock()
+	c, ok := a.cache[p]
+	routes := a.routes
+	a.mu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		if c.file == nil {
+			return nil, ErrNoRoute
+		}
+		return c.file, nil
+	}
+	route := match(routes, p)
+	if route == nil {
+		return nil, ErrNoRoute
+	}
+	f, err := a.resolve(ctx, route, p)
+	if err != nil && err != ErrNoRoute {
+		return nil, err
+	}
+	// misses below routes are cached too, so probing bots do not cost API
+	// calls
+	a.mu.Lock()
+	a.store(p, cachedArtifact{file: f, expires: time.Now().Add(a.ttl)})
+	a.mu.Unlock()
+	return f, err
+}
+
+// store caches c for p, first dropping the expired entries when the cache
+// is full. A miss finding it full still is not cached; a hit takes the
+// place of some other entry. The caller holds a.mu.
+func (a *Artifacts) store(p string, c cachedArtifact) {
+	if len(a.cache) >= artifactCacheMax {
+		now := time.Now()
+		for k, e := range a.cache {
+			if !now.Before(e.expires) {
+				delete(a.cache, k)
+			}
+		}
+	}
+	if len(a.cache) >= artifactCacheMax {
+		if c.file == nil {
+			return
+		}
+		for k := range a.cache {
+			delete(a.cache, k)
+			break
+		}
+	}
+	a.cache[p] = c
+}
+
+// match returns the route covering the URL path p, if any.
+func match(routes []Route, p string) *Route {
+	for i, r := range routes {
+		switch {
+		case r.File != "" && p == path.Clean("/"+r.Path):
+			return &routes[i]
+		case r.Folder != "" && strings.HasPrefix(p, folderPrefix(r)):
+			return &routes[i]
+		}
+	}
+	return nil
+}
+
+func folderPrefix(r Route) string {
+	return strings.TrimSuffix(path.Clean("/"+r.Path), "/") + "/"
+}
+
+func (a *Artifacts) resolve(ctx context.Context, r *Route, p string) (*drive.File, error) {
+	if r.File != "" {
+		f, err := a.srv.Files.Get(r.File).Fields(FileFields).Context(ctx).Do()
+		if isNotFound(err) {
+			return nil, ErrNoRoute
+		}
+		return f, err
+	}
+	f, err := ResolvePath(ctx, a.srv, r.Folder, strings.TrimPrefix(p, folderPrefix(*r)))
+	if err == ErrNoPath || err == nil && f.MimeType == FolderMime {
+		return nil, ErrNoRoute
+	}
+	return f, err
+}
+
+// ArtifactStats is what was served at one URL path.
+type ArtifactStats struct {
+	Downloads int64 `json:"downloads"` // full downloads, resumed ones counted once
+	Bytes     int64 `json:"bytes"`     // including partial responses
+}
+
+func (st ArtifactStats) add(o ArtifactStats) ArtifactStats {
+	return ArtifactStats{st.Downloads + o.Downloads, st.Bytes + o.Bytes}
+}
+
+func (st ArtifactStats) encode() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, uint64(st.Downloads))
+	binary.BigEndian.PutUint64(b[8:], uint64(st.Bytes))
+	return b
+}
+
+func decodeArtifactStats(b []byte) ArtifactStats {
+	if len(b) != 16 {
+		return ArtifactStats{}
+	}
+	return ArtifactStats{int64(binary.BigEndian.Uint64(b)), int64(binary.BigEndian.Uint64(b[8:]))}
+}
+
+// Served records n bytes sent for the URL path p, and a download when
+// download is set.
+func (a *Artifacts) Served(p string, download bool, n int64) {
+	st := ArtifactStats{Bytes: n}
+	if download {
+		st.Downloads = 1
+	}
+	p = path.Clean("/" + p)
+	a.mu.Lock()
+	a.counts[p] = a.counts[p].add(st)
+	a.mu.Unlock()
+}
+
+// Flush adds what was served since the last Flush to StateDB. The
+// database is only opened for the write, so the CLI can use it meanwhile.
+func (a *Artifacts) Flush() error {
+	a.mu.Lock()
+	counts := a.counts
+	a.counts = map[string]ArtifactStats{}
+	a.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+	db, err := openStateDB()
+	if err != nil {
+		a.requeue(counts)
+		return err
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists(downloadsBucket)
+		if err != nil {
+			return err
+		}
+		for p, st := range counts {
+			st = st.add(decodeArtifactStats(bk.Get([]byte(p))))
+			if err := bk.Put([]byte(p), st.encode()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		a.requeue(counts)
+	}
+	return err
+}
+
+func (a *Artifacts) requeue(counts map[string]ArtifactStats) {
+	a.mu.Lock()
+	for p, st := range counts {
+		a.counts[p] = a.counts[p].add(st)
+	}
+	a.mu.Unlock()
+}
+
+// Stats returns the totals of every path, flushed or not.
+func (a *Artifacts) Stats() (map[string]ArtifactStats, error) {
+	out := map[string]ArtifactStats{}
+	db, err := openStateDB()
+	if err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(downloadsBucket)
+		if bk == nil {
+			return nil
+		}
+		return bk.ForEach(func(k, v []byte) error {
+			out[string(k)] = decodeArtifactStats(v)
+			return nil
+		})
+	})
+	db.Close()
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	for p, st := range a.counts {
+		out[p] = out[p].add(st)
+	}
+	a.mu.Unlock()
+	return out, nil
+}
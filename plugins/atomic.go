@@ -0,0 +1,41 @@
+package magic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// stagingSuffix marks files uploaded under a StagingName.
+const stagingSuffix = ".partial"
+
+// StagingName returns the temporary name a file called name is uploaded
+// under before Publish, hidden and unique so that consumers polling the
+// folder for name, or for its extension, pass it over.
+func StagingName(name string) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "." + name + "." + hex.EncodeToString(b) + stagingSuffix
+}
+
+// IsStaging reports whether name is a StagingName, left behind by an
+// upload that was interrupted before Publish.
+func IsStaging(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, stagingSuffix)
+}
+
+// Publish renames the staged upload f to name, putting it in place in
+// one step.
+func Publish(ctx context.Context, srv *drive.Service, f *drive.File, name string) (*drive.File, error) {
+	f, err := srv.Files.Update(f.Id, &drive.File{Name: name}).Fields(FileFields).Context(ctx).Do()
+	return f, Classify(err)
+}
+
+// Discard deletes the staged upload f, which failed verification, for
+// good: it never was visible under its name, so there is nothing to undo.
+func Discard(ctx context.Context, srv *drive.Service, f *drive.File) error {
+	return Classify(srv.Files.Delete(f.Id).Context(ctx).Do())
+}
@@ -0,0 +1,104 @@
+package magic
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// AuditEntry records one mutating operation.
+type AuditEntry struct {
+	Time      time.Time         `json:"time"`
+	Actor     string            `json:"actor"`
+	Op        string            `json:"op"` // upload, delete, share, move, ...
+	FileID    string            `json:"fileId,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	RequestID string            `json:"requestId,omitempty"` // X-Request-ID of the gateway request or upload
+}
+
+// auditSink is the part of *syslog.Writer auditing uses.
+type auditSink interface {
+	Notice(m string) error
+	Close() error
+}
+
+var audit struct {
+	sync.Mutex
+	file   *os.File
+	syslog auditSink
+}
+
+// OpenAudit starts appending audit entries as JSON lines to path and,
+// when toSyslog is set, to the local syslog under the "magic" tag.
+// Either may be disabled with an empty path / false.
+func OpenAudit(path string, toSyslog bool) error {
+	audit.Lock()
+	defer audit.Unlock()
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		audit.file = f
+	}
+	if toSyslog {
+		w, err := openSyslog()
+		if err != nil {
+			return err
+		}
+		audit.syslog = w
+	}
+	return nil
+}
+
+// CloseAudit flushes and closes the audit sinks.
+func CloseAudit() {
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.file != nil {
+		audit.file.Sync()
+		audit.file.Close()
+		audit.file = nil
+	}
+	if audit.syslog != nil {
+		audit.syslog.Close()
+		audit.syslog = nil
+	}
+}
+
+// Audit writes e to the open sinks. It is a no-op when auditing is off.
+func Audit(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	audit.Lock()
+	defer audit.Unlock()
+	if audit.file != nil {
+		// One write per entry keeps lines whole with O_APPEND.
+		audit.file.Write(append(b, '\n'))
+	}
+	if audit.syslog != nil {
+		audit.syslog.Notice(string(b))
+	}
+}
+
+// auditLocal records op, done by the package on behalf of the local user,
+// on the Drive file id.
+func auditLocal(op string, id string, name string, params map[string]string, err error) {
+	e := AuditEntry{Op: op, FileID: id, Name: name, Params: params}
+	if u, uerr := user.Current(); uerr == nil {
+		e.Actor = u.Username
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	Audit(e)
+}
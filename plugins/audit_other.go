@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package magic
+
+import "errors"
+
+func openSyslog() (auditSink, error) {
+	return nil, errors.New("syslog is not available on this system")
+}
@@ -0,0 +1,116 @@
+package magic
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+var revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// AuthInfo describes the credentials the tool would use.
+type AuthInfo struct {
+	Location  string    // where the token or credential config is read from
+	Federated bool      // CredentialsFile is used instead of a user token
+	Encrypted bool      // the token file is encrypted
+	Account   string    // email of the authorized account, when reachable
+	Scopes    []string  // scopes the token was granted
+	Expiry    time.Time // of the access token; it is refreshed as needed
+	Refresh   bool      // a refresh token is held
+}
+
+// AuthStatus reports on the cached credentials without authorizing anew.
+// The account is looked up with Drive, refreshing the token if needed;
+// when that fails the rest is still returned along with the error.
+func AuthStatus(ctx context.Context) (*AuthInfo, error) {
+	if CredentialsFile != "" {
+		info := &AuthInfo{Location: CredentialsFile, Federated: true}
+		client, err := federatedClient(ctx, []string{drive.DriveFileScope})
+		if err == nil {
+			info.Account, err = account(ctx, client)
+		}
+		return info, err
+	}
+	info := &AuthInfo{Location: TokenFile}
+	if os.Getenv(TokenEnv) != "" {
+		info.Location = "$" + TokenEnv
+	}
+	tok, scopes, err := loadToken()
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("no token cached at %s; run any command to authorize", TokenFile)
+		}
+		return info, err
+	}
+	info.Encrypted, info.Scopes, info.Expiry, info.Refresh = tokenSealed, scopes, tok.Expiry, tok.RefreshToken != ""
+	b, err := readSecret()
+	if err != nil {
+		return info, fmt.Errorf("unable to read client secret file: %v", err)
+	}
+	config, err := google.ConfigFromJSON(b, scopes...)
+	if err != nil {
+		return info, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: Transport})
+	info.Account, err = account(ctx, tokenClient(ctx, config, tok, scopes))
+	return info, err
+}
+
+func account(ctx context.Context, client *http.Client) (string, error) {
+	srv, err := Service(client)
+	if err != nil {
+		return "", err
+	}
+	about, err := srv.About.Get().Fields("user(emailAddress)").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return about.User.EmailAddress, nil
+}
+
+// RevokeToken revokes the cached token with Google, which ends the grant
+// for every copy of it, and deletes the local cache.
+func RevokeToken(ctx context.Context) error {
+	if CredentialsFile != "" {
+		return fmt.Errorf("federated credentials from %s hold no token to revoke", CredentialsFile)
+	}
+	tok, _, err := loadToken()
+	if err != nil {
+		return err
+	}
+	t := tok.RefreshToken
+	if t == "" {
+		t = tok.AccessToken
+	}
+	c := &http.Client{Transport: Transport, Timeout: 30 * time.Second}
+	req, err := http.NewRequest("POST", revokeURL, nil)
+	if err != nil {
+		return err
+	}
+	req.URL.RawQuery = url.Values{"token": {t}}.Encode()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("unable to revoke token: %v", err)
+	}
+	res.Body.Close()
+	// 400 means the token was already invalid, which is as good
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("unable to revoke token: %s", res.Status)
+	}
+	if os.Getenv(TokenEnv) != "" {
+		return nil
+	}
+	if err = os.Remove(TokenFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(tokenLockPath())
+	return nil
+}
@@ -51,6 +51,7 @@ type FileInfo struct {
 	Size    int64     // length in bytes for regular files; system-dependent for others
 	ModTime time.Time // modification time
 	IsDir   bool      // abbreviation for Mode().IsDir()
+	Link    string    // symlink target, set when links are preserved
 }
 
 type UpDT struct {
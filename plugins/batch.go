@@ -0,0 +1,89 @@
+package magic
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Limiter paces API calls to a steady rate. A nil *Limiter does not limit.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter allows qps calls per second; Drive's default per-user quota
+// is about 10.
+func NewLimiter(qps float64) *Limiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &Limiter{interval: time.Duration(float64(time.Second) / qps)}
+}
+
+// Wait blocks until the caller may issue its next call.
+func (l *Limiter) Wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// Update is one metadata change: the non-empty fields of File are written,
+// and parents are added or removed (comma separated IDs).
+type Update struct {
+	ID            string
+	File          *drive.File
+	AddParents    string
+	RemoveParents string
+}
+
+// BatchUpdate applies updates with up to workers calls in flight, each
+// paced through limit. errs[i] is the outcome of updates[i].
+func BatchUpdate(ctx context.Context, srv *drive.Service, updates []Update, workers int, limit *Limiter) (errs []error) {
+	if workers < 1 {
+		workers = 1
+	}
+	errs = make([]error, len(updates))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				u := updates[i]
+				f := u.File
+				if f == nil {
+					f = &drive.File{}
+				}
+				call := srv.Files.Update(u.ID, f).Fields("id").Context(ctx)
+				if u.AddParents != "" {
+					call.AddParents(u.AddParents)
+				}
+				if u.RemoveParents != "" {
+					call.RemoveParents(u.RemoveParents)
+				}
+				limit.Wait()
+				_, errs[i] = call.Do()
+			}
+		}()
+	}
+	for i := range updates {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,30 @@
+package magic
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// OpenBrowser shows url in $BROWSER, or else in the default browser: with
+// open on macOS, the URL handler on Windows and xdg-open elsewhere. It
+// does not wait for the browser to close.
+func OpenBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch {
+	case os.Getenv("BROWSER") != "":
+		cmd = exec.Command(os.Getenv("BROWSER"), url)
+	case runtime.GOOS == "darwin":
+		cmd = exec.Command("open", url)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start a browser: %v", err)
+	}
+	go cmd.Wait()
+	return nil
+}
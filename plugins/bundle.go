@@ -0,0 +1,217 @@
+package magic
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Share bundles are zip archives encrypted with WinZip AES (AE-2), which
+// 7-Zip, WinZip, macOS Archive Utility and most unzip tools of today open
+// with nothing but the password, so they suit sending files to people
+// outside.
+
+const (
+	aesMethod     = 99
+	aesExtraID    = 0x9901
+	aesSaltSize   = 16 // AES-256
+	aesMACSize    = 10
+	aesIterations = 1000
+)
+
+// GeneratePassword returns a random password of n letters and digits,
+// leaving out those easily mistaken for one another.
+func GeneratePassword(n int) (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	b := make([]byte, n)
+	for i := range b {
+		c, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[c.Int64()]
+	}
+	return string(b), nil
+}
+
+// WriteBundle writes the local files and directories paths to w as a zip
+// archive encrypted with password. Files are stored under their base name
+// and directories with their content below theirs.
+func WriteBundle(w io.Writer, paths []string, password []byte) error {
+	if len(password) == 0 {
+		return errors.New("empty bundle password")
+	}
+	zw := zip.NewWriter(w)
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		err := filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(filepath.Dir(p), file)
+			if err != nil {
+				return err
+			}
+			return addEncrypted(zw, filepath.ToSlash(rel), file, info, password)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// addEncrypted adds file to zw as name. The entry is written to a
+// temporary file first, as its header holds the encrypted size.
+func addEncrypted(zw *zip.Writer, name string, file string, info os.FileInfo, password []byte) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := ioutil.TempFile("", "bundle")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	method := zip.Deflate
+	if compressed(mime.TypeByExtension(filepath.Ext(file))) {
+		method = zip.Store
+	}
+	salt := make([]byte, aesSaltSize)
+	if _, err = rand.Read(salt); err != nil {
+		return err
+	}
+	keys := pbkdf2.Key(password, salt, aesIterations, 2*32+2, sha1.New)
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha1.New, keys[32:64])
+	tmp.Write(salt)
+	tmp.Write(keys[64:]) // password verifier
+	ew := &aesWriter{w: io.MultiWriter(tmp, mac), block: block}
+	var data io.WriteCloser = nopWriteCloser{ew}
+	if method == zip.Deflate {
+		if data, err = flate.NewWriter(ew, flate.DefaultCompression); err != nil {
+			return err
+		}
+	}
+	if _, err = io.Copy(data, in); err != nil {
+		return err
+	}
+	if err = data.Close(); err != nil {
+		return err
+	}
+	tmp.Write(mac.Sum(nil)[:aesMACSize])
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], aesExtraID)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], 2) // AE-2: no CRC
+	copy(extra[6:], "AE")
+	extra[8] = 3 // AES-256
+	binary.LittleEndian.PutUint16(extra[9:], method)
+	h := &zip.FileHeader{
+		Name:               name,
+		Method:             aesMethod,
+		Flags:              0x1, // encrypted
+		Modified:           info.ModTime(),
+		Extra:              extra,
+		CompressedSize64:   uint64(size),
+		UncompressedSize64: uint64(info.Size()),
+	}
+	h.SetMode(info.Mode())
+	// unlike CreateHeader, CreateRaw leaves the MS-DOS time to the caller
+	t := info.ModTime()
+	h.ModifiedDate = uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+	h.ModifiedTime = uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()/2)
+	entry, err := zw.CreateRaw(h)
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, tmp)
+	return err
+}
+
+// aesWriter is AES in the CTR mode of WinZip, whose counter is little
+// endian and starts at 1.
+type aesWriter struct {
+	w       io.Writer
+	block   cipher.Block
+	counter uint64
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func (a *aesWriter) Write(p []byte) (int, error) {
+	out := make([]byte, len(p))
+	for i, c := range p {
+		if a.counter == 0 || a.used == aes.BlockSize {
+			a.counter++
+			var ctr [aes.BlockSize]byte
+			binary.LittleEndian.PutUint64(ctr[:], a.counter)
+			a.block.Encrypt(a.stream[:], ctr[:])
+			a.used = 0
+		}
+		out[i] = c ^ a.stream[a.used]
+		a.used++
+	}
+	return a.w.Write(out)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// UploadBundle uploads the WriteBundle of paths as name into the Drive
+// folder parent, or the root when parent is empty.
+func UploadBundle(ctx context.Context, srv *drive.Service, paths []string, password []byte, name string, parent string) (*drive.File, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(WriteBundle(pw, paths, password))
+	}()
+	defer pr.Close()
+	f := &drive.File{Name: name, MimeType: "application/zip"}
+	if parent != "" {
+		f.Parents = []string{parent}
+	}
+	return srv.Files.Create(f).Media(pr).Fields(FileFields).Context(ctx).Do()
+}
+
+// ReadPassword reads a password from the first line of file.
+func ReadPassword(file string) ([]byte, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(bytes.SplitN(b, []byte("\n"), 2)[0], "\r"), nil
+}
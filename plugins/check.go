@@ -0,0 +1,121 @@
+package magic
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// CheckReport is the outcome of Check. Paths are slash separated and
+// relative to the compared roots.
+type CheckReport struct {
+	Folder   string          `json:"folder"`
+	Local    string          `json:"local"`
+	Checksum string          `json:"checksum,omitempty"`
+	Matched  int             `json:"matched"`
+	Missing  []string        `json:"missing"` // local files not on Drive
+	Extra    []string        `json:"extra"`   // Drive files not present locally
+	Size     []CheckMismatch `json:"sizeMismatch"`
+	Content  []CheckMismatch `json:"checksumMismatch"`
+	Skipped  []string        `json:"skipped"` // Google Docs and files without a remote checksum
+}
+
+type CheckMismatch struct {
+	Path   string `json:"path"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	ID     string `json:"id"`
+}
+
+// OK reports whether both sides match.
+func (r *CheckReport) OK() bool {
+	return len(r.Missing)+len(r.Extra)+len(r.Size)+len(r.Content) == 0
+}
+
+// Check compares the Drive folder id with the local directory dir without
+// transferring content: sizes always, and local digests against Drive's
+// checksums when algo is set. Ignore files are honored and symlinks
+// followed, as for uploads.
+func Check(ctx context.Context, srv *drive.Service, id string, dir string, algo string) (*CheckReport, error) {
+	if algo != "" {
+		if _, err := NewChecksum(algo); err != nil {
+			return nil, err
+		}
+	}
+	remote, err := RemoteTree(ctx, srv, id)
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Clean(dir)
+	local, err := WalkWith(dir, WalkOptions{Ignore: true})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &CheckReport{Folder: id, Local: dir, Checksum: algo,
+		Missing: []string{}, Extra: []string{}, Size: []CheckMismatch{}, Content: []CheckMismatch{}, Skipped: []string{}}
+	seen := map[string]bool{}
+	for _, fi := range local {
+		if fi.Path == dir {
+			continue
+		}
+		rel, err := filepath.Rel(dir, fi.Path)
+		if err != nil {
+			return nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		f, ok := remote[rel]
+		if !ok {
+			r.Missing = append(r.Missing, rel)
+			continue
+		}
+		seen[rel] = true
+		if fi.IsDir || f.MimeType == FolderMime {
+			if fi.IsDir != (f.MimeType == FolderMime) {
+				r.Size = append(r.Size, CheckMismatch{Path: rel, Local: kind(fi.IsDir), Remote: kind(!fi.IsDir), ID: f.Id})
+			}
+			continue
+		}
+		if IsGoogleDoc(f) {
+			r.Skipped = append(r.Skipped, rel)
+			continue
+		}
+		if fi.Size != f.Size {
+			r.Size = append(r.Size, CheckMismatch{Path: rel, Local: strconv.FormatInt(fi.Size, 10), Remote: strconv.FormatInt(f.Size, 10), ID: f.Id})
+			continue
+		}
+		if algo != "" {
+			want := RemoteChecksum(f, algo)
+			if want == "" {
+				r.Skipped = append(r.Skipped, rel)
+				continue
+			}
+			got, err := LocalChecksum(fi.Path, algo)
+			if err != nil {
+				return nil, err
+			}
+			if got != want {
+				r.Content = append(r.Content, CheckMismatch{Path: rel, Local: got, Remote: want, ID: f.Id})
+				continue
+			}
+		}
+		r.Matched++
+	}
+	for p := range remote {
+		if !seen[p] {
+			r.Extra = append(r.Extra, p)
+		}
+	}
+	sort.Strings(r.Extra)
+	return r, nil
+}
+
+func kind(isDir bool) string {
+	if isDir {
+		return "directory"
+	}
+	return "file"
+}
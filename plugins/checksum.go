@@ -0,0 +1,81 @@
+package magic
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Checksum algorithms Drive reports for binary files.
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+)
+
+// NewChecksum returns a hash for algo, one of the Checksum constants.
+func NewChecksum(algo string) (hash.Hash, error) {
+	switch algo {
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unknown checksum algorithm %q", algo)
+}
+
+// RemoteChecksum returns the hex digest Drive stored for f, empty if the
+// field was not fetched or is not computed for this file.
+func RemoteChecksum(f *drive.File, algo string) string {
+	switch algo {
+	case ChecksumMD5:
+		return f.Md5Checksum
+	case ChecksumSHA1:
+		return f.Sha1Checksum
+	case ChecksumSHA256:
+		return f.Sha256Checksum
+	}
+	return ""
+}
+
+// LocalChecksum hashes the file at path with algo.
+func LocalChecksum(path string, algo string) (string, error) {
+	h, err := NewChecksum(algo)
+	if err != nil {
+		return "", err
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	if _, err = io.Copy(h, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify compares the local file at path against Drive's checksum of f.
+func Verify(path string, f *drive.File, algo string) error {
+	remote := RemoteChecksum(f, algo)
+	if remote == "" {
+		return fmt.Errorf("no %s checksum on Drive for %s", algo, f.Name)
+	}
+	local, err := LocalChecksum(path, algo)
+	if err != nil {
+		return err
+	}
+	if local != remote {
+		return fmt.Errorf("%s checksum mismatch for %s: local %s, Drive %s", algo, path, local, remote)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package magic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// CleanupOptions select the files Cleanup removes.
+type CleanupOptions struct {
+	OlderThan time.Duration // unmodified this long; 0 for any age
+	Query     string        // Drive search clause the files also match
+	Folder    string        // limits to the tree below this folder, else to files the user owns
+	Delete    bool          // delete for good instead of trashing
+	DryRun    bool          // only report
+	// Log, if set, is told of each file, with op "trash", "delete" or,
+	// on a dry run, "would trash" and "would delete".
+	Log func(op string, f *drive.File)
+}
+
+// CleanupStats are what Cleanup removed, or would have.
+type CleanupStats struct {
+	Files int
+	Bytes int64
+}
+
+// cleanupParents is how many folders one cleanup search names.
+const cleanupParents = 40
+
+// Cleanup trashes or deletes the files matching opt, to enforce retention
+// on folders anyone drops files into. Folders themselves are kept.
+func Cleanup(ctx context.Context, srv *drive.Service, opt CleanupOptions) (CleanupStats, error) {
+	var st CleanupStats
+	q := "trashed=false and mimeType!='" + FolderMime + "'"
+	if opt.OlderThan > 0 {
+		q += " and modifiedTime < '" + time.Now().Add(-opt.OlderThan).UTC().Format(time.RFC3339) + "'"
+	}
+	if opt.Query != "" {
+		q += " and (" + opt.Query + ")"
+	}
+	queries := []string{q + " and 'me' in owners"}
+	if opt.Folder != "" {
+		folders, err := treeFolders(ctx, srv, opt.Folder)
+		if err != nil {
+			return st, err
+		}
+		queries = nil
+		for len(folders) > 0 {
+			n := cleanupParents
+			if n > len(folders) {
+				n = len(folders)
+			}
+			in := make([]string, n)
+			for i, id := range folders[:n] {
+				in[i] = "'" + id + "' in parents"
+			}
+			queries = append(queries, q+" and ("+strings.Join(in, " or ")+")")
+			folders = folders[n:]
+		}
+	}
+	op := "trash"
+	if opt.Delete {
+		op = "delete"
+	}
+	if opt.DryRun {
+		op = "would " + op
+	}
+	for _, q := range queries {
+		// collect first: removing files while paging shifts the pages
+		var found []*drive.File
+		it := ListIter(ctx, srv, q)
+		for it.Next() {
+			found = append(found, it.File())
+		}
+		if err := it.Err(); err != nil {
+			return st, err
+		}
+		for _, f := range found {
+			var err error
+			switch {
+			case opt.DryRun:
+			case opt.Delete:
+				err = srv.Files.Delete(f.Id).Context(ctx).Do()
+			default:
+				_, err = srv.Files.Update(f.Id, &drive.File{Trashed: true}).Fields("id").Context(ctx).Do()
+			}
+			if err != nil {
+				return st, fmt.Errorf("%s: %v", Names.Display(f.Name), Classify(err))
+			}
+			st.Files++
+			st.Bytes += f.Size
+			if opt.Log != nil {
+				opt.Log(op, f)
+			}
+		}
+	}
+	return st, nil
+}
+
+// treeFolders returns id and the ids of every folder below it, listing
+// folders only.
+func treeFolders(ctx context.Context, srv *drive.Service, id string) ([]string, error) {
+	all := []string{id}
+	seen := map[string]bool{id: true}
+	for i := 0; i < len(all); i++ {
+		it := ListIter(ctx, srv, "'"+all[i]+"' in parents and mimeType='"+FolderMime+"' and trashed=false", "id")
+		for it.Next() {
+			if f := it.File(); !seen[f.Id] {
+				seen[f.Id] = true
+				all = append(all, f.Id)
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
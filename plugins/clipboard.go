@@ -0,0 +1,42 @@
+package magic
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboards are the programs tried, in order, to set the clipboard.
+func clipboards() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbcopy"}}
+	case "windows":
+		return [][]string{{"clip"}}
+	}
+	list := [][]string{{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		list = append([][]string{{"wl-copy"}}, list...)
+	}
+	return list
+}
+
+// CopyToClipboard puts text on the system clipboard, with pbcopy on macOS,
+// clip on Windows and wl-copy, xclip or xsel elsewhere.
+func CopyToClipboard(text string) error {
+	for _, c := range clipboards() {
+		path, err := exec.LookPath(c[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, c[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return errors.New("unable to set the clipboard: " + strings.TrimSpace(err.Error()+" "+string(out)))
+		}
+		return nil
+	}
+	return errors.New("unable to set the clipboard: none of pbcopy, clip, wl-copy, xclip or xsel found")
+}
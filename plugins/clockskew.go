@@ -0,0 +1,61 @@
+package magic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ClockSkewError is a token refresh refused because the local clock is too
+// far off Google's for assertions and tokens to be valid. Google only says
+// invalid_grant, just as for a revoked token, so the clock is compared
+// with the Date of its answer.
+type ClockSkewError struct {
+	Skew time.Duration // local time minus Google's
+	Err  error
+}
+
+func (e *ClockSkewError) Error() string {
+	skew, side := e.Skew, "ahead of"
+	if skew < 0 {
+		skew, side = -skew, "behind"
+	}
+	return fmt.Sprintf("authorization refused: the local clock is %v %s Google's, sync it with NTP (timedatectl set-ntp true): %v",
+		skew.Round(time.Second), side, e.Err)
+}
+
+func (e *ClockSkewError) Unwrap() error { return e.Err }
+
+// ExplainAuthError returns a ClockSkewError for invalid_grant refresh
+// failures made with a skewed clock, and adds a hint to the others, which
+// mean the token was revoked. Other errors are returned as they are.
+func ExplainAuthError(err error) error {
+	var re *oauth2.RetrieveError
+	if !errors.As(err, &re) || re.ErrorCode != "invalid_grant" {
+		return err
+	}
+	skew, serr := responseSkew(re.Response)
+	if serr != nil {
+		skew, serr = clockSkew()
+	}
+	if serr == nil && (skew > maxSkew || skew < -maxSkew) {
+		return &ClockSkewError{Skew: skew, Err: err}
+	}
+	return fmt.Errorf("%v (the token was revoked or expired; delete %s and authorize again)", err, TokenFile)
+}
+
+// responseSkew compares the local clock with the Date of res, to the
+// second it has.
+func responseSkew(res *http.Response) (time.Duration, error) {
+	if res == nil {
+		return 0, errors.New("no response")
+	}
+	remote, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(remote), nil
+}
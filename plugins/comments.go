@@ -0,0 +1,72 @@
+package magic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const commentFields = "id, author(displayName, emailAddress), content, createdTime, modifiedTime, resolved, " +
+	"quotedFileContent(value), replies(id, author(displayName, emailAddress), content, createdTime, action)"
+
+// Comments lists every comment on the file id, with its replies, oldest
+// first. Deleted comments are left out.
+func Comments(ctx context.Context, srv *drive.Service, id string) ([]*drive.Comment, error) {
+	var out []*drive.Comment
+	call := srv.Comments.List(id).PageSize(100).
+		Fields(googleapi.Field("nextPageToken, comments(" + commentFields + ")"))
+	err := call.Pages(ctx, func(page *drive.CommentList) error {
+		out = append(out, page.Comments...)
+		return nil
+	})
+	return out, err
+}
+
+// ResolveComment marks the comment resolved, the same as the Resolve
+// button in the Docs UI.
+func ResolveComment(ctx context.Context, srv *drive.Service, fileID string, commentID string) error {
+	_, err := srv.Replies.Create(fileID, commentID, &drive.Reply{Action: "resolve", Content: "Resolved"}).
+		Fields("id").Context(ctx).Do()
+	return err
+}
+
+// WriteCommentsMarkdown renders comments as a Markdown document titled
+// after the file, quoting the text each comment is anchored to.
+func WriteCommentsMarkdown(w io.Writer, title string, comments []*drive.Comment) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Comments on %s\n", title)
+	for _, c := range comments {
+		state := "open"
+		if c.Resolved {
+			state = "resolved"
+		}
+		fmt.Fprintf(&b, "\n## %s, %s (%s)\n\n", person(c.Author), c.CreatedTime, state)
+		if c.QuotedFileContent != nil && c.QuotedFileContent.Value != "" {
+			fmt.Fprintf(&b, "> %s\n\n", strings.Replace(c.QuotedFileContent.Value, "\n", "\n> ", -1))
+		}
+		fmt.Fprintf(&b, "%s\n", c.Content)
+		for _, r := range c.Replies {
+			text := r.Content
+			if r.Action != "" {
+				text = strings.TrimSpace("*" + r.Action + "* " + text)
+			}
+			fmt.Fprintf(&b, "\n- **%s**, %s: %s\n", person(r.Author), r.CreatedTime, text)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func person(u *drive.User) string {
+	switch {
+	case u == nil:
+		return "unknown"
+	case u.EmailAddress != "":
+		return u.DisplayName + " <" + u.EmailAddress + ">"
+	}
+	return u.DisplayName
+}
@@ -0,0 +1,47 @@
+package magic
+
+import (
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFile is where LoadConfig looks unless told otherwise.
+var ConfigFile = "magicserver.yaml"
+
+// Config holds settings shared by the CLI and the gateway. Command line
+// flags override it.
+type Config struct {
+	// BWLimit is a rate or schedule, see ParseBandwidth.
+	BWLimit string `yaml:"bwlimit"`
+	// Routes publish Drive files at clean URL paths of the gateway.
+	Routes []Route `yaml:"routes"`
+	// Browse is the Drive folder whose contents the gateway's list API
+	// exposes, by paths relative to it; empty disables the API.
+	Browse string `yaml:"browse"`
+	// CORSOrigins are the web origins whose pages may call the gateway,
+	// "*" for any.
+	CORSOrigins []string `yaml:"cors_origins"`
+	// ReadOnly refuses every change to Drive, as -read-only does.
+	ReadOnly bool `yaml:"read_only"`
+	// AllowFolders are the Drive folder IDs below which changes are
+	// permitted; empty permits them anywhere.
+	AllowFolders []string `yaml:"allow_folders"`
+	// Rules route uploads by what they are, see Rule.
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadConfig reads the YAML config at path; a missing file is an empty
+// config.
+func LoadConfig(path string) (*Config, error) {
+	c := &Config{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, yaml.Unmarshal(b, c)
+}
@@ -0,0 +1,69 @@
+package magic
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Transfers ride out network failures. A failed request is retried for
+// OutageAfter; a failure lasting longer is an outage, during which every
+// transfer is held, as if paused, until Google can be reached again. Upload
+// sessions then continue from the offset Drive kept.
+var (
+	// OutageAfter is how long failures last before they count as an
+	// outage; 0 fails transfers at the first network error as before.
+	OutageAfter = 30 * time.Second
+	// OutagePoll is how often connectivity is checked during an outage.
+	OutagePoll = 15 * time.Second
+)
+
+// NetworkError reports whether err is the network failing rather than
+// Drive answering: a dial, DNS or timeout error, a dropped connection, or
+// a stall.
+func NetworkError(err error) bool {
+	if err == nil || Refused(err) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrStalled) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// rideOut waits before a transfer that has failed with the network error
+// err since start is retried. It returns err, to fail the transfer, when
+// outages are not ridden out or Google is reachable while the failures
+// go on.
+func rideOut(start time.Time, err error) error {
+	if OutageAfter <= 0 {
+		return err
+	}
+	if time.Since(start) < OutageAfter {
+		time.Sleep(2 * time.Second)
+		return nil
+	}
+	if !TransfersOffline() && Reachable() == nil {
+		return err
+	}
+	pause.Lock()
+	if !pause.offline {
+		pause.offline = true
+		log.Printf("network down (%v), holding transfers until Google is reachable", err)
+		go func() {
+			WaitOnline(context.Background(), OutagePoll)
+			log.Println("network back, resuming transfers")
+			pause.Lock()
+			pause.offline = false
+			release()
+			pause.Unlock()
+		}()
+	}
+	pause.Unlock()
+	waitTransfers()
+	return nil
+}
@@ -0,0 +1,37 @@
+package magic
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// importTargets maps extensions Drive can convert on upload to the native
+// type they become.
+var importTargets = map[string]string{
+	".doc": DocMime, ".docx": DocMime, ".odt": DocMime, ".rtf": DocMime, ".md": DocMime, ".html": DocMime, ".htm": DocMime,
+	".xls": SheetMime, ".xlsx": SheetMime, ".ods": SheetMime, ".csv": SheetMime, ".tsv": SheetMime,
+	".ppt": SlidesMime, ".pptx": SlidesMime, ".odp": SlidesMime,
+}
+
+// importSources are content types Drive needs to recognize for converting,
+// where the system MIME table often lacks them.
+var importSources = map[string]string{
+	".md":  "text/markdown",
+	".csv": "text/csv",
+	".tsv": "text/tab-separated-values",
+}
+
+// ImportMime returns the native type the file name converts to, "" if it
+// cannot, and the content type to send its bytes as.
+func ImportMime(name string) (target string, source string) {
+	ext := strings.ToLower(filepath.Ext(name))
+	target = importTargets[ext]
+	if source = importSources[ext]; source == "" {
+		source = mime.TypeByExtension(ext)
+	}
+	if source == "" {
+		source = "application/octet-stream"
+	}
+	return target, source
+}
@@ -0,0 +1,193 @@
+package magic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DebugHTTP logs every call to Google: method, URL, status, latency and
+// how often the same request was sent before. DebugHTTPBody adds headers
+// and text payloads. Credentials are redacted in both.
+var (
+	DebugHTTP     bool
+	DebugHTTPBody bool
+)
+
+// debugBodyMax is how much of a payload is logged.
+const debugBodyMax = 64 << 10
+
+// secretParams are query, form and JSON fields whose values are never
+// logged. upload_id is one too: the session URI is all it takes to write
+// to the upload.
+var secretParams = map[string]bool{
+	"access_token": true, "refresh_token": true, "id_token": true, "code": true,
+	"code_verifier": true, "client_secret": true, "assertion": true, "subject_token": true,
+	"key": true, "private_key": true, "password": true, "upload_id": true,
+}
+
+var secretHeaders = map[string]bool{
+	"Authorization": true, "Proxy-Authorization": true, "Cookie": true,
+	"Set-Cookie": true, "X-Goog-Api-Key": true,
+}
+
+var secretJSON = regexp.MustCompile(`"(access_token|refresh_token|id_token|client_secret|private_key|assertion|subject_token)"(\s*:\s*)"[^"]*"`)
+
+// sent counts requests by what they ask for, to tell retries apart.
+var sent = struct {
+	sync.Mutex
+	last  map[string]time.Time
+	count map[string]int
+}{last: map[string]time.Time{}, count: map[string]int{}}
+
+type debugTransport struct {
+	base http.RoundTripper
+}
+
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !DebugHTTP && !DebugHTTPBody {
+		return t.base.RoundTrip(req)
+	}
+	retry := retries(req)
+	if DebugHTTPBody {
+		var body string
+		req = req.Clone(req.Context())
+		body, req.Body = peekBody(req.Header, req.Body, req.ContentLength)
+		log.Printf("http > %s %s\n%s%s", req.Method, redactURL(req.URL), formatHeaders(req.Header), body)
+	}
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	took := time.Since(start).Round(time.Millisecond)
+	note := ""
+	if retry > 0 {
+		note = fmt.Sprintf(" (retry %d)", retry)
+	}
+	if err != nil {
+		log.Printf("http %s %s failed after %v%s: %v", req.Method, redactURL(req.URL), took, note, err)
+		return res, err
+	}
+	log.Printf("http %s %s %d in %v%s", req.Method, redactURL(req.URL), res.StatusCode, took, note)
+	if DebugHTTPBody {
+		var body string
+		body, res.Body = peekBody(res.Header, res.Body, res.ContentLength)
+		log.Printf("http < %s\n%s%s", res.Status, formatHeaders(res.Header), body)
+	}
+	return res, nil
+}
+
+// retries returns how many times req was sent in the last minutes, by
+// method, URL and requested range.
+func retries(req *http.Request) int {
+	key := req.Method + " " + req.URL.String() + " " + req.Header.Get("Content-Range") + req.Header.Get("Range")
+	now := time.Now()
+	sent.Lock()
+	defer sent.Unlock()
+	if len(sent.last) > 1000 {
+		for k, t := range sent.last {
+			if now.Sub(t) > 5*time.Minute {
+				delete(sent.last, k)
+				delete(sent.count, k)
+			}
+		}
+	}
+	n := sent.count[key]
+	if now.Sub(sent.last[key]) > 5*time.Minute {
+		n = 0
+	}
+	sent.last[key], sent.count[key] = now, n+1
+	return n
+}
+
+func redactURL(u *url.URL) string {
+	c := *u
+	c.User = nil
+	c.RawQuery = redactQuery(u.RawQuery)
+	return c.String()
+}
+
+func redactQuery(raw string) string {
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return "REDACTED"
+	}
+	for k := range q {
+		if secretParams[k] {
+			q.Set(k, "REDACTED")
+		}
+	}
+	return q.Encode()
+}
+
+func formatHeaders(h http.Header) string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range h[k] {
+			switch {
+			case secretHeaders[k]:
+				v = "REDACTED"
+			case k == "Location":
+				if u, err := url.Parse(v); err == nil {
+					v = redactURL(u)
+				}
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", k, v)
+		}
+	}
+	return b.String()
+}
+
+// peekBody returns the start of body for the log, redacted, and a body
+// that still reads all of it. Payloads that are not text are only sized,
+// and not read.
+func peekBody(h http.Header, body io.ReadCloser, size int64) (string, io.ReadCloser) {
+	if body == nil || body == http.NoBody {
+		return "", body
+	}
+	t, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	text := strings.HasPrefix(t, "text/") || strings.HasSuffix(t, "json") ||
+		t == "application/x-www-form-urlencoded" || t == "application/xml"
+	if !text {
+		return fmt.Sprintf("  [%d bytes of %s]\n", size, t), body
+	}
+	head, err := ioutil.ReadAll(io.LimitReader(body, debugBodyMax+1))
+	rest := struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), errReader{err}, body), body}
+	more := ""
+	if len(head) > debugBodyMax {
+		head, more = head[:debugBodyMax], "..."
+	}
+	s := string(head)
+	if t == "application/x-www-form-urlencoded" {
+		s = redactQuery(s)
+	} else {
+		s = secretJSON.ReplaceAllString(s, `"$1"${2}"REDACTED"`)
+	}
+	return "  " + s + more + "\n", rest
+}
+
+// errReader hands on an error met while peeking, nil reading as EOF.
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, io.EOF
+}
@@ -0,0 +1,250 @@
+package magic
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// foldersBucket remembers, by parent ID and name, the folder chosen among
+// several of the same name, so later runs keep writing into the same one.
+var foldersBucket = []byte("folders")
+
+const dedupeFields = "id, name, mimeType, md5Checksum, size, createdTime"
+
+// GetOrCreateFolder returns the ID of the folder called name in the Drive
+// folder parent, or anywhere when parent is empty, creating it when there
+// is none. Drive allows several folders of one name; then the one chosen
+// on earlier runs, as remembered in StateDB, is kept to and else the
+// oldest. dups is the number of folders sharing the name, 0 when the
+// folder was created.
+func GetOrCreateFolder(ctx context.Context, srv *drive.Service, parent string, name string) (id string, dups int, err error) {
+	defer func() { err = Classify(err) }()
+	q := fmt.Sprintf("name = '%s' and mimeType = '%s' and trashed = false", QueryString(name), FolderMime)
+	if parent != "" {
+		q += fmt.Sprintf(" and '%s' in parents", parent)
+	}
+	var found []*drive.File
+	it := ListIter(ctx, srv, q, dedupeFields)
+	for it.Next() {
+		found = append(found, it.File())
+	}
+	if err = it.Err(); err != nil {
+		return "", 0, err
+	}
+	key := []byte(parent + "/" + name)
+	if len(found) == 0 {
+		f := &drive.File{Name: name, Description: "Auto Create by gdrive-upload", MimeType: FolderMime}
+		if parent != "" {
+			f.Parents = []string{parent}
+		}
+		if f, err = srv.Files.Create(f).Fields("id").Context(ctx).Do(); err != nil {
+			return "", 0, err
+		}
+		remember(key, f.Id)
+		return f.Id, 0, nil
+	}
+	if len(found) == 1 {
+		return found[0].Id, 1, nil
+	}
+	oldestFirst(found)
+	id = found[0].Id
+	known := recall(key)
+	for _, f := range found {
+		if f.Id == known {
+			return known, len(found), nil
+		}
+	}
+	remember(key, id)
+	return id, len(found), nil
+}
+
+// recall and remember keep the folder choices in StateDB. They are best
+// effort: without them a choice is merely made again.
+func recall(key []byte) string {
+	db, err := openStateDB()
+	if err != nil {
+		return ""
+	}
+	defer db.Close()
+	var id string
+	db.View(func(tx *bolt.Tx) error {
+		if bk := tx.Bucket(foldersBucket); bk != nil {
+			id = string(bk.Get(key))
+		}
+		return nil
+	})
+	return id
+}
+
+func remember(key []byte, id string) {
+	db, err := openStateDB()
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists(foldersBucket)
+		if err != nil {
+			return err
+		}
+		return bk.Put(key, []byte(id))
+	})
+}
+
+// oldestFirst orders files by creation, ties broken by ID.
+func oldestFirst(files []*drive.File) {
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedTime != files[j].CreatedTime {
+			return files[i].CreatedTime < files[j].CreatedTime
+		}
+		return files[i].Id < files[j].Id
+	})
+}
+
+// DedupeOptions controls DedupeNames.
+type DedupeOptions struct {
+	// Merge moves the content of duplicate folders into the oldest one and
+	// trashes copies of files with the same content, instead of renaming
+	// them; files that differ are still renamed.
+	Merge bool
+	// DryRun only logs what would be done.
+	DryRun bool
+	// Log is told of every change, ops being "rename", "move" and "trash".
+	Log func(op, p string)
+}
+
+// DedupeNames makes the names within every folder below the Drive folder
+// id unique. Of entries sharing a name the oldest keeps it; the others are
+// renamed "a (2).txt" style, or merged into it with opt.Merge. It returns
+// the number of changes.
+func DedupeNames(ctx context.Context, srv *drive.Service, id string, opt DedupeOptions) (int, error) {
+	d := &deduper{ctx: ctx, srv: srv, opt: opt}
+	err := d.folder("", id)
+	return d.changes, err
+}
+
+type deduper struct {
+	ctx     context.Context
+	srv     *drive.Service
+	opt     DedupeOptions
+	changes int
+}
+
+func (d *deduper) list(id string) ([]*drive.File, error) {
+	var files []*drive.File
+	it := ListIter(d.ctx, d.srv, "'"+id+"' in parents and trashed=false", dedupeFields)
+	for it.Next() {
+		files = append(files, it.File())
+	}
+	return files, it.Err()
+}
+
+func (d *deduper) folder(dir string, id string) error {
+	files, err := d.list(id)
+	if err != nil {
+		return err
+	}
+	oldestFirst(files)
+	taken := map[string]bool{}
+	groups := map[string][]*drive.File{}
+	var names []string
+	for _, f := range files {
+		if groups[f.Name] == nil {
+			names = append(names, f.Name)
+		}
+		taken[f.Name] = true
+		groups[f.Name] = append(groups[f.Name], f)
+	}
+	var subfolders []*drive.File
+	for _, name := range names {
+		group := groups[name]
+		keep := group[0]
+		if keep.MimeType == FolderMime {
+			subfolders = append(subfolders, keep)
+		}
+		for _, f := range group[1:] {
+			p := path.Join(dir, f.Name)
+			folder := f.MimeType == FolderMime
+			switch {
+			case d.opt.Merge && folder && keep.MimeType == FolderMime:
+				err = d.merge(p, f, keep)
+			case d.opt.Merge && !folder && f.Md5Checksum != "" && f.Md5Checksum == keep.Md5Checksum:
+				err = d.trash(p, f)
+			default:
+				n := 2
+				for taken[numbered(name, n, folder)] {
+					n++
+				}
+				renamed := numbered(name, n, folder)
+				taken[renamed] = true
+				err = d.rename(p, f, renamed)
+				if folder {
+					subfolders = append(subfolders, &drive.File{Id: f.Id, Name: renamed})
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", p, err)
+			}
+		}
+	}
+	for _, f := range subfolders {
+		if err := d.folder(path.Join(dir, f.Name), f.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge moves the content of the folder f into keep and trashes f. What
+// then clashes within keep is dealt with when keep is visited.
+func (d *deduper) merge(p string, f *drive.File, keep *drive.File) error {
+	children, err := d.list(f.Id)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		d.log("move", path.Join(p, c.Name))
+		if d.opt.DryRun {
+			continue
+		}
+		_, err := d.srv.Files.Update(c.Id, &drive.File{}).AddParents(keep.Id).RemoveParents(f.Id).
+			Fields("id").Context(d.ctx).Do()
+		auditLocal("move", c.Id, c.Name, map[string]string{"reason": "dedupe", "path": path.Join(p, c.Name), "folder": keep.Id}, err)
+		if err != nil {
+			return err
+		}
+	}
+	return d.trash(p, f)
+}
+
+func (d *deduper) trash(p string, f *drive.File) error {
+	d.log("trash", p)
+	if d.opt.DryRun {
+		return nil
+	}
+	_, err := d.srv.Files.Update(f.Id, &drive.File{Trashed: true}).Fields("id").Context(d.ctx).Do()
+	auditLocal("trash", f.Id, f.Name, map[string]string{"reason": "dedupe", "path": p}, err)
+	return err
+}
+
+func (d *deduper) rename(p string, f *drive.File, name string) error {
+	d.log("rename", p+" -> "+name)
+	if d.opt.DryRun {
+		return nil
+	}
+	_, err := d.srv.Files.Update(f.Id, &drive.File{Name: name}).Fields("id").Context(d.ctx).Do()
+	auditLocal("rename", f.Id, f.Name, map[string]string{"reason": "dedupe", "path": p, "to": name}, err)
+	return err
+}
+
+func (d *deduper) log(op, p string) {
+	d.changes++
+	if d.opt.Log != nil {
+		d.opt.Log(op, p)
+	}
+}
@@ -0,0 +1,82 @@
+package magic
+
+import (
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// DiffReport lists how two Drive folders differ. Paths are slash
+// separated and relative to the folders.
+type DiffReport struct {
+	A       string      `json:"a"`
+	B       string      `json:"b"`
+	Matched int         `json:"matched"`
+	OnlyA   []string    `json:"onlyA"`
+	OnlyB   []string    `json:"onlyB"`
+	Differ  []DiffEntry `json:"differ"`
+}
+
+type DiffEntry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"` // "type", "size" or "checksum"
+	A      string `json:"a"`      // the differing value on each side
+	B      string `json:"b"`
+}
+
+// OK reports whether the folders hold the same files.
+func (r *DiffReport) OK() bool {
+	return len(r.OnlyA)+len(r.OnlyB)+len(r.Differ) == 0
+}
+
+// Diff compares the Drive folders a and b by name, size and the checksum
+// Drive computed, so no content is downloaded. The services may belong to
+// different accounts.
+func Diff(ctx context.Context, srvA *drive.Service, a string, srvB *drive.Service, b string) (*DiffReport, error) {
+	ta, err := RemoteTree(ctx, srvA, a)
+	if err != nil {
+		return nil, err
+	}
+	tb, err := RemoteTree(ctx, srvB, b)
+	if err != nil {
+		return nil, err
+	}
+	r := &DiffReport{A: a, B: b, OnlyA: []string{}, OnlyB: []string{}, Differ: []DiffEntry{}}
+	for p, fa := range ta {
+		fb, ok := tb[p]
+		if !ok {
+			r.OnlyA = append(r.OnlyA, p)
+			continue
+		}
+		if d, same := compareRemote(p, fa, fb); !same {
+			r.Differ = append(r.Differ, d)
+			continue
+		}
+		r.Matched++
+	}
+	for p := range tb {
+		if _, ok := ta[p]; !ok {
+			r.OnlyB = append(r.OnlyB, p)
+		}
+	}
+	sort.Strings(r.OnlyA)
+	sort.Strings(r.OnlyB)
+	sort.Slice(r.Differ, func(i, j int) bool { return r.Differ[i].Path < r.Differ[j].Path })
+	return r, nil
+}
+
+func compareRemote(p string, a, b *drive.File) (DiffEntry, bool) {
+	switch {
+	case a.MimeType != b.MimeType && (a.MimeType == FolderMime || b.MimeType == FolderMime):
+		return DiffEntry{Path: p, Reason: "type", A: a.MimeType, B: b.MimeType}, false
+	case a.MimeType == FolderMime || IsGoogleDoc(a) || IsGoogleDoc(b):
+		return DiffEntry{}, true
+	case a.Size != b.Size:
+		return DiffEntry{Path: p, Reason: "size", A: strconv.FormatInt(a.Size, 10), B: strconv.FormatInt(b.Size, 10)}, false
+	case a.Md5Checksum != b.Md5Checksum:
+		return DiffEntry{Path: p, Reason: "checksum", A: a.Md5Checksum, B: b.Md5Checksum}, false
+	}
+	return DiffEntry{}, true
+}
@@ -0,0 +1,162 @@
+package magic
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// Diagnosis is the outcome of one Doctor check; Fix suggests what to do
+// when it failed.
+type Diagnosis struct {
+	Check  string
+	OK     bool
+	Detail string
+	Fix    string
+}
+
+// googleHosts are the endpoints the tool talks to.
+var googleHosts = []string{"accounts.google.com", "oauth2.googleapis.com", "www.googleapis.com"}
+
+// maxSkew is the clock difference beyond which token validation breaks.
+const maxSkew = 2 * time.Minute
+
+// Doctor checks everything a headless deployment needs: the client secret
+// and cached token, a token refresh, clock skew, DNS and connectivity to
+// Google, free space in the local directory dir and, when folder is set,
+// write access to it through a small probe file. It never prompts.
+func Doctor(ctx context.Context, folder string, dir string) []Diagnosis {
+	var out []Diagnosis
+	add := func(check string, err error, detail, fix string) bool {
+		d := Diagnosis{Check: check, OK: err == nil, Detail: detail}
+		if err != nil {
+			d.Detail, d.Fix = err.Error(), fix
+		}
+		out = append(out, d)
+		return err == nil
+	}
+
+	for _, host := range googleHosts {
+		addrs, err := net.LookupHost(host)
+		if !add("dns "+host, err, strings.Join(addrs, ", "), "check /etc/resolv.conf or the DNS server of this network") {
+			continue
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, "443"), 10*time.Second)
+		if err == nil {
+			conn.Close()
+		}
+		add("connect "+host+":443", err, "reachable", "allow outbound HTTPS to Google, or set HTTPS_PROXY")
+	}
+
+	skew, err := clockSkew()
+	if err == nil && (skew > maxSkew || skew < -maxSkew) {
+		err = fmt.Errorf("local clock is off by %v", skew.Round(time.Second))
+	}
+	add("clock skew", err, fmt.Sprintf("%v", skew.Round(time.Second)), "sync the clock with NTP (timedatectl set-ntp true)")
+
+	free, err := freeSpace(dir)
+	add("disk space "+dir, err, formatBytes(free)+" free", "check that "+dir+" exists and is readable")
+
+	b, err := readSecret()
+	if !add("client secret "+SecretFile, err, "readable", "download the OAuth client JSON from the Google Cloud console to "+SecretFile) {
+		return out
+	}
+	config, err := google.ConfigFromJSON(b, drive.DriveScope)
+	if !add("client secret format", err, "valid", "the file must be an OAuth client ID JSON, not a service account key") {
+		return out
+	}
+	tok, scopes, err := loadToken()
+	if !add("cached token "+TokenFile, err, "present", "run the tool once interactively to authorize, then copy "+TokenFile) {
+		return out
+	}
+
+	// an expired copy forces the refresh a long run would do
+	stale := *tok
+	stale.Expiry = time.Now().Add(-time.Minute)
+	hc := &http.Client{Transport: Transport, Timeout: 30 * time.Second}
+	rctx := context.WithValue(ctx, oauth2.HTTPClient, hc)
+	fresh, err := config.TokenSource(rctx, &stale).Token()
+	fix := "the token was revoked or the client changed; delete " + TokenFile + " and authorize again"
+	if skewed, ok := ExplainAuthError(err).(*ClockSkewError); ok {
+		err, fix = skewed, "sync the clock with NTP (timedatectl set-ntp true)"
+	}
+	if !add("token refresh", err, "refreshed", fix) {
+		return out
+	}
+	if fresh.AccessToken != tok.AccessToken && os.Getenv(TokenEnv) == "" {
+		if unlock, err := lockToken(); err == nil {
+			saveToken(TokenFile, fresh, scopes)
+			unlock()
+		}
+	}
+
+	srv, err := Service(config.Client(rctx, fresh))
+	if err != nil {
+		add("drive service", err, "", "")
+		return out
+	}
+	about, err := srv.About.Get().Fields("user(emailAddress), storageQuota(limit, usage)").Context(ctx).Do()
+	detail := ""
+	if err == nil {
+		detail = about.User.EmailAddress
+		if q := about.StorageQuota; q != nil && q.Limit > 0 {
+			detail += fmt.Sprintf(", %s of %s used", formatBytes(uint64(q.Usage)), formatBytes(uint64(q.Limit)))
+		}
+	}
+	add("drive api", err, detail, "enable the Drive API for the project in the Google Cloud console")
+
+	if folder != "" {
+		add("write "+folder, probeWrite(ctx, srv, folder), "probe file created and removed",
+			"share the folder with the authorized account as editor, or check the folder ID")
+	}
+	return out
+}
+
+// clockSkew compares the local clock with Google's Date header; positive
+// means the local clock is ahead.
+func clockSkew() (time.Duration, error) {
+	c := &http.Client{Transport: Transport, Timeout: 15 * time.Second}
+	before := time.Now()
+	res, err := c.Head("https://www.googleapis.com/")
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	remote, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("no usable Date header: %v", err)
+	}
+	local := before.Add(time.Since(before) / 2)
+	return local.Sub(remote), nil
+}
+
+func probeWrite(ctx context.Context, srv *drive.Service, folder string) error {
+	name := fmt.Sprintf(".magicserver-doctor-%d", time.Now().UnixNano())
+	f, err := srv.Files.Create(&drive.File{Name: name, Parents: []string{folder}}).
+		Media(strings.NewReader("ok")).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return srv.Files.Delete(f.Id).Context(ctx).Do()
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package magic
+
+import "errors"
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package magic
+
+import "golang.org/x/sys/unix"
+
+func freeSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,192 @@
+package magic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// DownloadOptions tune Download. The zero value fetches in a single
+// unthrottled stream without restoring POSIX attributes.
+type DownloadOptions struct {
+	// Posix restores attributes stored by PosixProperties.
+	Posix bool
+	// Streams splits files of at least StreamMin bytes into that many
+	// concurrent ranged requests.
+	Streams int
+	// Bandwidth caps the combined rate of all streams.
+	Bandwidth *Bandwidth
+	// Checksum, when set, verifies the written file against Drive's
+	// digest for that algorithm.
+	Checksum string
+	// Identities decrypt content encrypted to them; encrypted files are
+	// written as they are without.
+	Identities []*Identity
+	// Progress, when set, is told how the download goes.
+	Progress ProgressReporter
+
+	tracker *Tracker
+}
+
+// StreamMin is the smallest file split into ranged streams; below it the
+// extra requests cost more than they gain.
+var StreamMin int64 = 64 << 20
+
+// Download fetches the Drive file id into dest. Preserved symlinks and
+// packed sparse files are recreated; with opt.Posix set, attributes
+// stored by PosixProperties are restored as well.
+func Download(srv *drive.Service, id string, dest string, opt DownloadOptions) (*drive.File, error) {
+	opt.tracker = newTracker(opt.Progress, TransferInfo{Name: dest, ID: id})
+	x := &TransferJob{TransferInfo: TransferInfo{Name: dest, ID: id, Size: -1}}
+	f, err := RunTransfer(context.Background(), x, func(ctx context.Context, x *TransferJob) (*drive.File, error) {
+		return download(srv, x.ID, x.Name, opt)
+	})
+	err = Classify(err)
+	opt.tracker.Done(f, err)
+	return f, err
+}
+
+func download(srv *drive.Service, id string, dest string, opt DownloadOptions) (*drive.File, error) {
+	dest = LongPath(dest)
+	f, err := srv.Files.Get(id).Fields(FileFields + ", appProperties").Do()
+	if err != nil {
+		return nil, err
+	}
+	opt.tracker.start(f.Size)
+	if target, ok := PosixLink(f.AppProperties); ok {
+		os.Remove(dest)
+		return f, os.Symlink(target, dest)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return nil, err
+	}
+	sparse := f.AppProperties[SparseKey] == "1"
+	switch {
+	case !sparse && opt.Streams > 1 && f.Size >= StreamMin:
+		err = downloadRanges(srv, id, out, f.Size, opt)
+	case !sparse && f.Size > 0:
+		// one range, continued after network failures
+		err = downloadRange(srv, id, out, 0, f.Size, opt)
+	default:
+		var res *http.Response
+		if res, err = srv.Files.Get(id).Download(); err == nil {
+			body := opt.tracker.reader(opt.Bandwidth.Reader(res.Body))
+			if sparse {
+				err = RestoreSparse(out, body)
+			} else {
+				_, err = io.Copy(out, body)
+			}
+			res.Body.Close()
+		}
+	}
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err = out.Close(); err != nil {
+		return nil, err
+	}
+	if opt.Checksum != "" && !sparse {
+		if err = Verify(dest, f, opt.Checksum); err != nil {
+			return f, err
+		}
+	}
+	if IsEncrypted(f) && len(opt.Identities) > 0 {
+		if err = DecryptFile(dest, opt.Identities, f.AppProperties); err != nil {
+			return f, fmt.Errorf("unable to decrypt %s: %v", f.Name, err)
+		}
+	}
+	if opt.Posix {
+		err = RestorePosix(dest, f.AppProperties)
+	}
+	return f, err
+}
+
+// downloadRanges fills out with size bytes of id, fetched as opt.Streams
+// concurrent byte ranges.
+func downloadRanges(srv *drive.Service, id string, out *os.File, size int64, opt DownloadOptions) error {
+	if err := out.Truncate(size); err != nil {
+		return err
+	}
+	part := (size + int64(opt.Streams) - 1) / int64(opt.Streams)
+	errs := make(chan error, opt.Streams)
+	var wg sync.WaitGroup
+	for off := int64(0); off < size; off += part {
+		end := off + part
+		if end > size {
+			end = size
+		}
+		wg.Add(1)
+		go func(off, end int64) {
+			defer wg.Done()
+			if err := downloadRange(srv, id, out, off, end, opt); err != nil {
+				errs <- err
+			}
+		}(off, end)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// downloadRange fetches the bytes off to end of id into out. After a
+// network failure or a stall the rest of the range is asked for again.
+func downloadRange(srv *drive.Service, id string, out *os.File, off, end int64, opt DownloadOptions) error {
+	w := &sectionWriter{f: out, off: off, t: opt.tracker}
+	for start, stalled := time.Now(), 0; ; {
+		call := srv.Files.Get(id)
+		call.Header().Set("Range", "bytes="+strconv.FormatInt(w.off, 10)+"-"+strconv.FormatInt(end-1, 10))
+		waitTransfers()
+		ctx, watch := watchStall(context.Background())
+		from := w.off
+		res, err := call.Context(ctx).Download()
+		if err == nil {
+			_, err = io.Copy(w, watch.reader(opt.Bandwidth.Reader(res.Body)))
+			res.Body.Close()
+		}
+		err = watch.err(err)
+		watch.cancel()
+		if errors.Is(err, ErrStalled) {
+			if w.off > from {
+				stalled = 0
+			}
+			if stalled++; stalled > stallRetries {
+				return err
+			}
+			start = time.Now()
+		}
+		if !NetworkError(err) {
+			if err == nil && w.off != end {
+				err = fmt.Errorf("short range %d-%d: got %d bytes", off, end-1, w.off-off)
+			}
+			return err
+		}
+		if err = rideOut(start, err); err != nil {
+			return err
+		}
+	}
+}
+
+// sectionWriter writes sequentially into f starting at off.
+type sectionWriter struct {
+	f   *os.File
+	off int64
+	t   *Tracker
+}
+
+func (w *sectionWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	w.t.add(n)
+	return n, err
+}
@@ -0,0 +1,291 @@
+package magic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+)
+
+// SecretFile and TokenFile locate the OAuth client secret and the cached
+// token, relative to the working directory.
+var (
+	SecretFile = "client_secret.json"
+	TokenFile  = filepath.Join(".credentials", "drive-api-cert.json")
+	// CredentialsFile, when set, is an external account (workload identity
+	// federation) config used instead of the client secret and token. It
+	// trades the AWS, Azure, GCP or OIDC identity of the machine for
+	// short-lived Google tokens.
+	CredentialsFile = ""
+)
+
+// SecretEnv and TokenEnv name environment variables holding the client
+// secret and token JSON themselves, which take precedence over SecretFile
+// and TokenFile. A token given this way is refreshed in memory only.
+const (
+	SecretEnv = "GDRIVE_CLIENT_SECRET_JSON"
+	TokenEnv  = "GDRIVE_TOKEN_JSON"
+)
+
+// AuthFlow picks how a new token is obtained: AuthFlowCode has the user
+// paste a code from the browser, AuthFlowDevice shows a short code to enter
+// on another device. The device flow needs an OAuth client of the "TVs and
+// Limited Input devices" type, and Google allows it drive.file but not the
+// full Drive scope.
+var AuthFlow = AuthFlowCode
+
+const (
+	AuthFlowCode   = "code"
+	AuthFlowDevice = "device"
+)
+
+const FolderMime = "application/vnd.google-apps.folder"
+
+// FileFields is the partial response asked for on Get and Create calls:
+// only what callers actually read, which keeps responses small and cheap.
+const FileFields = "id, name, size, md5Checksum, sha1Checksum, sha256Checksum, mimeType, modifiedTime"
+
+// Client builds an authorized Drive http client from CredentialsFile, or
+// else from the client secret and cached token. Without scopes only
+// drive.file is asked for, which reaches the files the tool created
+// itself. When no token is cached yet, or the cached one was granted fewer
+// scopes than asked for, the user is asked to authorize on stdin.
+func Client(ctx context.Context, scope ...string) (*http.Client, error) {
+	if len(scope) == 0 {
+		scope = []string{drive.DriveFileScope}
+	}
+	if CredentialsFile != "" {
+		return federatedClient(ctx, scope)
+	}
+	b, err := readSecret()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+	}
+	config, err := google.ConfigFromJSON(b, scope...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	tok, granted, err := loadToken()
+	if err != nil && tokenSealed {
+		// authorizing again would replace the token the passphrase guards
+		return nil, err
+	}
+	if err == nil && !covers(granted, scope) && os.Getenv(TokenEnv) != "" {
+		return nil, fmt.Errorf("the token in %s lacks scopes %s", TokenEnv, strings.Join(missing(granted, scope), " "))
+	}
+	if err == nil && !covers(granted, scope) {
+		// keep what was granted before, so alternating commands do not
+		// ask for consent every time
+		config.Scopes = union(granted, scope)
+		fmt.Printf("The cached token lacks scopes %s; authorize again.\n", strings.Join(missing(granted, scope), " "))
+		tok = nil
+	}
+	if tok == nil {
+		if AuthFlow == AuthFlowDevice {
+			tok, granted, err = tokenFromDevice(ctx, config)
+		} else {
+			tok, granted, err = tokenFromWeb(config)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !covers(granted, scope) {
+			return nil, fmt.Errorf("scopes %s were not granted", strings.Join(missing(granted, scope), " "))
+		}
+		if err = saveToken(TokenFile, tok, granted); err != nil {
+			return nil, err
+		}
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: Transport})
+	return tokenClient(ctx, config, tok, granted), nil
+}
+
+// federatedClient authorizes with CredentialsFile. No token is cached:
+// the external identity is exchanged again whenever the token expires.
+func federatedClient(ctx context.Context, scope []string) (*http.Client, error) {
+	b, err := ioutil.ReadFile(CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file: %v", err)
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: Transport})
+	creds, err := google.CredentialsFromJSONWithType(ctx, b, google.ExternalAccount, scope...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file: %v", err)
+	}
+	return oauth2.NewClient(ctx, creds.TokenSource), nil
+}
+
+// covers reports whether the granted scopes include every wanted one. The
+// full Drive scope includes drive.file.
+func covers(granted []string, want []string) bool {
+	return len(missing(granted, want)) == 0
+}
+
+func missing(granted []string, want []string) []string {
+	has := map[string]bool{}
+	for _, s := range granted {
+		has[s] = true
+	}
+	if has[drive.DriveScope] {
+		has[drive.DriveFileScope] = true
+	}
+	var out []string
+	for _, s := range want {
+		if !has[s] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func union(a []string, b []string) []string {
+	return append(append([]string{}, a...), missing(a, b)...)
+}
+
+// Transport keeps plenty of idle connections to Google around, so
+// concurrent transfers reuse them instead of paying a TLS handshake each.
+// Calls made with a WithRequestID context carry the id, and changes the
+// guard set by SetGuard refuses are never sent. What is sent is logged
+// with DebugHTTP.
+var Transport http.RoundTripper = requestIDTransport{base: guardTransport{base: debugTransport{base: &http.Transport{
+	Proxy:               http.ProxyFromEnvironment,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 32,
+	IdleConnTimeout:     90 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+}}}}
+
+// Service wraps an authorized client into a Drive v3 service.
+func Service(client *http.Client) (*drive.Service, error) {
+	return drive.New(client)
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(Classify(err), ErrNotFound)
+}
+
+func tokenFromWeb(config *oauth2.Config) (*oauth2.Token, []string, error) {
+	// prompt=consent makes Google issue a refresh token again when the
+	// client was authorized before
+	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.ApprovalForce,
+		oauth2.SetAuthURLParam("include_granted_scopes", "true"))
+	fmt.Printf("Go to the following link in your browser then type the "+
+		"authorization code: \n%v\n", authURL)
+
+	var code string
+	if _, err := fmt.Scan(&code); err != nil {
+		return nil, nil, fmt.Errorf("unable to read authorization code: %v", err)
+	}
+	tok, err := config.Exchange(oauth2.NoContext, code)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve token from web: %v", err)
+	}
+	return tok, grantedScopes(tok, config.Scopes), nil
+}
+
+func tokenFromDevice(ctx context.Context, config *oauth2.Config) (*oauth2.Token, []string, error) {
+	// client secret files carry no device endpoint
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+	hctx := context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: Transport})
+	da, err := config.DeviceAuth(hctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to start device authorization: %v", err)
+	}
+	fmt.Printf("On any device, go to %v and enter the code %v\n", da.VerificationURI, da.UserCode)
+	tok, err := config.DeviceAccessToken(hctx, da)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to retrieve token by device authorization: %v", err)
+	}
+	return tok, grantedScopes(tok, config.Scopes), nil
+}
+
+// grantedScopes reads the scopes the user actually approved from the token
+// response, which can be fewer than asked for.
+func grantedScopes(tok *oauth2.Token, asked []string) []string {
+	if s, ok := tok.Extra("scope").(string); ok && s != "" {
+		return strings.Fields(s)
+	}
+	return asked
+}
+
+// cachedToken is the format of TokenFile: the token and the scopes it was
+// granted.
+type cachedToken struct {
+	*oauth2.Token
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func readSecret() ([]byte, error) {
+	if s := os.Getenv(SecretEnv); s != "" {
+		return []byte(s), nil
+	}
+	return ioutil.ReadFile(SecretFile)
+}
+
+// loadToken returns the token from TokenEnv, or else the cached one.
+func loadToken() (*oauth2.Token, []string, error) {
+	if s := os.Getenv(TokenEnv); s != "" {
+		return decodeToken(strings.NewReader(s))
+	}
+	return tokenFromFile(TokenFile)
+}
+
+func tokenFromFile(file string) (*oauth2.Token, []string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b, err = openToken(b); err != nil {
+		return nil, nil, err
+	}
+	return decodeToken(bytes.NewReader(b))
+}
+
+// decodeToken reads a token and its scopes. Tokens saved before scopes
+// were recorded got the full Drive scope, the only one asked for back
+// then.
+func decodeToken(r io.Reader) (*oauth2.Token, []string, error) {
+	t := cachedToken{Token: &oauth2.Token{}}
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, nil, err
+	}
+	if len(t.Scopes) == 0 {
+		t.Scopes = []string{drive.DriveScope}
+	}
+	return t.Token, t.Scopes, nil
+}
+
+func saveToken(file string, token *oauth2.Token, scopes []string) error {
+	b, err := json.Marshal(cachedToken{Token: token, Scopes: scopes})
+	if err != nil {
+		return err
+	}
+	if TokenEncrypt || tokenSealed {
+		if b, err = sealToken(b); err != nil {
+			return fmt.Errorf("unable to encrypt oauth token: %v", err)
+		}
+	}
+	os.MkdirAll(filepath.Dir(file), 0700)
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, append(b, '\n'), 0600); err == nil {
+		err = os.Rename(tmp, file)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package magic
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Usage is the storage taken by a Drive folder tree.
+type Usage struct {
+	ID      string
+	Path    string // relative to the folder DiskUsage was asked about
+	Bytes   int64  // of the files below, at any depth
+	Files   int
+	Folders int
+	Subs    []*Usage // by name
+}
+
+// duFields are all a usage listing fetches.
+const duFields = "id, name, mimeType, size"
+
+// DiskUsage adds up the files below the Drive folder id, listing up to
+// parallel folders at once. A folder reached twice, through a second
+// parent, is counted once.
+func DiskUsage(ctx context.Context, srv *drive.Service, id string, parallel int) (*Usage, error) {
+	if parallel < 1 {
+		parallel = 1
+	}
+	root := &Usage{ID: id}
+	var (
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, parallel)
+		mu    sync.Mutex
+		seen  = map[string]bool{id: true}
+		first error
+	)
+	var list func(u *Usage)
+	list = func(u *Usage) {
+		defer wg.Done()
+		sem <- struct{}{}
+		defer func() { <-sem }()
+		it := ListIter(ctx, srv, "'"+u.ID+"' in parents and trashed=false", duFields)
+		for it.Next() {
+			f := it.File()
+			if f.MimeType != FolderMime {
+				u.Files++
+				u.Bytes += f.Size
+				continue
+			}
+			mu.Lock()
+			dup := seen[f.Id]
+			seen[f.Id] = true
+			mu.Unlock()
+			if dup {
+				continue
+			}
+			sub := &Usage{ID: f.Id, Path: path.Join(u.Path, Names.Display(f.Name))}
+			u.Subs = append(u.Subs, sub)
+			wg.Add(1)
+			go list(sub)
+		}
+		if err := it.Err(); err != nil {
+			mu.Lock()
+			if first == nil {
+				first = err
+			}
+			mu.Unlock()
+		}
+	}
+	wg.Add(1)
+	list(root)
+	wg.Wait()
+	if first != nil {
+		return nil, first
+	}
+	root.sum()
+	return root, nil
+}
+
+// sum adds the subfolders into u.
+func (u *Usage) sum() {
+	sort.Slice(u.Subs, func(i, j int) bool { return u.Subs[i].Path < u.Subs[j].Path })
+	for _, s := range u.Subs {
+		s.sum()
+		u.Bytes += s.Bytes
+		u.Files += s.Files
+		u.Folders += s.Folders + 1
+	}
+}
+
+// Walk calls fn for u and the subfolders down to depth levels below it,
+// subfolders first as du does; a negative depth has no limit.
+func (u *Usage) Walk(depth int, fn func(u *Usage, level int)) {
+	u.walk(0, depth, fn)
+}
+
+func (u *Usage) walk(level, depth int, fn func(*Usage, int)) {
+	if depth < 0 || level < depth {
+		for _, s := range u.Subs {
+			s.walk(level+1, depth, fn)
+		}
+	}
+	fn(u, level)
+}
+
+// BigFile is a file of a Biggest report.
+type BigFile struct {
+	Path string // below the folder asked about; the name for the whole Drive
+	File *drive.File
+}
+
+// Biggest returns the top largest files below the Drive folder id, or of
+// all the user owns when id is empty, largest first. The whole Drive is
+// ordered by Drive itself, by storage used with revisions; a folder is
+// listed in full and ordered by size.
+func Biggest(ctx context.Context, srv *drive.Service, id string, top int) ([]BigFile, error) {
+	if id == "" {
+		if top > listPageSize {
+			top = listPageSize
+		}
+		r, err := srv.Files.List().Q("'me' in owners and trashed=false and mimeType!='" + FolderMime + "'").
+			OrderBy("quotaBytesUsed desc").PageSize(int64(top)).
+			Fields("files(" + FileFields + ", quotaBytesUsed)").Context(ctx).Do()
+		if err != nil {
+			return nil, Classify(err)
+		}
+		list := make([]BigFile, len(r.Files))
+		for i, f := range r.Files {
+			list[i] = BigFile{Path: Names.Display(f.Name), File: f}
+		}
+		return list, nil
+	}
+	tree, err := RemoteTree(ctx, srv, id)
+	if err != nil {
+		return nil, err
+	}
+	var list []BigFile
+	for p, f := range tree {
+		if f.MimeType != FolderMime {
+			list = append(list, BigFile{Path: displayPath(p), File: f})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].File.Size != list[j].File.Size {
+			return list[i].File.Size > list[j].File.Size
+		}
+		return list[i].Path < list[j].Path
+	})
+	if len(list) > top {
+		list = list[:top]
+	}
+	return list, nil
+}
+
+// displayPath shows each name of the slash separated path p decrypted.
+func displayPath(p string) string {
+	names := strings.Split(p, "/")
+	for i, n := range names {
+		names[i] = Names.Display(n)
+	}
+	return strings.Join(names, "/")
+}
@@ -0,0 +1,560 @@
+package magic
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Content encryption works like age: a random file key encrypts the
+// content in chunks with ChaCha20-Poly1305 and is wrapped to the X25519
+// key of every recipient. The wrapped keys are written into the header of
+// the content, so a file downloaded by any means can be opened, and into
+// appProperties, where a rekey changes the recipients without uploading
+// the content again and which take precedence over the header.
+
+const (
+	encVersion   = "magicserver-encryption/v1"
+	encChunk     = 64 << 10
+	fileKeySize  = 16
+	stanzaPrefix = "-> X25519 "
+
+	// EncryptedKey is the appProperty marking encrypted content.
+	EncryptedKey = "mgenc"
+	// stanzaKey prefixes the appProperties holding the wrapped keys,
+	// numbered from 0.
+	stanzaKey = "mgk"
+
+	recipientPrefix = "magicpub1"
+	identityPrefix  = "MAGIC-SECRET-KEY-1"
+)
+
+// ErrNoIdentity is returned when none of the identities given can unwrap
+// the file key.
+var ErrNoIdentity = errors.New("no identity matches any recipient of the file")
+
+var (
+	keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	b64         = base64.RawStdEncoding
+)
+
+// Recipient is a public key content is encrypted to.
+type Recipient struct {
+	pub []byte
+}
+
+func (r *Recipient) String() string {
+	return recipientPrefix + strings.ToLower(keyEncoding.EncodeToString(r.pub))
+}
+
+// Identity is the private key of a Recipient.
+type Identity struct {
+	priv []byte
+	pub  []byte
+}
+
+// GenerateIdentity makes a new random Identity.
+func GenerateIdentity() (*Identity, error) {
+	priv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(priv); err != nil {
+		return nil, err
+	}
+	return newIdentity(priv)
+}
+
+func newIdentity(priv []byte) (*Identity, error) {
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{priv: priv, pub: pub}, nil
+}
+
+func (id *Identity) String() string {
+	return identityPrefix + keyEncoding.EncodeToString(id.priv)
+}
+
+// Recipient returns the public half of id.
+func (id *Identity) Recipient() *Recipient {
+	return &Recipient{pub: id.pub}
+}
+
+// ParseRecipient reads a key written by Recipient.String.
+func ParseRecipient(s string) (*Recipient, error) {
+	b, err := parseKey(s, recipientPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient %q", s)
+	}
+	return &Recipient{pub: b}, nil
+}
+
+// ParseIdentity reads a key written by Identity.String.
+func ParseIdentity(s string) (*Identity, error) {
+	b, err := parseKey(s, identityPrefix)
+	if err != nil {
+		return nil, errors.New("invalid identity")
+	}
+	return newIdentity(b)
+}
+
+func parseKey(s string, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(strings.ToUpper(s), strings.ToUpper(prefix)) {
+		return nil, errors.New("wrong prefix")
+	}
+	b, err := keyEncoding.DecodeString(strings.ToUpper(s[len(prefix):]))
+	if err != nil || len(b) != curve25519.PointSize {
+		return nil, errors.New("bad key")
+	}
+	return b, nil
+}
+
+// LoadIdentities reads the identities in file, one per line; empty lines
+// and lines starting with # are skipped.
+func LoadIdentities(file string) ([]*Identity, error) {
+	lines, err := keyLines(file)
+	if err != nil {
+		return nil, err
+	}
+	var ids []*Identity
+	for _, l := range lines {
+		id, err := ParseIdentity(l)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%s holds no identity", file)
+	}
+	return ids, nil
+}
+
+// ParseRecipients reads each of args as a recipient, or else as a file of
+// recipients or identities, one per line, identities standing for their
+// recipients.
+func ParseRecipients(args []string) ([]*Recipient, error) {
+	var out []*Recipient
+	for _, a := range args {
+		if r, err := ParseRecipient(a); err == nil {
+			out = append(out, r)
+			continue
+		}
+		lines, err := keyLines(a)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither a recipient nor a readable file", a)
+		}
+		for _, l := range lines {
+			if id, err := ParseIdentity(l); err == nil {
+				out = append(out, id.Recipient())
+				continue
+			}
+			r, err := ParseRecipient(l)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", a, err)
+			}
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+func keyLines(file string) ([]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// stanza is the file key wrapped to one recipient.
+type stanza struct {
+	eph     []byte // ephemeral public key
+	wrapped []byte
+}
+
+func (s stanza) String() string {
+	return b64.EncodeToString(s.eph) + " " + b64.EncodeToString(s.wrapped)
+}
+
+func parseStanza(v string) (stanza, error) {
+	f := strings.Fields(v)
+	if len(f) != 2 {
+		return stanza{}, errors.New("malformed key stanza")
+	}
+	eph, err := b64.DecodeString(f[0])
+	if err != nil || len(eph) != curve25519.PointSize {
+		return stanza{}, errors.New("malformed key stanza")
+	}
+	wrapped, err := b64.DecodeString(f[1])
+	if err != nil || len(wrapped) != fileKeySize+chacha20poly1305.Overhead {
+		return stanza{}, errors.New("malformed key stanza")
+	}
+	return stanza{eph, wrapped}, nil
+}
+
+func wrapKey(fileKey []byte, r *Recipient) (stanza, error) {
+	ephPriv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(ephPriv); err != nil {
+		return stanza{}, err
+	}
+	eph, err := curve25519.X25519(ephPriv, curve25519.Basepoint)
+	if err != nil {
+		return stanza{}, err
+	}
+	shared, err := curve25519.X25519(ephPriv, r.pub)
+	if err != nil {
+		return stanza{}, err
+	}
+	aead, err := chacha20poly1305.New(derive(shared, append(append([]byte{}, eph...), r.pub...), "magicserver X25519"))
+	if err != nil {
+		return stanza{}, err
+	}
+	return stanza{eph: eph, wrapped: aead.Seal(nil, make([]byte, aead.NonceSize()), fileKey, nil)}, nil
+}
+
+// unwrap returns the file key of s, or nil when s is not for id.
+func (id *Identity) unwrap(s stanza) []byte {
+	shared, err := curve25519.X25519(id.priv, s.eph)
+	if err != nil {
+		return nil
+	}
+	aead, err := chacha20poly1305.New(derive(shared, append(append([]byte{}, s.eph...), id.pub...), "magicserver X25519"))
+	if err != nil {
+		return nil
+	}
+	key, err := aead.Open(nil, make([]byte, aead.NonceSize()), s.wrapped, nil)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+func derive(secret []byte, salt []byte, info string) []byte {
+	k := make([]byte, chacha20poly1305.KeySize)
+	io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), k)
+	return k
+}
+
+func unwrapAny(stanzas []stanza, ids []*Identity) ([]byte, error) {
+	for _, s := range stanzas {
+		for _, id := range ids {
+			if key := id.unwrap(s); key != nil {
+				return key, nil
+			}
+		}
+	}
+	return nil, ErrNoIdentity
+}
+
+// Envelope is the file key of one encrypted file, wrapped to its
+// recipients.
+type Envelope struct {
+	key     []byte
+	stanzas []stanza
+}
+
+// NewEnvelope makes a new file key for recipients to.
+func NewEnvelope(to []*Recipient) (*Envelope, error) {
+	if len(to) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	env := &Envelope{key: make([]byte, fileKeySize)}
+	if _, err := rand.Read(env.key); err != nil {
+		return nil, err
+	}
+	return env, env.wrap(to)
+}
+
+func (env *Envelope) wrap(to []*Recipient) error {
+	env.stanzas = nil
+	for _, r := range to {
+		s, err := wrapKey(env.key, r)
+		if err != nil {
+			return err
+		}
+		env.stanzas = append(env.stanzas, s)
+	}
+	return nil
+}
+
+// Properties returns the appProperties marking a file encrypted with env.
+func (env *Envelope) Properties() map[string]string {
+	p := map[string]string{EncryptedKey: "1"}
+	for i, s := range env.stanzas {
+		p[stanzaKey+strconv.Itoa(i)] = s.String()
+	}
+	return p
+}
+
+// stanzasOf returns the wrapped keys in the appProperties props.
+func stanzasOf(props map[string]string) ([]stanza, error) {
+	var out []stanza
+	for i := 0; ; i++ {
+		v, ok := props[stanzaKey+strconv.Itoa(i)]
+		if !ok {
+			return out, nil
+		}
+		s, err := parseStanza(v)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+}
+
+// Encrypt writes the encryption of plain under env to w.
+func (env *Envelope) Encrypt(w io.Writer, plain io.Reader) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, encVersion)
+	for _, s := range env.stanzas {
+		fmt.Fprintln(bw, stanzaPrefix+s.String())
+	}
+	fmt.Fprintln(bw, "---")
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	bw.Write(nonce)
+	aead, err := chacha20poly1305.New(derive(env.key, nonce, "payload"))
+	if err != nil {
+		return err
+	}
+	in := bufio.NewReaderSize(plain, encChunk)
+	buf := make([]byte, encChunk)
+	out := make([]byte, 0, encChunk+chacha20poly1305.Overhead)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(in, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		if !last {
+			if _, err := in.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if _, err := bw.Write(aead.Seal(out[:0], chunkNonce(counter, last), buf[:n], nil)); err != nil {
+			return err
+		}
+		if last {
+			return bw.Flush()
+		}
+	}
+}
+
+// EncryptReader returns the encryption of plain under env as it is read.
+func (env *Envelope) EncryptReader(plain io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(env.Encrypt(pw, plain))
+	}()
+	return pr
+}
+
+// chunkNonce numbers the chunks and flags the last, so chunks can be
+// neither reordered nor dropped from the end.
+func chunkNonce(counter uint64, last bool) []byte {
+	n := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(n[3:11], counter)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+// Decrypt writes the plain content of the encrypted r to w, unwrapping
+// its file key with one of ids. The wrapped keys in props, the file's
+// appProperties, are used when present and those in the header otherwise.
+func Decrypt(w io.Writer, r io.Reader, ids []*Identity, props map[string]string) error {
+	in := bufio.NewReaderSize(r, encChunk+chacha20poly1305.Overhead)
+	line, err := in.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != encVersion {
+		return errors.New("not encrypted content")
+	}
+	var header []stanza
+	for {
+		line, err = in.ReadString('\n')
+		if err != nil {
+			return errors.New("truncated encryption header")
+		}
+		line = strings.TrimSpace(line)
+		if line == "---" {
+			break
+		}
+		if !strings.HasPrefix(line, stanzaPrefix) {
+			return errors.New("malformed encryption header")
+		}
+		s, err := parseStanza(strings.TrimPrefix(line, stanzaPrefix))
+		if err != nil {
+			return err
+		}
+		header = append(header, s)
+	}
+	stanzas, err := stanzasOf(props)
+	if err != nil {
+		return err
+	}
+	if len(stanzas) == 0 {
+		stanzas = header
+	}
+	key, err := unwrapAny(stanzas, ids)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 16)
+	if _, err = io.ReadFull(in, nonce); err != nil {
+		return errors.New("truncated encrypted content")
+	}
+	aead, err := chacha20poly1305.New(derive(key, nonce, "payload"))
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, encChunk+chacha20poly1305.Overhead)
+	out := make([]byte, 0, encChunk)
+	for counter := uint64(0); ; counter++ {
+		n, err := io.ReadFull(in, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		if !last {
+			if _, err := in.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return err
+			}
+		}
+		plain, err := aead.Open(out[:0], chunkNonce(counter, last), buf[:n], nil)
+		if err != nil {
+			return errors.New("encrypted content is damaged or truncated")
+		}
+		if _, err = w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// DecryptFile decrypts the local file p in place.
+func DecryptFile(p string, ids []*Identity, props map[string]string) error {
+	in, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := p + ".decrypting"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = Decrypt(out, in, ids, props)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	in.Close()
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// IsEncrypted reports whether f holds content encrypted by this tool,
+// going by its appProperties.
+func IsEncrypted(f *drive.File) bool {
+	return f.AppProperties[EncryptedKey] == "1"
+}
+
+// Rekey wraps the file keys of the encrypted files at or below the Drive
+// file or folder id to the recipients to instead of the current ones,
+// unwrapping them with one of ids, and returns the number of files
+// changed. Only appProperties are written; the content, and the keys in
+// its header, stay as they were. A recipient removed thus can no longer
+// get the key from Drive, but whoever unwrapped it before, or kept the
+// header, still has it: re-upload to revoke that.
+func Rekey(ctx context.Context, srv *drive.Service, id string, ids []*Identity, to []*Recipient, log func(name string, err error)) (int, error) {
+	if len(to) == 0 {
+		return 0, errors.New("no recipients")
+	}
+	const fields = "id, name, mimeType, appProperties"
+	f, err := srv.Files.Get(id).Fields(fields).Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	files := []*drive.File{f}
+	n := 0
+	for len(files) > 0 {
+		f, files = files[0], files[1:]
+		if f.MimeType == FolderMime {
+			it := ListIter(ctx, srv, "'"+f.Id+"' in parents and trashed=false", fields)
+			for it.Next() {
+				files = append(files, it.File())
+			}
+			if err := it.Err(); err != nil {
+				return n, err
+			}
+			continue
+		}
+		if !IsEncrypted(f) {
+			continue
+		}
+		err := rekeyFile(ctx, srv, f, ids, to)
+		if log != nil {
+			log(f.Name, err)
+		}
+		if err == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func rekeyFile(ctx context.Context, srv *drive.Service, f *drive.File, ids []*Identity, to []*Recipient) error {
+	old, err := stanzasOf(f.AppProperties)
+	if err != nil {
+		return err
+	}
+	key, err := unwrapAny(old, ids)
+	if err != nil {
+		return err
+	}
+	env := &Envelope{key: key}
+	if err = env.wrap(to); err != nil {
+		return err
+	}
+	upd := &drive.File{AppProperties: env.Properties()}
+	for i := len(env.stanzas); i < len(old); i++ {
+		upd.NullFields = append(upd.NullFields, "AppProperties."+stanzaKey+strconv.Itoa(i))
+	}
+	_, err = srv.Files.Update(f.Id, upd).Fields("id").Context(ctx).Do()
+	return err
+}
@@ -0,0 +1,86 @@
+package magic
+
+import (
+	"errors"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Failures of Drive calls are sorted into these, so callers branch with
+// errors.Is instead of on codes and messages; errors.As still finds the
+// *googleapi.Error underneath.
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrRateLimited      = errors.New("rate limited")
+)
+
+// APIError is a Drive failure of the Kind, one of the errors above, or
+// nil for failures of no known kind.
+type APIError struct {
+	Op   string // what was being done, if told
+	Kind error
+	Err  error // the *googleapi.Error, or what wraps it
+}
+
+func (e *APIError) Error() string {
+	if e.Op == "" {
+		return e.Err.Error()
+	}
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+// Is matches the Kind of e.
+func (e *APIError) Is(target error) bool { return target == e.Kind }
+
+// Classify wraps err in an APIError when it holds a *googleapi.Error of a
+// known kind, and returns it unchanged otherwise.
+func Classify(err error) error {
+	var ae *APIError
+	var ge *googleapi.Error
+	if err == nil || errors.As(err, &ae) || !errors.As(err, &ge) {
+		return err
+	}
+	if kind := kindOf(ge); kind != nil {
+		return &APIError{Kind: kind, Err: err}
+	}
+	return err
+}
+
+func kindOf(e *googleapi.Error) error {
+	reasons := map[string]bool{}
+	for _, item := range e.Errors {
+		reasons[item.Reason] = true
+	}
+	switch {
+	case e.Code == http.StatusNotFound:
+		return ErrNotFound
+	case e.Code == http.StatusTooManyRequests, reasons["rateLimitExceeded"], reasons["userRateLimitExceeded"],
+		reasons["sharingRateLimitExceeded"]:
+		return ErrRateLimited
+	// storage, or the daily allowance of API calls or uploads
+	case reasons["storageQuotaExceeded"], reasons["teamDriveFileLimitExceeded"], reasons["dailyLimitExceeded"]:
+		return ErrQuotaExceeded
+	case e.Code == http.StatusForbidden:
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// responseError turns the failed raw response res, whose body is not read
+// yet, into a classified error, done by op.
+func responseError(op string, res *http.Response) error {
+	err := googleapi.CheckResponse(res)
+	if err == nil {
+		err = errors.New(res.Status)
+	}
+	if c, ok := Classify(err).(*APIError); ok {
+		c.Op = op
+		return c
+	}
+	return &APIError{Op: op, Err: err}
+}
@@ -0,0 +1,151 @@
+package magic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	DocMime    = "application/vnd.google-apps.document"
+	SheetMime  = "application/vnd.google-apps.spreadsheet"
+	SlidesMime = "application/vnd.google-apps.presentation"
+	DrawMime   = "application/vnd.google-apps.drawing"
+)
+
+var ErrNoExport = errors.New("no export to this format for this kind of document")
+
+// exportFormats maps a format name to the MIME type asked for, per kind
+// of native document; kinds missing from a row cannot be exported to it.
+var exportFormats = map[string]map[string]string{
+	"pdf":  {DocMime: "application/pdf", SheetMime: "application/pdf", SlidesMime: "application/pdf", DrawMime: "application/pdf"},
+	"docx": {DocMime: "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	"xlsx": {SheetMime: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	"pptx": {SlidesMime: "application/vnd.openxmlformats-officedocument.presentationml.presentation"},
+	"odt":  {DocMime: "application/vnd.oasis.opendocument.text"},
+	"ods":  {SheetMime: "application/x-vnd.oasis.opendocument.spreadsheet"},
+	"odp":  {SlidesMime: "application/vnd.oasis.opendocument.presentation"},
+	"txt":  {DocMime: "text/plain", SlidesMime: "text/plain"},
+	"csv":  {SheetMime: "text/csv"},
+	"png":  {DrawMime: "image/png"},
+	"svg":  {DrawMime: "image/svg+xml"},
+}
+
+// ExportMime returns the MIME type to export a native file of srcMime as
+// format, or "" if Drive cannot.
+func ExportMime(format string, srcMime string) string {
+	return exportFormats[format][srcMime]
+}
+
+// Export writes the native Google file f to dest as mime. Exports through
+// the API are capped at 10 MB; larger documents are fetched through the
+// file's export link instead, which has no such limit.
+func Export(ctx context.Context, client *http.Client, srv *drive.Service, f *drive.File, mime string, dest string) error {
+	res, err := srv.Files.Export(f.Id, mime).Context(ctx).Download()
+	if err != nil && exportTooLarge(err) {
+		res, err = exportLink(ctx, client, srv, f.Id, mime)
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, res.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func exportTooLarge(err error) bool {
+	var e *googleapi.Error
+	if !errors.As(err, &e) || e.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range e.Errors {
+		if item.Reason == "exportSizeLimitExceeded" {
+			return true
+		}
+	}
+	return strings.Contains(e.Message, "too large")
+}
+
+func exportLink(ctx context.Context, client *http.Client, srv *drive.Service, id string, mime string) (*http.Response, error) {
+	f, err := srv.Files.Get(id).Fields("exportLinks").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	link := f.ExportLinks[mime]
+	if link == "" {
+		return nil, fmt.Errorf("no export link for %s", mime)
+	}
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return nil, responseError("export link", res)
+	}
+	return res, nil
+}
+
+// ExportAll exports every native document below the Drive folder id into
+// dir as format, mirroring the folder layout with the names of LocalTree,
+// whose renames are recorded in dir. done is called per file, with the
+// local path and the outcome; files of kinds that format does not cover
+// are reported with ErrNoExport.
+func ExportAll(ctx context.Context, client *http.Client, srv *drive.Service, id string, dir string, format string, done func(path string, err error)) error {
+	if exportFormats[format] == nil {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	tree, names, err := LocalTree(ctx, srv, id)
+	if err != nil {
+		return err
+	}
+	exported := NameMap{}
+	for p, r := range names {
+		if tree[p].MimeType == FolderMime {
+			exported[p] = r
+		}
+	}
+	for p, f := range tree {
+		if !IsGoogleDoc(f) {
+			continue
+		}
+		if r, ok := names[p]; ok {
+			exported[p+"."+format] = r
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(p)) + "." + format
+		mime := ExportMime(format, f.MimeType)
+		if mime == "" {
+			done(dest, ErrNoExport)
+			continue
+		}
+		done(dest, Export(ctx, client, srv, f, mime, LongPath(dest)))
+	}
+	if len(exported) > 0 {
+		if err = os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return exported.Save(dir)
+}
@@ -0,0 +1,349 @@
+package magic
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// External plugins are programs in PluginDir, written in any language,
+// that the tool starts and talks to over stdin and stdout: one JSON object
+// per line each way, a request answered by one response.
+//
+//	> {"id":1,"method":"describe"}
+//	< {"id":1,"result":{"name":"tagger","hooks":["filter","notify"]}}
+//	> {"id":2,"method":"filter","params":{"path":"a/b.log","size":12,"modTime":"..."}}
+//	< {"id":2,"result":{"skip":true,"reason":"logs stay local"}}
+//
+// describe comes first and lists the hooks the plugin serves:
+//
+//	filter  decides whether a local file is uploaded; result {skip, reason}
+//	notify  is told of every finished transfer; params are a PluginEvent,
+//	        the result is ignored
+//	store   is offered each upload before Drive; result {handled, id}.
+//	        A handled upload is not sent to Drive; the plugin reads the file
+//	        at path itself
+//
+// A response with "error" set fails the call. Requests are sent one at a
+// time, so plugins need not handle concurrency. Anything written to stderr
+// goes to the tool's stderr.
+
+// PluginDir is where external plugins are looked for.
+var PluginDir = "plugins.d"
+
+// PluginTimeout is how long a plugin may take to answer; it is stopped
+// after that.
+var PluginTimeout = 30 * time.Second
+
+// Plugin hooks.
+const (
+	HookFilter = "filter"
+	HookNotify = "notify"
+	HookStore  = "store"
+)
+
+// ExternalPlugin is a running plugin process.
+type ExternalPlugin struct {
+	Path  string
+	Name  string
+	Hooks []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	lastID int
+	broken error
+}
+
+type pluginRequest struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type pluginResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// PluginEvent is a finished transfer, as notify hooks are told it.
+type PluginEvent struct {
+	Event string `json:"event"` // upload or download
+	Path  string `json:"path"`
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// LoadPlugins starts every executable in dir, in name order; a missing
+// dir has none.
+func LoadPlugins(dir string) ([]*ExternalPlugin, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	var list []*ExternalPlugin
+	for _, e := range entries {
+		if !runnable(e) {
+			continue
+		}
+		p, err := StartPlugin(filepath.Join(dir, e.Name()))
+		if err != nil {
+			ClosePlugins(list)
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+func runnable(e os.FileInfo) bool {
+	if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		return ext == ".exe" || ext == ".bat" || ext == ".cmd"
+	}
+	return e.Mode()&0111 != 0
+}
+
+// StartPlugin runs the plugin at path and asks it to describe itself.
+func StartPlugin(path string) (*ExternalPlugin, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("unable to start plugin %s: %v", path, err)
+	}
+	p := &ExternalPlugin{Path: path, Name: filepath.Base(path), cmd: cmd, in: in, out: bufio.NewReader(out)}
+	var desc struct {
+		Name  string   `json:"name"`
+		Hooks []string `json:"hooks"`
+	}
+	if err = p.Call("describe", nil, &desc); err != nil {
+		p.Close()
+		return nil, err
+	}
+	if desc.Name != "" {
+		p.Name = desc.Name
+	}
+	p.Hooks = desc.Hooks
+	return p, nil
+}
+
+// Has reports whether the plugin serves hook.
+func (p *ExternalPlugin) Has(hook string) bool {
+	for _, h := range p.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+// Call sends method with params and decodes the result into result, if
+// not nil. A plugin that fails to answer in PluginTimeout is stopped, and
+// later calls fail.
+func (p *ExternalPlugin) Call(method string, params interface{}, result interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.broken != nil {
+		return p.broken
+	}
+	p.lastID++
+	req, err := json.Marshal(pluginRequest{ID: p.lastID, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	type answer struct {
+		res pluginResponse
+		err error
+	}
+	got := make(chan answer, 1)
+	go func() {
+		var a answer
+		if _, a.err = p.in.Write(append(req, '\n')); a.err == nil {
+			var line []byte
+			if line, a.err = p.out.ReadBytes('\n'); a.err == nil {
+				a.err = json.Unmarshal(line, &a.res)
+			}
+		}
+		got <- a
+	}()
+	var a answer
+	select {
+	case a = <-got:
+	case <-time.After(PluginTimeout):
+		p.cmd.Process.Kill()
+		a.err = fmt.Errorf("no answer in %v", PluginTimeout)
+	}
+	switch {
+	case a.err != nil:
+		p.broken = fmt.Errorf("plugin %s: %s: %v", p.Name, method, a.err)
+		return p.broken
+	case a.res.ID != p.lastID:
+		p.broken = fmt.Errorf("plugin %s: %s: answer to request %d, want %d", p.Name, method, a.res.ID, p.lastID)
+		return p.broken
+	case a.res.Error != "":
+		return fmt.Errorf("plugin %s: %s: %s", p.Name, method, a.res.Error)
+	case result != nil && len(a.res.Result) > 0:
+		return json.Unmarshal(a.res.Result, result)
+	}
+	return nil
+}
+
+// Close ends the plugin: its stdin is closed, and it is killed if it has
+// not exited a few seconds later.
+func (p *ExternalPlugin) Close() error {
+	p.in.Close()
+	done := make(chan error, 1)
+	go func() { done <- p.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+// ClosePlugins ends every plugin in list.
+func ClosePlugins(list []*ExternalPlugin) {
+	for _, p := range list {
+		if err := p.Close(); err != nil {
+			log.Printf("plugin %s: %v", p.Name, err)
+		}
+	}
+}
+
+// PluginFilter returns a WalkOptions.Accept asking the filter plugins in
+// list about each file; a plugin failing to answer keeps the file. It is
+// nil without filter plugins.
+func PluginFilter(list []*ExternalPlugin) func(path string, info os.FileInfo) bool {
+	var filters []*ExternalPlugin
+	for _, p := range list {
+		if p.Has(HookFilter) {
+			filters = append(filters, p)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return func(path string, info os.FileInfo) bool {
+		params := map[string]interface{}{"path": path, "size": info.Size(), "modTime": info.ModTime().UTC()}
+		for _, p := range filters {
+			var r struct {
+				Skip   bool   `json:"skip"`
+				Reason string `json:"reason"`
+			}
+			if err := p.Call(HookFilter, params, &r); err != nil {
+				log.Print(err)
+				continue
+			}
+			if r.Skip {
+				if r.Reason != "" {
+					log.Printf("skipping %s: %s", path, r.Reason)
+				}
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// UsePlugins wraps transfers in the notify and store plugins of list, as
+// transfer hooks. Notifiers go outside, so they hear of uploads a store
+// took as well.
+func UsePlugins(list []*ExternalPlugin) {
+	var notify []*ExternalPlugin
+	for _, p := range list {
+		if p.Has(HookNotify) {
+			notify = append(notify, p)
+		}
+	}
+	if len(notify) > 0 {
+		WithTransferHook(notifyHook(notify))
+	}
+	for _, p := range list {
+		if p.Has(HookStore) {
+			WithTransferHook(storeHook(p))
+		}
+	}
+}
+
+func storeHook(p *ExternalPlugin) func(Transfer) Transfer {
+	return func(next Transfer) Transfer {
+		return func(ctx context.Context, x *TransferJob) (*drive.File, error) {
+			if !x.Upload {
+				return next(ctx, x)
+			}
+			var r struct {
+				Handled bool   `json:"handled"`
+				ID      string `json:"id"`
+			}
+			params := map[string]interface{}{"path": x.Name, "size": x.Size, "file": x.File}
+			if err := p.Call(HookStore, params, &r); err != nil {
+				return nil, err
+			}
+			if !r.Handled {
+				return next(ctx, x)
+			}
+			f := *x.File
+			f.Id, f.Size = r.ID, x.Size
+			return &f, nil
+		}
+	}
+}
+
+func notifyHook(list []*ExternalPlugin) func(Transfer) Transfer {
+	return func(next Transfer) Transfer {
+		return func(ctx context.Context, x *TransferJob) (*drive.File, error) {
+			f, err := next(ctx, x)
+			e := PluginEvent{Event: "download", Path: x.Name, ID: x.ID, Size: x.Size}
+			if x.Upload {
+				e.Event = "upload"
+			}
+			if f != nil {
+				e.ID, e.Name, e.Size = f.Id, f.Name, f.Size
+			}
+			if err != nil {
+				e.Error = err.Error()
+			}
+			for _, p := range list {
+				if nerr := p.Call(HookNotify, e, nil); nerr != nil {
+					log.Print(nerr)
+				}
+			}
+			return f, err
+		}
+	}
+}
@@ -1,26 +1,56 @@
 package magic
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	magic_struct "./authentication/layer2/layer3/typedef"
 )
 
+// Symlink policies for WalkWith.
+const (
+	LinksFollow   = "follow"   // walk into the link target
+	LinksSkip     = "skip"     // leave links out
+	LinksPreserve = "preserve" // report the link itself with its target
+)
+
+type WalkOptions struct {
+	Links  string
+	Ignore bool   // honor IgnoreFile patterns
+	Filter Filter // size and age bounds for files
+	// Accept, when set, has the last word on files the other checks
+	// let through.
+	Accept func(path string, info os.FileInfo) bool
+
+	// MaxDepth and MaxFiles guard against runaway trees; zero is no limit.
+	// Exceeding one fails the walk, unless LimitWarn is set, in which case
+	// a warning is logged and the walk is truncated.
+	MaxDepth  int
+	MaxFiles  int
+	LimitWarn bool
+
+	// Workers is the number of directories read concurrently (default 1).
+	// Progress, when set, is called periodically with the running counts.
+	Workers  int
+	Progress func(files, dirs int)
+}
+
+var (
+	ErrMaxDepth = errors.New("directory tree exceeds the depth limit")
+	ErrMaxFiles = errors.New("directory tree exceeds the file count limit")
+)
+
 func Walk(root string) (route []magic_struct.FileInfo) {
 
-	var files []magic_struct.FileInfo
-	var temp magic_struct.FileInfo
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-
-		temp.Path = path
-		temp.Name = info.Name()
-		temp.Size = info.Size()
-		temp.ModTime = info.ModTime()
-		temp.IsDir = info.IsDir()
-		files = append(files, temp)
-		return nil
-	})
+	files, err := WalkWith(root, WalkOptions{})
 	if err != nil {
 		panic(err)
 	}
@@ -28,3 +58,293 @@ func Walk(root string) (route []magic_struct.FileInfo) {
 	return files
 
 }
+
+// WalkWith lists root recursively, applying the symlink policy in opt.
+// Followed links to directories already visited are skipped so link loops
+// terminate. Directories are read by up to opt.Workers goroutines; the
+// result is sorted by path, so parents always precede their children.
+func WalkWith(root string, opt WalkOptions) ([]magic_struct.FileInfo, error) {
+	switch opt.Links {
+	case "":
+		opt.Links = LinksFollow
+	case LinksFollow, LinksSkip, LinksPreserve:
+	default:
+		return nil, fmt.Errorf("unknown links policy %q", opt.Links)
+	}
+	if opt.Workers < 1 {
+		opt.Workers = 1
+	}
+	root = filepath.Clean(root)
+	w := &walker{opt: opt, seen: map[string]bool{}}
+	w.cond = sync.NewCond(&w.mu)
+	if opt.Ignore {
+		w.ignore = NewIgnore(root)
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	w.add(root, info, "")
+	if info.IsDir() && w.enter(root) {
+		done := make(chan struct{})
+		if opt.Progress != nil {
+			go w.report(done)
+		}
+		w.push(dirJob{LongPath(root), root, 0})
+		var wg sync.WaitGroup
+		for i := 0; i < opt.Workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.work()
+			}()
+		}
+		wg.Wait()
+		close(done)
+	}
+	if opt.Progress != nil {
+		opt.Progress(w.counts())
+	}
+	if w.err != nil {
+		return nil, w.err
+	}
+	sort.Slice(w.files, func(i, j int) bool { return w.files[i].Path < w.files[j].Path })
+	return w.files, nil
+}
+
+// dirJob is a directory waiting to be read, with the name it is shown as.
+type dirJob struct {
+	real, shown string
+	depth       int
+}
+
+type walker struct {
+	opt    WalkOptions
+	ignore *Ignore
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue holds unread directories; outstanding counts those plus the
+	// ones being read, and the walk is over when it drops to zero.
+	queue       []dirJob
+	outstanding int
+
+	seen    map[string]bool
+	files   []magic_struct.FileInfo
+	dirs    int
+	warned  bool
+	stopped bool
+	err     error
+}
+
+// enter marks the directory behind real as visited, reporting false when
+// it already was.
+func (w *walker) enter(real string) bool {
+	abs, err := filepath.EvalSymlinks(real)
+	if err != nil {
+		return true
+	}
+	if caseInsensitiveFS {
+		abs = strings.ToLower(abs)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.seen[abs] {
+		return false
+	}
+	w.seen[abs] = true
+	return true
+}
+
+func (w *walker) push(job dirJob) {
+	w.mu.Lock()
+	w.queue = append(w.queue, job)
+	w.outstanding++
+	w.mu.Unlock()
+	w.cond.Signal()
+}
+
+// work reads queued directories until none are left anywhere.
+func (w *walker) work() {
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && w.outstanding > 0 {
+			w.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			return
+		}
+		// Newest first keeps the queue as small as a depth-first walk.
+		job := w.queue[len(w.queue)-1]
+		w.queue = w.queue[:len(w.queue)-1]
+		w.mu.Unlock()
+
+		w.visit(job.real, job.shown, job.depth)
+
+		w.mu.Lock()
+		w.outstanding--
+		if w.outstanding == 0 {
+			w.cond.Broadcast()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// visit lists the directory real, reporting its entries under shown.
+func (w *walker) visit(real, shown string, depth int) {
+	if w.halted() {
+		return
+	}
+	entries, err := ioutil.ReadDir(real)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+
+	for _, info := range entries {
+		if w.halted() {
+			return
+		}
+		path := filepath.Join(real, info.Name())
+		name := filepath.Join(shown, info.Name())
+
+		if w.ignore != nil && w.ignore.Match(name, info.IsDir()) {
+			continue
+		}
+		if w.opt.MaxDepth > 0 && depth+1 > w.opt.MaxDepth {
+			if err := w.limit(ErrMaxDepth, name); err != nil {
+				w.fail(err)
+			}
+			return
+		}
+		if w.opt.MaxFiles > 0 && w.count() >= w.opt.MaxFiles {
+			if err := w.limit(ErrMaxFiles, name); err != nil {
+				w.fail(err)
+			}
+			w.stop()
+			return
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			switch w.opt.Links {
+			case LinksSkip:
+				continue
+			case LinksPreserve:
+				target, err := os.Readlink(path)
+				if err != nil {
+					w.fail(err)
+					return
+				}
+				w.add(name, info, target)
+				continue
+			}
+			target, err := os.Stat(path)
+			if err != nil {
+				// dangling link
+				continue
+			}
+			if target.IsDir() {
+				resolved, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					w.fail(err)
+					return
+				}
+				if w.enter(resolved) {
+					w.add(name, target, "")
+					w.push(dirJob{resolved, name, depth + 1})
+				}
+				continue
+			}
+			info = target
+		}
+		w.add(name, info, "")
+		if info.IsDir() && w.enter(path) {
+			w.push(dirJob{path, name, depth + 1})
+		}
+	}
+}
+
+// limit fails the walk with err, or logs it once and lets the caller
+// truncate when warnings were asked for.
+func (w *walker) limit(err error, at string) error {
+	if !w.opt.LimitWarn {
+		return fmt.Errorf("%w at %s", err, at)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.warned {
+		log.Printf("warning: %v at %s, truncating the walk", err, at)
+		w.warned = true
+	}
+	return nil
+}
+
+func (w *walker) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.stopped = true
+}
+
+func (w *walker) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+}
+
+func (w *walker) halted() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stopped
+}
+
+func (w *walker) count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.files)
+}
+
+func (w *walker) counts() (files, dirs int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.files) - w.dirs, w.dirs
+}
+
+func (w *walker) report(done chan struct{}) {
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tick.C:
+			w.opt.Progress(w.counts())
+		}
+	}
+}
+
+func (w *walker) add(path string, info os.FileInfo, link string) {
+	if !info.IsDir() && !w.opt.Filter.Match(info.Size(), info.ModTime()) {
+		return
+	}
+	if !info.IsDir() && w.opt.Accept != nil && !w.opt.Accept(path, info) {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if info.IsDir() {
+		w.dirs++
+	}
+	w.files = append(w.files, magic_struct.FileInfo{
+		Path:    path,
+		Name:    filepath.Base(path),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+		Link:    link,
+	})
+}
@@ -0,0 +1,100 @@
+package magic
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Filter limits files by size and age. Zero fields are not checked.
+// Directories are never filtered.
+type Filter struct {
+	MinSize int64
+	MaxSize int64
+	MinAge  time.Duration
+	MaxAge  time.Duration
+}
+
+func (f Filter) Match(size int64, modTime time.Time) bool {
+	if f.MinSize > 0 && size < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+	age := time.Since(modTime)
+	if f.MinAge > 0 && age < f.MinAge {
+		return false
+	}
+	if f.MaxAge > 0 && age > f.MaxAge {
+		return false
+	}
+	return true
+}
+
+// Query renders the age bounds as a Drive search clause. Drive cannot
+// search by size, so remote sizes still have to go through Match.
+func (f Filter) Query() string {
+	var q []string
+	now := time.Now().UTC()
+	if f.MinAge > 0 {
+		q = append(q, fmt.Sprintf("modifiedTime < '%s'", now.Add(-f.MinAge).Format(time.RFC3339)))
+	}
+	if f.MaxAge > 0 {
+		q = append(q, fmt.Sprintf("modifiedTime > '%s'", now.Add(-f.MaxAge).Format(time.RFC3339)))
+	}
+	return strings.Join(q, " and ")
+}
+
+// ParseSize reads sizes like "512", "64K", "64M" or "2G" (powers of 1024).
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	s = strings.TrimSuffix(s, "B")
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	case strings.HasSuffix(s, "T"):
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// ParseAge reads durations, additionally accepting days and weeks such as
+// "90d" or "2w".
+func ParseAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit == 0 {
+		return time.ParseDuration(s)
+	}
+	v, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return time.Duration(v * float64(unit)), nil
+}
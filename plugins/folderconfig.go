@@ -0,0 +1,131 @@
+package magic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// FolderConfigName is the file a Drive folder keeps its FolderConfig in.
+const FolderConfigName = ".magicserver.yaml"
+
+// FolderConfig holds defaults that a shared destination folder sets for
+// every client writing into it. Only the file directly in the folder
+// counts, not ones in its subfolders.
+type FolderConfig struct {
+	// Conflict settles sync conflicts when the user chose no resolution.
+	Conflict Resolution `yaml:"conflict"`
+	// AllowTypes lists the MIME types accepted, "image/*" style; empty
+	// allows everything.
+	AllowTypes []string `yaml:"allow_types"`
+	// Retention, such as 30d, trashes files not modified for that long
+	// whenever a client uploads into the folder; sync leaves it alone, as
+	// the trashing would propagate to local copies.
+	Retention string `yaml:"retention"`
+
+	retention time.Duration
+}
+
+// LoadFolderConfig reads the FolderConfig of the Drive folder id; a folder
+// without one gets an empty config.
+func LoadFolderConfig(ctx context.Context, srv *drive.Service, id string) (*FolderConfig, error) {
+	c := &FolderConfig{}
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", FolderConfigName, id)
+	list, err := srv.Files.List().Q(q).Fields("files(id)").PageSize(1).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Files) == 0 {
+		return c, nil
+	}
+	res, err := srv.Files.Get(list.Files[0].Id).Context(ctx).Download()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("unable to parse %s of folder %s: %v", FolderConfigName, id, err)
+	}
+	if c.Conflict != "" {
+		if _, err = ParseResolution(string(c.Conflict)); err != nil {
+			return nil, fmt.Errorf("%s of folder %s: %v", FolderConfigName, id, err)
+		}
+	}
+	if c.retention, err = ParseAge(c.Retention); err != nil {
+		return nil, fmt.Errorf("%s of folder %s: invalid retention: %v", FolderConfigName, id, err)
+	}
+	return c, nil
+}
+
+// Allows reports whether a file of type mimeType may be written into the
+// folder. A nil config allows everything.
+func (c *FolderConfig) Allows(mimeType string) bool {
+	return c == nil || len(c.AllowTypes) == 0 || matchType(c.AllowTypes, mimeType)
+}
+
+// matchType reports whether mimeType is one of patterns, which may end in
+// "/*" to match a whole family of types.
+func matchType(patterns []string, mimeType string) bool {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+	for _, t := range patterns {
+		t = strings.ToLower(t)
+		if t == mimeType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsName is Allows for the type guessed from a file name's extension.
+func (c *FolderConfig) AllowsName(name string) bool {
+	return c.Allows(typeByName(name))
+}
+
+// typeByName guesses the MIME type of a file from its extension.
+func typeByName(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// ApplyRetention trashes the files below the Drive folder id that were not
+// modified within the config's retention, and returns their paths.
+func ApplyRetention(ctx context.Context, srv *drive.Service, id string, c *FolderConfig) ([]string, error) {
+	if c == nil || c.retention <= 0 {
+		return nil, nil
+	}
+	tree, err := RemoteTree(ctx, srv, id)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-c.retention)
+	var trashed []string
+	for p, f := range tree {
+		if f.MimeType == FolderMime || p == FolderConfigName {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, f.ModifiedTime)
+		if err != nil || !t.Before(cutoff) {
+			continue
+		}
+		_, err = srv.Files.Update(f.Id, &drive.File{Trashed: true}).Fields("id").Context(ctx).Do()
+		auditLocal("trash", f.Id, f.Name, map[string]string{"reason": "retention", "path": p, "retention": c.Retention}, err)
+		if err != nil {
+			return trashed, fmt.Errorf("%s: %v", p, err)
+		}
+		trashed = append(trashed, p)
+	}
+	return trashed, nil
+}
@@ -0,0 +1,184 @@
+package magic
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Listing formats besides the default text.
+const (
+	FormatCSV = "csv"
+	FormatTSV = "tsv"
+)
+
+// formatColumns are the CSV and TSV columns, after a header row.
+var formatColumns = []string{"id", "name", "mimeType", "size", "modifiedTime", "parents"}
+
+// FileFormat writes a file listing as CSV, TSV or a Go template run for
+// each *drive.File, for spreadsheets and awk. A template like
+// '{{.Id}}\t{{.Name}}' may spell tabs and newlines as \t and \n, and gets
+// a newline at the end when it has none.
+type FileFormat struct {
+	csv  *csv.Writer
+	tmpl *template.Template
+	w    io.Writer
+}
+
+// NewFileFormat returns the writer of format to w, or nil for "", the
+// caller's own text.
+func NewFileFormat(w io.Writer, format string) (*FileFormat, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case FormatCSV, FormatTSV:
+		cw := csv.NewWriter(w)
+		if format == FormatTSV {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write(formatColumns); err != nil {
+			return nil, err
+		}
+		return &FileFormat{csv: cw, w: w}, nil
+	}
+	if !strings.Contains(format, "{{") {
+		return nil, fmt.Errorf("unknown format %q: want csv, tsv or a Go template", format)
+	}
+	text := strings.NewReplacer(`\t`, "\t", `\n`, "\n", `\\`, `\`).Replace(format)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	tmpl, err := template.New("format").Funcs(template.FuncMap{"display": Names.Display}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("bad format template: %v", err)
+	}
+	return &FileFormat{tmpl: tmpl, w: w}, nil
+}
+
+// Write writes the line of f.
+func (ff *FileFormat) Write(f *drive.File) error {
+	if ff.tmpl != nil {
+		return ff.tmpl.Execute(ff.w, f)
+	}
+	size := ""
+	if f.MimeType != FolderMime {
+		size = strconv.FormatInt(f.Size, 10)
+	}
+	return ff.csv.Write([]string{f.Id, Names.Display(f.Name), f.MimeType, size, f.ModifiedTime, strings.Join(f.Parents, " ")})
+}
+
+// Flush writes out what is buffered, returning any error met writing.
+func (ff *FileFormat) Flush() error {
+	if ff.csv == nil {
+		return nil
+	}
+	ff.csv.Flush()
+	return ff.csv.Error()
+}
+
+// Column presets for NewTable.
+const (
+	DefaultColumns = "id,size,mtime,name"
+	LongColumns    = "owner,size,mtime,id,name" // like ls -l
+)
+
+// ColumnFields are the file attributes a listing fetches for any of the
+// columns.
+const ColumnFields = ListFields + ", owners(emailAddress), webContentLink"
+
+// fileColumns are the columns a table may show.
+var fileColumns = map[string]func(f *drive.File) string{
+	"id":   func(f *drive.File) string { return f.Id },
+	"name": func(f *drive.File) string { return Names.Display(f.Name) },
+	"size": func(f *drive.File) string {
+		if f.MimeType == FolderMime {
+			return "-"
+		}
+		return formatBytes(uint64(f.Size))
+	},
+	"bytes": func(f *drive.File) string { return strconv.FormatInt(f.Size, 10) },
+	"mtime": func(f *drive.File) string {
+		t, err := time.Parse(time.RFC3339, f.ModifiedTime)
+		if err != nil {
+			return "-"
+		}
+		return t.Local().Format("2006-01-02 15:04")
+	},
+	"owner": func(f *drive.File) string {
+		if len(f.Owners) == 0 {
+			return "-"
+		}
+		return f.Owners[0].EmailAddress
+	},
+	"type":    func(f *drive.File) string { return f.MimeType },
+	"link":    func(f *drive.File) string { return f.WebContentLink },
+	"parents": func(f *drive.File) string { return strings.Join(f.Parents, ",") },
+}
+
+// Table writes files as aligned columns, once flushed.
+type Table struct {
+	tw   *tabwriter.Writer
+	cols []func(f *drive.File) string
+}
+
+// NewTable returns a table of the comma separated columns: id, name,
+// size, bytes, mtime, owner, type, link and parents.
+func NewTable(w io.Writer, columns string) (*Table, error) {
+	t := &Table{tw: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)}
+	for _, c := range strings.Split(columns, ",") {
+		col, ok := fileColumns[strings.TrimSpace(c)]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", c)
+		}
+		t.cols = append(t.cols, col)
+	}
+	return t, nil
+}
+
+// Write adds the row of f.
+func (t *Table) Write(f *drive.File) error {
+	cells := make([]string, len(t.cols))
+	for i, col := range t.cols {
+		cells[i] = strings.Replace(col(f), "\t", " ", -1)
+	}
+	_, err := fmt.Fprintln(t.tw, strings.Join(cells, "\t"))
+	return err
+}
+
+// Flush aligns and writes out the rows.
+func (t *Table) Flush() error {
+	return t.tw.Flush()
+}
+
+// sortKeys map the sort keys of listings to Drive orderBy keys.
+var sortKeys = map[string]string{
+	"name": "name_natural", "size": "quotaBytesUsed", "mtime": "modifiedTime",
+	"ctime": "createdTime", "folder": "folder",
+}
+
+// SortOrder turns a comma separated list of sort keys (name, size, mtime,
+// ctime and folder, each reversed by a leading -) into a Drive orderBy,
+// so that Drive returns listings already sorted.
+func SortOrder(keys string) (string, error) {
+	var order []string
+	for _, k := range strings.Split(keys, ",") {
+		k = strings.TrimSpace(k)
+		desc := strings.HasPrefix(k, "-")
+		key, ok := sortKeys[strings.TrimPrefix(k, "-")]
+		if !ok {
+			return "", fmt.Errorf("unknown sort key %q: want name, size, mtime, ctime or folder", k)
+		}
+		if desc {
+			key += " desc"
+		}
+		order = append(order, key)
+	}
+	return strings.Join(order, ","), nil
+}
@@ -0,0 +1,60 @@
+package magic
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FuzzyScore matches pattern against s as a picker does: the letters of
+// pattern must appear in s in order, ignoring case, and the score is
+// higher the more of them are adjacent or start words. ok is false when
+// s does not match.
+func FuzzyScore(pattern, s string) (score int, ok bool) {
+	p := []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return 0, true
+	}
+	lower := strings.ToLower(s)
+	i, prev, last := 0, rune(0), -2
+	for pos, r := range lower {
+		if r == p[i] {
+			score++
+			if pos == last {
+				score += 3 // adjacent to the previous letter matched
+			}
+			if pos == 0 || !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+				score += 2 // at the start of a word
+			}
+			last = pos + utf8.RuneLen(r)
+			if i++; i == len(p) {
+				// shorter names are the likelier pick
+				return score*64 - len(s)/8, true
+			}
+		}
+		prev = r
+	}
+	return 0, false
+}
+
+// FuzzyFind returns the indexes of the n names matching pattern best,
+// best first.
+func FuzzyFind(pattern string, names []string, n int) []int {
+	type hit struct{ i, score int }
+	var hits []hit
+	for i, name := range names {
+		if score, ok := FuzzyScore(pattern, name); ok {
+			hits = append(hits, hit{i, score})
+		}
+	}
+	sort.SliceStable(hits, func(a, b int) bool { return hits[a].score > hits[b].score })
+	if len(hits) > n {
+		hits = hits[:n]
+	}
+	out := make([]int, len(hits))
+	for i, h := range hits {
+		out[i] = h.i
+	}
+	return out
+}
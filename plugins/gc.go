@@ -0,0 +1,209 @@
+package magic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Packs are only reachable through the manifests naming them, so a run
+// stopped before writing its manifest, or manifests deleted with the
+// snapshots they belonged to, leave packs nobody will read again. GC finds
+// and removes them in two phases, so that the packs of a run still
+// uploading, whose manifest does not exist yet, are not taken for garbage:
+// a pack unreferenced on one run is only marked, and is trashed on a later
+// one if it is still unreferenced and the mark older than the grace
+// period. A lock file in the folder keeps two collections from running at
+// once.
+
+const (
+	// GCLockName is the lock file GC holds in the folder it collects.
+	GCLockName = ".magicpack-gc.lock"
+	gcMarkKey  = "magicpack.unreferenced"
+	gcLockTTL  = time.Hour
+	gcFields   = "id, name, mimeType, size, createdTime, appProperties"
+)
+
+// ErrGCLocked is returned while another collection holds the lock.
+var ErrGCLocked = errors.New("another gc is running in this folder")
+
+// GCOptions controls GC.
+type GCOptions struct {
+	// Grace is how long packs stay marked before they are trashed, and
+	// how old a pack must be to be marked; it needs to exceed the longest
+	// upload.
+	Grace time.Duration
+	// DryRun only logs what would be done.
+	DryRun bool
+	// Log is told of every change, ops being "mark", "unmark" and "trash".
+	Log func(op string, f *drive.File)
+}
+
+// GCStats sums up a collection.
+type GCStats struct {
+	Packs      int
+	Referenced int
+	Marked     int
+	Trashed    int
+	Freed      int64 // bytes of the packs trashed
+}
+
+// GC collects the packs below the Drive folder id that no manifest below
+// it references.
+func GC(ctx context.Context, srv *drive.Service, id string, opt GCOptions) (GCStats, error) {
+	var st GCStats
+	if !opt.DryRun {
+		unlock, err := lockGC(ctx, srv, id)
+		if err != nil {
+			return st, err
+		}
+		defer unlock()
+	}
+	var packs, manifests []*drive.File
+	folders := []string{id}
+	for len(folders) > 0 {
+		dir := folders[0]
+		folders = folders[1:]
+		it := ListIter(ctx, srv, "'"+dir+"' in parents and trashed=false", gcFields)
+		for it.Next() {
+			f := it.File()
+			switch {
+			case f.MimeType == FolderMime:
+				folders = append(folders, f.Id)
+			case f.Name == PackManifestName:
+				manifests = append(manifests, f)
+			case isPack(f.Name):
+				packs = append(packs, f)
+			}
+		}
+		if err := it.Err(); err != nil {
+			return st, err
+		}
+	}
+	// mark: a manifest that cannot be read could reference anything
+	referenced := map[string]bool{}
+	for _, m := range manifests {
+		pm, err := ReadPackManifest(srv, m.Id)
+		if err != nil {
+			return st, fmt.Errorf("unable to read manifest %s, nothing was changed: %v", m.Id, err)
+		}
+		for _, p := range pm.Packs {
+			referenced[p] = true
+		}
+		for _, e := range pm.Entries {
+			referenced[e.Pack] = true
+		}
+	}
+	now := time.Now()
+	st.Packs = len(packs)
+	for _, f := range packs {
+		mark, marked := f.AppProperties[gcMarkKey]
+		var err error
+		switch {
+		case referenced[f.Id]:
+			st.Referenced++
+			if marked {
+				err = gcUpdate(ctx, srv, f, opt, "unmark", &drive.File{NullFields: []string{"AppProperties." + gcMarkKey}})
+			}
+		case marked:
+			t, perr := time.Parse(time.RFC3339, mark)
+			if perr == nil && now.Sub(t) < opt.Grace {
+				st.Marked++
+				break
+			}
+			if err = gcUpdate(ctx, srv, f, opt, "trash", &drive.File{Trashed: true}); err == nil {
+				st.Trashed++
+				st.Freed += f.Size
+			}
+		default:
+			created, perr := time.Parse(time.RFC3339, f.CreatedTime)
+			if perr != nil || now.Sub(created) < opt.Grace {
+				break
+			}
+			upd := &drive.File{AppProperties: map[string]string{gcMarkKey: now.UTC().Format(time.RFC3339)}}
+			if err = gcUpdate(ctx, srv, f, opt, "mark", upd); err == nil {
+				st.Marked++
+			}
+		}
+		if err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+func isPack(name string) bool {
+	return strings.HasPrefix(name, ".magicpack-") && strings.HasSuffix(name, ".tar")
+}
+
+func gcUpdate(ctx context.Context, srv *drive.Service, f *drive.File, opt GCOptions, op string, upd *drive.File) error {
+	if opt.Log != nil {
+		opt.Log(op, f)
+	}
+	if opt.DryRun {
+		return nil
+	}
+	_, err := srv.Files.Update(f.Id, upd).Fields("id").Context(ctx).Do()
+	return err
+}
+
+// lockGC creates GCLockName in the folder id. Of concurrent lockers the
+// one with the oldest lock wins. The holder touches its lock every
+// gcLockTTL/4 until unlock, so locks untouched for gcLockTTL were left by
+// collections that died and are removed.
+func lockGC(ctx context.Context, srv *drive.Service, id string) (unlock func(), err error) {
+	f := &drive.File{Name: GCLockName, MimeType: "text/plain", Parents: []string{id}}
+	mine, err := srv.Files.Create(f).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create gc lock: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(gcLockTTL / 4)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case now := <-tick.C:
+				upd := &drive.File{ModifiedTime: now.UTC().Format(time.RFC3339)}
+				srv.Files.Update(mine.Id, upd).Fields("id").Context(ctx).Do()
+			}
+		}
+	}()
+	unlock = func() {
+		close(done)
+		srv.Files.Delete(mine.Id).Context(context.Background()).Do()
+	}
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", GCLockName, id)
+	var locks []*drive.File
+	it := ListIter(ctx, srv, q, "id, createdTime, modifiedTime")
+	for it.Next() {
+		locks = append(locks, it.File())
+	}
+	if err = it.Err(); err != nil {
+		unlock()
+		return nil, err
+	}
+	oldestFirst(locks)
+	for _, l := range locks {
+		if l.Id == mine.Id {
+			return unlock, nil
+		}
+		touched, err := time.Parse(time.RFC3339, l.ModifiedTime)
+		if err == nil && time.Since(touched) > gcLockTTL {
+			srv.Files.Delete(l.Id).Context(ctx).Do()
+			continue
+		}
+		unlock()
+		return nil, ErrGCLocked
+	}
+	// the listing may lag behind the create
+	return unlock, nil
+}
@@ -0,0 +1,252 @@
+package magic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// The guard is a safety net for running powerful credentials in
+// automation: in read-only mode every call that would change Drive fails
+// before it is sent, and with an allowlist only files below the allowed
+// folders may be changed. It sits in Transport, so it covers every call,
+// however it is made.
+
+// ErrReadOnly is returned for changes refused in read-only mode.
+var ErrReadOnly = errors.New("refusing to change Drive in read-only mode")
+
+// outsideError is returned for changes outside the allowed folders.
+type outsideError struct {
+	id string
+}
+
+func (e outsideError) Error() string {
+	if e.id == "" {
+		return "refusing to change Drive outside the allowed folders"
+	}
+	return fmt.Sprintf("refusing to change %s: not in an allowed folder", e.id)
+}
+
+var guard struct {
+	sync.Mutex
+	readOnly bool
+	allow    map[string]bool
+	inside   map[string]bool // cache of ancestry lookups
+}
+
+// SetGuard switches read-only mode, and limits changes to the Drive
+// folders allow and everything below them; with allow empty anything may
+// be changed.
+func SetGuard(readOnly bool, allow []string) {
+	guard.Lock()
+	defer guard.Unlock()
+	guard.readOnly = readOnly
+	guard.allow = nil
+	if len(allow) > 0 {
+		guard.allow = map[string]bool{}
+		for _, id := range allow {
+			guard.allow[id] = true
+		}
+	}
+	guard.inside = map[string]bool{}
+}
+
+// Refused reports whether err is the guard refusing a call.
+func Refused(err error) bool {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	_, outside := err.(outsideError)
+	return err == ErrReadOnly || outside
+}
+
+type guardTransport struct {
+	base http.RoundTripper
+}
+
+func (t guardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	guard.Lock()
+	readOnly, allow := guard.readOnly, guard.allow
+	guard.Unlock()
+	if !readOnly && allow == nil || !mutates(req) {
+		return t.base.RoundTrip(req)
+	}
+	if readOnly {
+		return nil, ErrReadOnly
+	}
+	ids, req, err := targets(req)
+	if err != nil {
+		return nil, err
+	}
+	parents := func(id string) ([]string, error) { return t.parents(req, id) }
+	for _, id := range ids {
+		if err := checkInside(id, parents); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// mutates reports whether req can change anything in Drive. Token
+// requests and searches sent by POST do not.
+func mutates(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	host := req.URL.Hostname()
+	switch {
+	case host == "oauth2.googleapis.com", host == "accounts.google.com",
+		host == "driveactivity.googleapis.com" && strings.HasSuffix(req.URL.Path, ":query"):
+		return false
+	}
+	return strings.HasSuffix(host, ".googleapis.com")
+}
+
+// targets returns the IDs of the files and folders req changes, or that
+// it creates files in. Requests whose targets are unknown are refused.
+// The body of a create is peeked at, so the request returned is to be
+// sent instead of req.
+func targets(req *http.Request) ([]string, *http.Request, error) {
+	var ids []string
+	q := req.URL.Query()
+	if p := q.Get("addParents"); p != "" {
+		ids = append(ids, strings.Split(p, ",")...)
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case req.URL.Hostname() == "sheets.googleapis.com" && len(parts) >= 3 && parts[1] == "spreadsheets":
+		return append(ids, strings.SplitN(parts[2], ":", 2)[0]), req, nil
+	case req.URL.Hostname() != "www.googleapis.com":
+	case len(parts) >= 4 && parts[0] == "drive" && parts[2] == "files" && parts[3] != "trash":
+		ids = append(ids, parts[3])
+		if len(parts) == 5 && parts[4] == "copy" {
+			return withParents(req, ids)
+		}
+		return ids, req, nil
+	case len(parts) >= 5 && parts[0] == "upload" && parts[3] == "files":
+		return append(ids, parts[4]), req, nil
+	case len(parts) == 4 && parts[0] == "upload" && parts[3] == "files":
+		// writes into a session already let through
+		if q.Get("upload_id") != "" {
+			return ids, req, nil
+		}
+		return withParents(req, ids)
+	case len(parts) == 3 && parts[0] == "drive" && parts[2] == "files":
+		return withParents(req, ids)
+	}
+	return nil, nil, outsideError{}
+}
+
+// withParents adds the parents named in the metadata of the create or
+// copy req to ids; a file without any would land outside every folder.
+func withParents(req *http.Request, ids []string) ([]string, *http.Request, error) {
+	if req.Body == nil {
+		return nil, nil, outsideError{}
+	}
+	var peeked bytes.Buffer
+	body := io.TeeReader(req.Body, &peeked)
+	var meta struct {
+		Parents []string `json:"parents"`
+	}
+	var err error
+	ct, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if strings.HasPrefix(ct, "multipart/") {
+		var part *multipart.Part
+		if part, err = multipart.NewReader(body, params["boundary"]).NextPart(); err == nil {
+			err = json.NewDecoder(part).Decode(&meta)
+		}
+	} else {
+		err = json.NewDecoder(body).Decode(&meta)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read the parents of a new file: %v", err)
+	}
+	if len(meta.Parents) == 0 {
+		return nil, nil, outsideError{}
+	}
+	out := req.Clone(req.Context())
+	out.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&peeked, req.Body), req.Body}
+	out.GetBody = nil
+	return append(ids, meta.Parents...), out, nil
+}
+
+// checkInside returns nil when id is an allowed folder or below one.
+func checkInside(id string, parents func(string) ([]string, error)) error {
+	ok, err := inside(id, parents, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return outsideError{id}
+	}
+	return nil
+}
+
+func inside(id string, parents func(string) ([]string, error), seen map[string]bool) (bool, error) {
+	guard.Lock()
+	ok, known := guard.inside[id]
+	if guard.allow[id] {
+		ok, known = true, true
+	}
+	guard.Unlock()
+	if known || seen[id] {
+		return ok, nil
+	}
+	seen[id] = true
+	ps, err := parents(id)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range ps {
+		if ok, err = inside(p, parents, seen); err != nil || ok {
+			break
+		}
+	}
+	if err == nil {
+		guard.Lock()
+		guard.inside[id] = ok
+		guard.Unlock()
+	}
+	return ok, err
+}
+
+// parents looks up the parents of id with the credentials of req.
+func (t guardTransport) parents(req *http.Request, id string) ([]string, error) {
+	u := "https://www.googleapis.com/drive/v3/files/" + url.PathEscape(id) + "?fields=parents&supportsAllDrives=true"
+	get, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	get = get.WithContext(req.Context())
+	get.Header.Set("Authorization", req.Header.Get("Authorization"))
+	res, err := t.base.RoundTrip(get)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, responseError("unable to look up the folders of "+id, res)
+	}
+	var f struct {
+		Parents []string `json:"parents"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&f); err != nil {
+		return nil, err
+	}
+	return f.Parents, nil
+}
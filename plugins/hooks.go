@@ -0,0 +1,54 @@
+package magic
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Transfer moves the file of x: an upload sends x.Body as x.File, a
+// download writes the Drive file x.ID to x.Name.
+type Transfer func(ctx context.Context, x *TransferJob) (*drive.File, error)
+
+// TransferJob is a transfer as hooks see it. Before calling the next
+// Transfer a hook may change the File metadata of an upload, or wrap its
+// Body, setting Size to what the wrapped Body yields; after it, the file
+// is on Drive or on disk.
+type TransferJob struct {
+	TransferInfo
+	File *drive.File // nil for downloads
+	Body io.Reader   // nil for downloads
+}
+
+var hooks struct {
+	sync.RWMutex
+	list []func(next Transfer) Transfer
+}
+
+// WithTransferHook wraps every later transfer in hook, for encryption,
+// scanning, tagging, metrics and the like. Hooks added first run
+// outermost.
+func WithTransferHook(hook func(next Transfer) Transfer) {
+	hooks.Lock()
+	hooks.list = append(hooks.list, hook)
+	hooks.Unlock()
+}
+
+func transferHooks() []func(Transfer) Transfer {
+	hooks.RLock()
+	defer hooks.RUnlock()
+	return hooks.list
+}
+
+// RunTransfer runs last, the transfer itself, inside the hooks added with
+// WithTransferHook.
+func RunTransfer(ctx context.Context, x *TransferJob, last Transfer) (*drive.File, error) {
+	list := transferHooks()
+	t := last
+	for i := len(list) - 1; i >= 0; i-- {
+		t = list[i](t)
+	}
+	return t(ctx, x)
+}
@@ -0,0 +1,167 @@
+package magic
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IgnoreFile holds gitignore style patterns. Each one applies to the
+// directory it sits in and everything below it.
+const IgnoreFile = ".gdriveignore"
+
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore answers whether paths of a tree are excluded by the ignore files
+// found along the way. Ignore files are read lazily, once per directory.
+type Ignore struct {
+	root  string
+	mu    sync.Mutex
+	rules map[string][]ignorePattern
+}
+
+func NewIgnore(root string) *Ignore {
+	return &Ignore{root: filepath.Clean(root), rules: map[string][]ignorePattern{}}
+}
+
+// Match reports whether path (inside the root) is ignored. As in git, the
+// last matching pattern wins and deeper ignore files override shallower.
+func (ig *Ignore) Match(path string, isDir bool) bool {
+	path = filepath.Clean(path)
+	if path == ig.root {
+		return false
+	}
+	var dirs []string
+	for d := filepath.Dir(path); ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if d == ig.root || d == "." || d == string(filepath.Separator) {
+			break
+		}
+	}
+	ignored := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, p := range ig.load(dirs[i]) {
+			if p.dirOnly && !isDir {
+				continue
+			}
+			if p.re.MatchString(rel) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func (ig *Ignore) load(dir string) []ignorePattern {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+	if pats, ok := ig.rules[dir]; ok {
+		return pats
+	}
+	var pats []ignorePattern
+	if f, err := os.Open(filepath.Join(dir, IgnoreFile)); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if p, ok := parseIgnorePattern(scanner.Text()); ok {
+				pats = append(pats, p)
+			}
+		}
+		f.Close()
+	}
+	ig.rules[dir] = pats
+	return pats
+}
+
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	var p ignorePattern
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+	// a leading \# or \! is a literal one, which globToRegexp unescapes
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+	// A slash anywhere but the end anchors the pattern to its directory;
+	// otherwise it matches a name at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return p, false
+	}
+	p.re = re
+	return p, true
+}
+
+// globToRegexp translates gitignore globs, including "**", to a regexp.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// "**/" matches zero or more directories
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += j
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
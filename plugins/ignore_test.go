@@ -0,0 +1,133 @@
+package magic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIgnorePattern(t *testing.T) {
+	tests := []struct {
+		line    string
+		ok      bool
+		negate  bool
+		dirOnly bool
+	}{
+		{"", false, false, false},
+		{"   ", false, false, false},
+		{"# comment", false, false, false},
+		{`\#notes`, true, false, false},
+		{"*.log", true, false, false},
+		{"*.log  ", true, false, false},
+		{"!keep.log", true, true, false},
+		{`\!bang`, true, false, false},
+		{"build/", true, false, true},
+		{"!build/", true, true, true},
+		{"/", false, false, true},
+	}
+	for _, tt := range tests {
+		p, ok := parseIgnorePattern(tt.line)
+		if ok != tt.ok {
+			t.Errorf("%q: ok = %v, want %v", tt.line, ok, tt.ok)
+			continue
+		}
+		if ok && (p.negate != tt.negate || p.dirOnly != tt.dirOnly) {
+			t.Errorf("%q: negate %v dirOnly %v, want %v %v", tt.line, p.negate, p.dirOnly, tt.negate, tt.dirOnly)
+		}
+	}
+}
+
+func TestIgnorePatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.log", "a.log", true},
+		{"*.log", "deep/down/a.log", true},
+		{"*.log", "a.log.txt", false},
+		{"*.log", "logs/a", false},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"[ab].txt", "b.txt", true},
+		{"[!ab].txt", "b.txt", false},
+		{"[!ab].txt", "c.txt", true},
+		{"[oops", "[oops", true},
+		{`\#notes`, "#notes", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+		{`\!bang`, "!bang", true},
+		// a slash anchors the pattern to its directory
+		{"/top", "top", true},
+		{"/top", "sub/top", false},
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/sub/a.md", false},
+		{"docs/*.md", "x/docs/a.md", false},
+		{"**/tmp", "tmp", true},
+		{"**/tmp", "a/b/tmp", true},
+		{"a/**/b", "a/b", true},
+		{"a/**/b", "a/x/y/b", true},
+		{"a/**/b", "xa/b", false},
+		{"logs/**", "logs/a/b.txt", true},
+		{"logs/**", "logs", false},
+		{"a**z", "a/b/z", true},
+	}
+	for _, tt := range tests {
+		p, ok := parseIgnorePattern(tt.pattern)
+		if !ok {
+			t.Errorf("%q did not parse", tt.pattern)
+			continue
+		}
+		if got := p.re.MatchString(tt.path); got != tt.want {
+			t.Errorf("%q on %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreMatch(t *testing.T) {
+	root, err := ioutil.TempDir("", "ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	write := func(dir, content string) {
+		d := filepath.Join(root, dir)
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(d, IgnoreFile), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".", "# ignore logs\n*.log\n!keep.log\nbuild/\n/top.txt\n")
+	write("sub", "!sub.log\nkeep.log\n")
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{".", true, false},
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"x/keep.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"x/build", true, true},
+		{"top.txt", false, true},
+		{"x/top.txt", false, false},
+		// deeper ignore files override shallower ones
+		{"sub/sub.log", false, false},
+		{"sub/other.log", false, true},
+		{"sub/keep.log", false, true},
+		{"sub/deeper/keep.log", false, true},
+		{"other/keep.log", false, false},
+	}
+	ig := NewIgnore(root)
+	for _, tt := range tests {
+		if got := ig.Match(filepath.Join(root, tt.path), tt.isDir); got != tt.want {
+			t.Errorf("Match(%s, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package magic
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/drivelabels/v2"
+)
+
+// LabelsScope must be granted, in addition to the Drive scope, to look
+// labels up by name.
+const LabelsScope = drivelabels.DriveLabelsReadonlyScope
+
+type (
+	label = drivelabels.GoogleAppsDriveLabelsV2Label
+	field = drivelabels.GoogleAppsDriveLabelsV2Field
+)
+
+// Labels is the catalog of published Drive labels the user can apply,
+// used to turn human readable names into label, field and choice IDs.
+type Labels struct {
+	list []*label
+}
+
+func LoadLabels(ctx context.Context, client *http.Client) (*Labels, error) {
+	srv, err := drivelabels.New(client)
+	if err != nil {
+		return nil, err
+	}
+	l := &Labels{}
+	err = srv.Labels.List().PublishedOnly(true).View("LABEL_VIEW_FULL").MinimumRole("APPLIER").
+		Pages(ctx, func(r *drivelabels.GoogleAppsDriveLabelsV2ListLabelsResponse) error {
+			l.list = append(l.list, r.Labels...)
+			return nil
+		})
+	return l, err
+}
+
+// LabelSpec is a parsed "Label[.Field]=Value" with its IDs resolved.
+type LabelSpec struct {
+	Label *label
+	Field *field
+	Value string
+}
+
+// Resolve parses spec, e.g. "Confidentiality=Internal" or
+// "Contract.Status=Signed". Names match titles case-insensitively; the
+// field may be left out when the label has only one.
+func (l *Labels) Resolve(spec string) (*LabelSpec, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 {
+		return nil, fmt.Errorf("label %q is not Label[.Field]=Value", spec)
+	}
+	name, fieldName := kv[0], ""
+	if i := strings.Index(name, "."); i >= 0 {
+		name, fieldName = name[:i], name[i+1:]
+	}
+	s := &LabelSpec{Value: kv[1]}
+	for _, lb := range l.list {
+		if lb.Properties != nil && strings.EqualFold(lb.Properties.Title, name) {
+			s.Label = lb
+			break
+		}
+	}
+	if s.Label == nil {
+		return nil, fmt.Errorf("no label named %q", name)
+	}
+	for _, f := range s.Label.Fields {
+		if fieldName == "" && len(s.Label.Fields) == 1 ||
+			f.Properties != nil && strings.EqualFold(f.Properties.DisplayName, fieldName) {
+			s.Field = f
+		}
+	}
+	if s.Field == nil {
+		return nil, fmt.Errorf("label %q has no field %q", name, fieldName)
+	}
+	return s, nil
+}
+
+// value returns the field value as Drive stores it: a choice ID for
+// selection fields, the text otherwise.
+func (s *LabelSpec) value() (string, error) {
+	if s.Field.SelectionOptions == nil {
+		return s.Value, nil
+	}
+	for _, c := range s.Field.SelectionOptions.Choices {
+		if c.Properties != nil && strings.EqualFold(c.Properties.DisplayName, s.Value) || c.Id == s.Value {
+			return c.Id, nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a choice of %s", s.Value, s.Field.Properties.DisplayName)
+}
+
+// Modification is the change applying s to a file.
+func (s *LabelSpec) Modification() (*drive.LabelModification, error) {
+	v, err := s.value()
+	if err != nil {
+		return nil, err
+	}
+	fm := &drive.LabelFieldModification{FieldId: s.Field.Id}
+	switch f := s.Field; {
+	case f.SelectionOptions != nil:
+		fm.SetSelectionValues = []string{v}
+	case f.IntegerOptions != nil:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		fm.SetIntegerValues = []int64{n}
+	case f.DateOptions != nil:
+		fm.SetDateValues = []string{v} // YYYY-MM-DD
+	case f.UserOptions != nil:
+		fm.SetUserValues = []string{v} // email address
+	default:
+		fm.SetTextValues = []string{v}
+	}
+	return &drive.LabelModification{LabelId: s.Label.Id, FieldModifications: []*drive.LabelFieldModification{fm}}, nil
+}
+
+// Query is the Drive search clause matching files carrying s.
+func (s *LabelSpec) Query() (string, error) {
+	v, err := s.value()
+	if err != nil {
+		return "", err
+	}
+	key := s.Field.QueryKey
+	if key == "" {
+		key = "labels/" + s.Label.Id + "." + s.Field.Id
+	}
+	if s.Field.IntegerOptions != nil {
+		return key + " = " + v, nil
+	}
+	return key + " = '" + QueryString(v) + "'", nil
+}
+
+// ApplyLabels sets the given label values on the file id.
+func ApplyLabels(ctx context.Context, srv *drive.Service, id string, specs []*LabelSpec) error {
+	req := &drive.ModifyLabelsRequest{}
+	for _, s := range specs {
+		m, err := s.Modification()
+		if err != nil {
+			return err
+		}
+		req.LabelModifications = append(req.LabelModifications, m)
+	}
+	if len(req.LabelModifications) == 0 {
+		return nil
+	}
+	_, err := srv.Files.ModifyLabels(id, req).Context(ctx).Do()
+	return err
+}
+
+// FileLabels describes the labels applied to the file id as
+// "Label.Field=value" strings, using the catalog names where known.
+func (l *Labels) FileLabels(ctx context.Context, srv *drive.Service, id string) ([]string, error) {
+	var out []string
+	err := srv.Files.ListLabels(id).Pages(ctx, func(r *drive.LabelList) error {
+		for _, applied := range r.Labels {
+			for fid, f := range applied.Fields {
+				vals := append(append(append([]string(nil), f.Selection...), f.Text...), f.DateString...)
+				for _, n := range f.Integer {
+					vals = append(vals, strconv.FormatInt(n, 10))
+				}
+				for _, u := range f.User {
+					vals = append(vals, u.EmailAddress)
+				}
+				out = append(out, l.describe(applied.Id, fid, vals))
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+func (l *Labels) describe(labelID string, fieldID string, vals []string) string {
+	name, fname := labelID, fieldID
+	for _, lb := range l.list {
+		if lb.Id != labelID {
+			continue
+		}
+		if lb.Properties != nil {
+			name = lb.Properties.Title
+		}
+		for _, f := range lb.Fields {
+			if f.Id != fieldID {
+				continue
+			}
+			if f.Properties != nil {
+				fname = f.Properties.DisplayName
+			}
+			if f.SelectionOptions != nil {
+				for i, v := range vals {
+					for _, c := range f.SelectionOptions.Choices {
+						if c.Id == v && c.Properties != nil {
+							vals[i] = c.Properties.DisplayName
+						}
+					}
+				}
+			}
+		}
+	}
+	return name + "." + fname + "=" + strings.Join(vals, ",")
+}
@@ -0,0 +1,84 @@
+package magic
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Drive cannot expire anyone-with-link permissions, so ShareLink records
+// the expiry in appProperties and RevokeExpiredLinks enforces it.
+const (
+	linkSharedKey  = "link.shared"
+	linkExpiresKey = "link.expires"
+	linkPermKey    = "link.permission"
+)
+
+// Links fetches the view and download links of the file id.
+func Links(ctx context.Context, srv *drive.Service, id string) (*drive.File, error) {
+	return srv.Files.Get(id).Fields("id, name, webViewLink, webContentLink").
+		SupportsAllDrives(true).Context(ctx).Do()
+}
+
+// ShareLink gives anyone with the link role ("reader" or "commenter")
+// access to the file id. A non-zero expires is enforced by
+// RevokeExpiredLinks.
+func ShareLink(ctx context.Context, srv *drive.Service, id string, role string, expires time.Time) error {
+	p, err := srv.Permissions.Create(id, &drive.Permission{Type: "anyone", Role: role}).
+		Fields("id").SupportsAllDrives(true).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if expires.IsZero() {
+		return nil
+	}
+	props := map[string]string{
+		linkSharedKey:  "1",
+		linkExpiresKey: expires.UTC().Format(time.RFC3339),
+		linkPermKey:    p.Id,
+	}
+	_, err = srv.Files.Update(id, &drive.File{AppProperties: props}).Fields("id").
+		SupportsAllDrives(true).Context(ctx).Do()
+	return err
+}
+
+// ShareWith gives the account email role access to the file id; Drive
+// itself removes it at expires when that is set.
+func ShareWith(ctx context.Context, srv *drive.Service, id string, email string, role string, expires time.Time) error {
+	p := &drive.Permission{Type: "user", Role: role, EmailAddress: email}
+	if !expires.IsZero() {
+		p.ExpirationTime = expires.UTC().Format(time.RFC3339)
+	}
+	_, err := srv.Permissions.Create(id, p).SendNotificationEmail(false).Fields("id").
+		SupportsAllDrives(true).Context(ctx).Do()
+	return err
+}
+
+// RevokeExpiredLinks removes anyone-with-link access shared by ShareLink
+// whose expiry has passed, returning the affected file IDs.
+func RevokeExpiredLinks(ctx context.Context, srv *drive.Service) ([]string, error) {
+	q := "appProperties has { key='" + linkSharedKey + "' and value='1' } and trashed=false"
+	it := ListIter(ctx, srv, q, "id, appProperties")
+	var revoked []string
+	for it.Next() {
+		f := it.File()
+		exp, err := time.Parse(time.RFC3339, f.AppProperties[linkExpiresKey])
+		if err != nil || time.Now().Before(exp) {
+			continue
+		}
+		err = srv.Permissions.Delete(f.Id, f.AppProperties[linkPermKey]).SupportsAllDrives(true).Context(ctx).Do()
+		if err == nil || !isNotFound(err) {
+			auditLocal("unshare", f.Id, "", map[string]string{"reason": "expired", "permission": f.AppProperties[linkPermKey]}, err)
+		}
+		if err != nil && !isNotFound(err) {
+			return revoked, err
+		}
+		upd := &drive.File{AppProperties: map[string]string{linkSharedKey: "0"}}
+		if _, err = srv.Files.Update(f.Id, upd).Fields("id").SupportsAllDrives(true).Context(ctx).Do(); err != nil {
+			return revoked, err
+		}
+		revoked = append(revoked, f.Id)
+	}
+	return revoked, it.Err()
+}
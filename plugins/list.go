@@ -0,0 +1,120 @@
+package magic
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// ListFields are the file attributes fetched by listings unless asked
+// otherwise.
+const ListFields = FileFields + ", parents"
+
+const listPageSize = 1000
+
+var queryEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// QueryString escapes s, backslashes and quotes, for a single quoted
+// string of a Drive search.
+func QueryString(s string) string {
+	return queryEscaper.Replace(s)
+}
+
+// ListIterator walks the results of a Drive search page by page, fetching
+// the next page only when the current one is used up.
+//
+//	it := magic.ListIter(ctx, srv, "trashed=false")
+//	for it.Next() {
+//		f := it.File()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type ListIterator struct {
+	call  *drive.FilesListCall
+	ctx   context.Context
+	page  []*drive.File
+	pos   int
+	token string
+	done  bool
+	err   error
+}
+
+// ListIter lists the files matching query. fields selects the file
+// attributes to return, ListFields if empty.
+func ListIter(ctx context.Context, srv *drive.Service, query string, fields ...string) *ListIterator {
+	f := ListFields
+	if len(fields) > 0 {
+		f = fields[0]
+	}
+	call := srv.Files.List().Q(query).PageSize(listPageSize).
+		Fields(googleapi.Field("nextPageToken, files(" + f + ")"))
+	return &ListIterator{call: call, ctx: ctx, pos: -1}
+}
+
+// Call exposes the underlying list call so callers can set corpora,
+// ordering and the like before the first Next.
+func (it *ListIterator) Call() *drive.FilesListCall {
+	return it.call
+}
+
+// Next advances to the next file, reporting false at the end or on error.
+func (it *ListIterator) Next() bool {
+	it.pos++
+	for it.pos >= len(it.page) {
+		if it.done || it.err != nil {
+			return false
+		}
+		if it.token != "" {
+			it.call.PageToken(it.token)
+		}
+		r, err := it.call.Context(it.ctx).Do()
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.pos, it.token = r.Files, 0, r.NextPageToken
+		it.done = it.token == ""
+	}
+	return true
+}
+
+// File returns the current file.
+func (it *ListIterator) File() *drive.File {
+	return it.page[it.pos]
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *ListIterator) Err() error {
+	return Classify(it.err)
+}
+
+// ListPage returns one page of at most size files directly in the Drive
+// folder id, folders first and then by name, and the token of the next
+// page, empty after the last.
+func ListPage(ctx context.Context, srv *drive.Service, id string, token string, size int64) ([]*drive.File, string, error) {
+	call := srv.Files.List().Q("'" + id + "' in parents and trashed=false").
+		OrderBy("folder,name").PageSize(size).
+		Fields(googleapi.Field("nextPageToken, files(" + FileFields + ")"))
+	if token != "" {
+		call.PageToken(token)
+	}
+	r, err := call.Context(ctx).Do()
+	if err != nil {
+		return nil, "", Classify(err)
+	}
+	return r.Files, r.NextPageToken, nil
+}
+
+// Count returns the number of files matching query and their total size,
+// fetching nothing else.
+func Count(ctx context.Context, srv *drive.Service, query string) (int, int64, error) {
+	n, bytes := 0, int64(0)
+	it := ListIter(ctx, srv, query, "size")
+	for it.Next() {
+		n++
+		bytes += it.File().Size
+	}
+	return n, bytes, it.Err()
+}
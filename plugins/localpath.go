@@ -0,0 +1,190 @@
+package magic
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// caseInsensitiveFS is set where local file names differ by case only in
+// their spelling, as on Windows and by default on macOS.
+var caseInsensitiveFS = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+// maxName is the longest file name, in bytes, most file systems take.
+const maxName = 255
+
+// LocalName turns the Drive file name name into one valid locally. Slashes
+// and NUL bytes become "_", names longer than 255 bytes are cut short with
+// a digest of the whole name kept, so cut names stay apart, and the rules
+// of the platform apply on top. The same name always maps the same way.
+func LocalName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == 0 {
+			return '_'
+		}
+		return r
+	}, name)
+	switch name {
+	case "", ".", "..":
+		name = strings.Replace(name, ".", "_", -1) + "_"
+	}
+	return platformName(limitName(name, name))
+}
+
+// limitName cuts name to maxName bytes, keeping its extension and adding a
+// digest of full.
+func limitName(name string, full string) string {
+	if len(name) <= maxName {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > 32 {
+		ext = ""
+	}
+	sum := sha1.Sum([]byte(full))
+	tag := "~" + hex.EncodeToString(sum[:4])
+	base := name[:maxName-len(tag)-len(ext)]
+	// do not split a UTF-8 sequence
+	for !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return base + tag + ext
+}
+
+// LocalPath turns the slash separated path of a Drive file into the one it
+// is stored as locally, each name made valid with LocalName.
+func LocalPath(p string) string {
+	if p == "" {
+		return p
+	}
+	parts := strings.Split(p, "/")
+	for i, name := range parts {
+		parts[i] = LocalName(name)
+	}
+	return path.Join(parts...)
+}
+
+// NameMapName is the file a downloaded folder records its renamed entries
+// in.
+const NameMapName = ".magicnames.json"
+
+// NameMap records, by local slash separated path, the Drive files whose
+// local name differs from their name on Drive.
+type NameMap map[string]NameRecord
+
+type NameRecord struct {
+	ID   string `json:"id"`
+	Name string `json:"name"` // on Drive
+}
+
+// LocalTree lists the Drive folder id recursively like RemoteTree, but
+// keyed by the paths its files are downloaded to: names are made valid
+// with LocalName, and names that then clash within a folder, including
+// the duplicates Drive allows, are told apart by a " (2)" style suffix,
+// handed out in the order of name and ID. Every entry renamed is
+// recorded in the NameMap.
+func LocalTree(ctx context.Context, srv *drive.Service, id string) (map[string]*drive.File, NameMap, error) {
+	tree := map[string]*drive.File{}
+	names := NameMap{}
+	queue := []string{""}
+	folders := map[string]string{"": id}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		var files []*drive.File
+		it := ListIter(ctx, srv, "'"+folders[dir]+"' in parents and trashed=false")
+		for it.Next() {
+			files = append(files, it.File())
+		}
+		if err := it.Err(); err != nil {
+			return nil, nil, err
+		}
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].Name != files[j].Name {
+				return files[i].Name < files[j].Name
+			}
+			return files[i].Id < files[j].Id
+		})
+		taken := map[string]bool{}
+		for _, f := range files {
+			name := LocalName(f.Name)
+			for n := 2; taken[foldName(name)]; n++ {
+				name = numbered(LocalName(f.Name), n, f.MimeType == FolderMime)
+			}
+			taken[foldName(name)] = true
+			p := path.Join(dir, name)
+			tree[p] = f
+			if name != f.Name {
+				names[p] = NameRecord{ID: f.Id, Name: f.Name}
+			}
+			if f.MimeType == FolderMime {
+				folders[p] = f.Id
+				queue = append(queue, p)
+			}
+		}
+	}
+	return tree, names, nil
+}
+
+// numbered makes "a (n).txt" of the local name "a.txt"; folders have no
+// extension.
+func numbered(name string, n int, folder bool) string {
+	ext := ""
+	if !folder {
+		ext = path.Ext(name)
+	}
+	full := fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+	return limitName(full, full)
+}
+
+// LoadNameMap reads the NameMap of the local folder dir; a folder without
+// one has none renamed.
+func LoadNameMap(dir string) (NameMap, error) {
+	m := NameMap{}
+	b, err := ioutil.ReadFile(filepath.Join(dir, NameMapName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(b, &m)
+}
+
+// Save writes m into the local folder dir, or removes a stale file when m
+// is empty.
+func (m NameMap) Save(dir string) error {
+	file := filepath.Join(dir, NameMapName)
+	if len(m) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// DriveName returns the name on Drive of the file downloaded to the local
+// path p.
+func (m NameMap) DriveName(p string) string {
+	if r, ok := m[p]; ok {
+		return r.Name
+	}
+	return path.Base(p)
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package magic
+
+// LongPath returns p; only Windows limits path lengths this way.
+func LongPath(p string) string {
+	return p
+}
+
+// platformName returns name; LocalName already made it valid here.
+func platformName(name string) string {
+	return name
+}
@@ -0,0 +1,58 @@
+//go:build windows
+// +build windows
+
+package magic
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath leaves room below MAX_PATH for the 8.3 name Windows may append.
+const maxPath = 248
+
+// LongPath returns p in the \\?\ form that lifts the MAX_PATH limit when
+// it is too long for the plain form.
+func LongPath(p string) string {
+	if len(p) < maxPath || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// platformName makes name valid on Windows: characters it forbids become
+// "_", trailing dots and spaces are dropped and device names such as CON
+// or nul.txt get a "_" appended to their base.
+func platformName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = base + "_" + name[len(base):]
+	}
+	return name
+}
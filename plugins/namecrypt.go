@@ -0,0 +1,133 @@
+package magic
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Names, when set, encrypts the names of the files and folders uploaded,
+// so the Drive listing tells nothing about them, and decrypts them again
+// for listings and downloads. Names Drive holds in the clear are left as
+// they are.
+var Names *NameKey
+
+// NameKey encrypts names deterministically: a name always encrypts to the
+// same text under one key, so uploads keep finding the folders of earlier
+// runs by name. That also shows which files share a name, which is the
+// price of it. The extension can be kept in the clear, for previews and
+// type detection.
+type NameKey struct {
+	// KeepExtension leaves the extension of file names readable.
+	KeepExtension bool
+
+	block cipher.Block
+	mac   []byte
+}
+
+// nameSalt is fixed so a passphrase gives the same key everywhere.
+var nameSalt = []byte("magicserver name encryption")
+
+// encoding is lower case and without padding, as names are often
+// compared case-insensitively.
+var nameEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ErrNotEncrypted is returned by Decrypt for names not encrypted under
+// the key.
+var ErrNotEncrypted = errors.New("name is not encrypted with this key")
+
+// NewNameKey derives a NameKey from passphrase.
+func NewNameKey(passphrase []byte) (*NameKey, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty name passphrase")
+	}
+	k, err := scrypt.Key(passphrase, nameSalt, 1<<15, 8, 1, 64)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(k[:32])
+	if err != nil {
+		return nil, err
+	}
+	return &NameKey{block: block, mac: k[32:]}, nil
+}
+
+// LoadNameKey derives the NameKey of the passphrase on the first line of
+// keyFile.
+func LoadNameKey(keyFile string) (*NameKey, error) {
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read name key file: %v", err)
+	}
+	return NewNameKey(bytes.TrimRight(bytes.SplitN(b, []byte("\n"), 2)[0], "\r"))
+}
+
+// Encrypt returns the encrypted form of name, or name with a nil key. The
+// synthetic IV is an HMAC of the name, as in SIV mode, and doubles as its
+// authentication tag.
+func (k *NameKey) Encrypt(name string) string {
+	if k == nil {
+		return name
+	}
+	ext := ""
+	// dot files are all extension
+	if k.KeepExtension && path.Ext(name) != name {
+		ext = path.Ext(name)
+		name = strings.TrimSuffix(name, ext)
+	}
+	iv := k.tag([]byte(name))
+	out := make([]byte, len(iv)+len(name))
+	copy(out, iv)
+	cipher.NewCTR(k.block, iv).XORKeyStream(out[len(iv):], []byte(name))
+	return nameEncoding.EncodeToString(out) + ext
+}
+
+// Decrypt reverses Encrypt, failing with ErrNotEncrypted for names that
+// were not encrypted under k.
+func (k *NameKey) Decrypt(name string) (string, error) {
+	if k == nil {
+		return name, nil
+	}
+	ext := ""
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		if !k.KeepExtension {
+			return "", ErrNotEncrypted
+		}
+		name, ext = name[:i], name[i:]
+	}
+	b, err := nameEncoding.DecodeString(name)
+	if err != nil || len(b) < aes.BlockSize {
+		return "", ErrNotEncrypted
+	}
+	iv, plain := b[:aes.BlockSize], make([]byte, len(b)-aes.BlockSize)
+	cipher.NewCTR(k.block, iv).XORKeyStream(plain, b[aes.BlockSize:])
+	if !hmac.Equal(iv, k.tag(plain)) {
+		return "", ErrNotEncrypted
+	}
+	return string(plain) + ext, nil
+}
+
+// Display is Decrypt for showing names: names that are not encrypted are
+// returned unchanged.
+func (k *NameKey) Display(name string) string {
+	if plain, err := k.Decrypt(name); err == nil {
+		return plain
+	}
+	return name
+}
+
+func (k *NameKey) tag(name []byte) []byte {
+	h := hmac.New(sha256.New, k.mac)
+	h.Write(name)
+	return h.Sum(nil)[:aes.BlockSize]
+}
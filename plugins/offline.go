@@ -0,0 +1,142 @@
+package magic
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/net/context"
+)
+
+// Runs that cannot reach Google are recorded in StateDB instead of
+// failing, and replayed once the network is back, so a laptop on a flaky
+// connection is never held up by it.
+
+var offlineBucket = []byte("offline")
+
+// OfflineOp is a run recorded while offline.
+type OfflineOp struct {
+	ID     uint64    `json:"id"`
+	Op     string    `json:"op"`   // "upload" or "sync"
+	Args   []string  `json:"args"` // command line, without the program
+	Dir    string    `json:"dir"`  // working directory of the run
+	Path   string    `json:"path"` // local file or directory
+	Folder string    `json:"folder,omitempty"`
+	Title  string    `json:"title,omitempty"`
+	Queued time.Time `json:"queued"`
+}
+
+// Reachable returns nil when the Google API hosts, or the HTTPS proxy in
+// between, accept connections.
+func Reachable() error {
+	for _, host := range googleHosts {
+		addr := net.JoinHostPort(host, "443")
+		req := &http.Request{URL: &url.URL{Scheme: "https", Host: host}}
+		if proxy, err := http.ProxyFromEnvironment(req); err == nil && proxy != nil {
+			addr = proxy.Host
+			if proxy.Port() == "" {
+				port := "80"
+				if proxy.Scheme == "https" {
+					port = "443"
+				}
+				addr = net.JoinHostPort(proxy.Hostname(), port)
+			}
+		}
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	return nil
+}
+
+// WaitOnline polls Reachable every interval until it succeeds or ctx is
+// done.
+func WaitOnline(ctx context.Context, interval time.Duration) error {
+	for Reachable() != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	return nil
+}
+
+// QueueOffline records op, returning it with its ID.
+func QueueOffline(op OfflineOp) (OfflineOp, error) {
+	db, err := openStateDB()
+	if err != nil {
+		return op, err
+	}
+	defer db.Close()
+	op.Queued = time.Now()
+	err = db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists(offlineBucket)
+		if err != nil {
+			return err
+		}
+		if op.ID, err = bk.NextSequence(); err != nil {
+			return err
+		}
+		b, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		return bk.Put(offlineKey(op.ID), b)
+	})
+	return op, err
+}
+
+// OfflineOps returns the recorded runs, oldest first.
+func OfflineOps() ([]OfflineOp, error) {
+	db, err := openStateDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var ops []OfflineOp
+	err = db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(offlineBucket)
+		if bk == nil {
+			return nil
+		}
+		return bk.ForEach(func(k, v []byte) error {
+			var op OfflineOp
+			if err := json.Unmarshal(v, &op); err != nil {
+				return fmt.Errorf("unable to read offline operation %x: %v", k, err)
+			}
+			ops = append(ops, op)
+			return nil
+		})
+	})
+	return ops, err
+}
+
+// DropOffline forgets the run with the given ID, once replayed or given up.
+func DropOffline(id uint64) error {
+	db, err := openStateDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		if bk := tx.Bucket(offlineBucket); bk != nil {
+			return bk.Delete(offlineKey(id))
+		}
+		return nil
+	})
+}
+
+// offlineKey is big endian, so ForEach goes in the order runs were queued.
+func offlineKey(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
@@ -0,0 +1,65 @@
+package magic
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// RecoveredName is the folder in My Drive orphans are moved into.
+const RecoveredName = "Recovered"
+
+// Orphans returns the files the user owns that no folder they can reach
+// holds: those left without parents, as when the folder they were in was
+// deleted by someone else, and those whose parents are gone. Of an
+// orphaned folder only the folder is returned, not what it holds.
+// Orphans use storage quota but show nowhere in the Drive UI.
+func Orphans(ctx context.Context, srv *drive.Service) ([]*drive.File, error) {
+	reachable := map[string]bool{}
+	var list []*drive.File
+	it := ListIter(ctx, srv, "'me' in owners and trashed=false")
+	for it.Next() {
+		f := it.File()
+		orphan := true
+		for _, p := range f.Parents {
+			ok, seen := reachable[p]
+			if !seen {
+				_, err := srv.Files.Get(p).Fields("id").Context(ctx).Do()
+				if err != nil && !isNotFound(err) {
+					return nil, Classify(err)
+				}
+				ok = err == nil
+				reachable[p] = ok
+			}
+			if ok {
+				orphan = false
+				break
+			}
+		}
+		if orphan {
+			list = append(list, f)
+		}
+	}
+	return list, it.Err()
+}
+
+// Recover moves the orphans in list into the RecoveredName folder of My
+// Drive, creating it when needed, and returns its ID.
+func Recover(ctx context.Context, srv *drive.Service, list []*drive.File) (string, error) {
+	id, _, err := GetOrCreateFolder(ctx, srv, "root", RecoveredName)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range list {
+		call := srv.Files.Update(f.Id, &drive.File{}).AddParents(id)
+		if len(f.Parents) > 0 {
+			call.RemoveParents(strings.Join(f.Parents, ","))
+		}
+		if _, err = call.Fields("id").Context(ctx).Do(); err != nil {
+			return id, fmt.Errorf("%s: %v", Names.Display(f.Name), Classify(err))
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,200 @@
+package magic
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// Small files are bundled into plain tar packs so each costs part of an
+// upload instead of two round trips. The manifest maps every packed path
+// to its pack and the byte range of its data, so single files can be
+// fetched back with one ranged download.
+
+const (
+	PackManifestName = ".magicpack-manifest.json"
+	packNameFormat   = ".magicpack-%04d.tar"
+)
+
+type PackEntry struct {
+	Path    string    `json:"path"`
+	Pack    string    `json:"pack"` // Drive ID of the tar
+	Offset  int64     `json:"offset"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+type PackManifest struct {
+	Packs   []string    `json:"packs"`
+	Entries []PackEntry `json:"entries"`
+}
+
+// Packer collects small files into packs of about limit bytes each and
+// uploads them into the Drive folder parent.
+type Packer struct {
+	client *http.Client
+	parent string
+	limit  int64
+
+	manifest PackManifest
+	tmp      *os.File
+	tw       *tar.Writer
+	written  countWriter
+	entries  []PackEntry // entries of the open pack
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func NewPacker(client *http.Client, parent string, limit int64) *Packer {
+	return &Packer{client: client, parent: parent, limit: limit}
+}
+
+// Add appends the local file path to the open pack as name.
+func (p *Packer) Add(name string, path string) error {
+	if p.tw == nil {
+		tmp, err := ioutil.TempFile("", "magicpack-")
+		if err != nil {
+			return err
+		}
+		p.tmp = tmp
+		p.written = countWriter{w: tmp}
+		p.tw = tar.NewWriter(&p.written)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(name)
+	if err = p.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	offset := p.written.n
+	n, err := io.Copy(p.tw, in)
+	if err != nil {
+		return err
+	}
+	p.entries = append(p.entries, PackEntry{Path: hdr.Name, Offset: offset, Size: n, ModTime: info.ModTime()})
+	if p.written.n >= p.limit {
+		return p.flush()
+	}
+	return nil
+}
+
+// flush uploads the open pack and records its entries.
+func (p *Packer) flush() error {
+	if p.tw == nil {
+		return nil
+	}
+	defer func() {
+		p.tmp.Close()
+		os.Remove(p.tmp.Name())
+		p.tw, p.tmp, p.entries = nil, nil, nil
+	}()
+	if err := p.tw.Close(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf(packNameFormat, len(p.manifest.Packs)+1)
+	f := &drive.File{Name: name, MimeType: "application/x-tar", Parents: []string{p.parent}}
+	s, err := NewSession(p.client, f, p.written.n)
+	if err != nil {
+		return err
+	}
+	uploaded, err := sendSession(s, p.tmp.Name())
+	if err != nil {
+		return err
+	}
+	p.manifest.Packs = append(p.manifest.Packs, uploaded.Id)
+	for _, e := range p.entries {
+		e.Pack = uploaded.Id
+		p.manifest.Entries = append(p.manifest.Entries, e)
+	}
+	return nil
+}
+
+// Close uploads the last pack and the manifest, returning the manifest's
+// Drive file.
+func (p *Packer) Close() (*drive.File, error) {
+	if err := p.flush(); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(p.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	f := &drive.File{Name: PackManifestName, MimeType: "application/json", Parents: []string{p.parent}}
+	s, err := NewSession(p.client, f, int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = s.Write(b); err != nil {
+		return nil, err
+	}
+	return s.Close()
+}
+
+// ReadPackManifest downloads and decodes the manifest with the given ID.
+func ReadPackManifest(srv *drive.Service, id string) (*PackManifest, error) {
+	res, err := srv.Files.Get(id).Download()
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	m := &PackManifest{}
+	return m, json.NewDecoder(res.Body).Decode(m)
+}
+
+// Unpack fetches a single packed file into dest with a ranged download.
+func Unpack(srv *drive.Service, e PackEntry, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if e.Size > 0 {
+		call := srv.Files.Get(e.Pack)
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-%d", e.Offset, e.Offset+e.Size-1))
+		res, err := call.Download()
+		if err != nil {
+			out.Close()
+			return err
+		}
+		_, err = io.CopyN(out, res.Body, e.Size)
+		res.Body.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dest, e.ModTime, e.ModTime)
+}
@@ -0,0 +1,62 @@
+package magic
+
+import "sync"
+
+// Transfers can be paused process wide: chunk uploads and ranged
+// downloads block before their next request until resumed, while open
+// sessions stay valid on the Drive side. Transfers are also held, apart
+// from any pause, while the network is down.
+var pause = struct {
+	sync.Mutex
+	paused  bool
+	offline bool
+	resume  chan struct{}
+}{resume: make(chan struct{})}
+
+// PauseTransfers stops new chunks from being issued.
+func PauseTransfers() {
+	pause.Lock()
+	pause.paused = true
+	pause.Unlock()
+}
+
+// ResumeTransfers lets paused transfers continue.
+func ResumeTransfers() {
+	pause.Lock()
+	pause.paused = false
+	release()
+	pause.Unlock()
+}
+
+func TransfersPaused() bool {
+	pause.Lock()
+	defer pause.Unlock()
+	return pause.paused
+}
+
+// TransfersOffline reports whether transfers are held for a network
+// outage.
+func TransfersOffline() bool {
+	pause.Lock()
+	defer pause.Unlock()
+	return pause.offline
+}
+
+// release wakes the waiting transfers once nothing holds them. Callers
+// hold pause.
+func release() {
+	if !pause.paused && !pause.offline {
+		close(pause.resume)
+		pause.resume = make(chan struct{})
+	}
+}
+
+// waitTransfers blocks while transfers are paused or offline.
+func waitTransfers() {
+	pause.Lock()
+	held, resume := pause.paused || pause.offline, pause.resume
+	pause.Unlock()
+	if held {
+		<-resume
+	}
+}
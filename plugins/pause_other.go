@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package magic
+
+// HandlePauseSignals is a no-op where job control signals do not exist.
+func HandlePauseSignals() {}
@@ -0,0 +1,29 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package magic
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandlePauseSignals makes SIGTSTP (^Z) pause transfers instead of
+// stopping the process, and SIGCONT resume them.
+func HandlePauseSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTSTP, syscall.SIGCONT)
+	go func() {
+		for sig := range c {
+			if sig == syscall.SIGTSTP {
+				log.Println("transfers paused, send SIGCONT to resume")
+				PauseTransfers()
+			} else {
+				log.Println("transfers resumed")
+				ResumeTransfers()
+			}
+		}
+	}()
+}
@@ -0,0 +1,191 @@
+package magic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/net/context"
+)
+
+// Google Photos has its own API: bytes are uploaded for an upload token,
+// and tokens are then registered as media items in batches. There is no
+// generated Go client for it, so requests are made by hand.
+
+// PhotosScope must be granted for the Photos calls to work. It only
+// allows adding items and albums, not reading the library.
+const PhotosScope = "https://www.googleapis.com/auth/photoslibrary.appendonly"
+
+var photosURL = "https://photoslibrary.googleapis.com/v1/"
+
+// photosBatch is the most media items one batchCreate call accepts.
+const photosBatch = 50
+
+// PhotoResult is the outcome of adding one local file to Photos.
+type PhotoResult struct {
+	Path string
+	ID   string // media item ID
+	URL  string // the item's page in Google Photos
+	Err  error
+}
+
+// CreateAlbum creates an album titled title and returns its ID.
+func CreateAlbum(ctx context.Context, client *http.Client, title string) (string, error) {
+	req := map[string]interface{}{"album": map[string]string{"title": title}}
+	var res struct {
+		ID string `json:"id"`
+	}
+	if err := photosCall(ctx, client, "albums", req, &res); err != nil {
+		return "", fmt.Errorf("unable to create album: %v", err)
+	}
+	return res.ID, nil
+}
+
+// UploadPhotos adds the local image and video files paths to the Photos
+// library, and to the album albumID when it is set. done is called once
+// per file as its media item is registered or fails.
+func UploadPhotos(ctx context.Context, client *http.Client, albumID string, paths []string, done func(PhotoResult)) {
+	for start := 0; start < len(paths); start += photosBatch {
+		end := start + photosBatch
+		if end > len(paths) {
+			end = len(paths)
+		}
+		var batch []string
+		var tokens []string
+		for _, p := range paths[start:end] {
+			tok, err := uploadPhotoBytes(ctx, client, p)
+			if err != nil {
+				done(PhotoResult{Path: p, Err: err})
+				continue
+			}
+			batch, tokens = append(batch, p), append(tokens, tok)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		for _, r := range registerPhotos(ctx, client, albumID, batch, tokens) {
+			done(r)
+		}
+	}
+}
+
+// uploadPhotoBytes sends the content of path and returns its upload token.
+func uploadPhotoBytes(ctx context.Context, client *http.Client, path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", photosURL+"uploads", in)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Goog-Upload-Protocol", "raw")
+	req.Header.Set("X-Goog-Upload-File-Name", filepath.Base(path))
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to upload to Photos: %s %s", res.Status, b)
+	}
+	return string(b), nil
+}
+
+// registerPhotos turns upload tokens into media items, one result per path.
+func registerPhotos(ctx context.Context, client *http.Client, albumID string, paths []string, tokens []string) []PhotoResult {
+	type simpleItem struct {
+		UploadToken string `json:"uploadToken"`
+		FileName    string `json:"fileName"`
+	}
+	type newItem struct {
+		SimpleMediaItem simpleItem `json:"simpleMediaItem"`
+	}
+	req := struct {
+		AlbumID       string    `json:"albumId,omitempty"`
+		NewMediaItems []newItem `json:"newMediaItems"`
+	}{AlbumID: albumID}
+	for i, p := range paths {
+		req.NewMediaItems = append(req.NewMediaItems, newItem{simpleItem{tokens[i], filepath.Base(p)}})
+	}
+	var res struct {
+		NewMediaItemResults []struct {
+			UploadToken string `json:"uploadToken"`
+			Status      struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"status"`
+			MediaItem struct {
+				ID         string `json:"id"`
+				ProductURL string `json:"productUrl"`
+			} `json:"mediaItem"`
+		} `json:"newMediaItemResults"`
+	}
+	results := make([]PhotoResult, len(paths))
+	err := photosCall(ctx, client, "mediaItems:batchCreate", req, &res)
+	for i, p := range paths {
+		results[i] = PhotoResult{Path: p, Err: err}
+		if err == nil {
+			results[i].Err = errors.New("no media item created")
+		}
+	}
+	if err != nil {
+		return results
+	}
+	byToken := map[string]int{}
+	for i, tok := range tokens {
+		byToken[tok] = i
+	}
+	for _, r := range res.NewMediaItemResults {
+		i, ok := byToken[r.UploadToken]
+		if !ok {
+			continue
+		}
+		if r.Status.Code != 0 {
+			results[i].Err = fmt.Errorf("unable to create media item: %s", r.Status.Message)
+			continue
+		}
+		results[i].ID, results[i].URL, results[i].Err = r.MediaItem.ID, r.MediaItem.ProductURL, nil
+	}
+	return results
+}
+
+// photosCall POSTs in as JSON to the Photos API method and decodes the
+// response into out.
+func photosCall(ctx context.Context, client *http.Client, method string, in interface{}, out interface{}) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", photosURL+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s %s", res.Status, b)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
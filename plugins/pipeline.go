@@ -0,0 +1,233 @@
+package magic
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// UploadJob is a local file to upload with its prepared Drive metadata.
+type UploadJob struct {
+	Path string
+	File *drive.File
+	Size int64
+	// SessionURI, when set, is a resumable session to continue instead of
+	// opening a new one.
+	SessionURI string
+	// ContentType, when set, is the type of the content if it differs
+	// from File.MimeType.
+	ContentType string
+	// PublishAs, when set, is the name File was staged for: the caller
+	// renames the upload to it once verified, as with StagingName.
+	PublishAs string
+	// Bandwidth, when set, paces this job's upload.
+	Bandwidth *Bandwidth `json:"-"`
+	// Progress, when set, is told how the upload goes.
+	Progress ProgressReporter `json:"-"`
+}
+
+type UploadResult struct {
+	Job  UploadJob
+	File *drive.File
+	Err  error
+}
+
+type preparedUpload struct {
+	job     UploadJob
+	session *Session
+	err     error
+}
+
+// PipelineUpload uploads jobs over transfers concurrent content streams.
+// Resumable sessions (the metadata round trip) are opened ahead of time
+// by a separate stage, so a worker finishing one file can start sending
+// the next one's bytes immediately. done is called once per job, from the
+// worker goroutines. Once Drive answers that a quota is exhausted, jobs
+// not started yet are left alone, done is not called for them, and the
+// quota error is returned.
+func PipelineUpload(client *http.Client, jobs []UploadJob, transfers int, done func(UploadResult)) error {
+	return pipeline(client, jobs, transfers, nil, done)
+}
+
+// pipeline is PipelineUpload with opened called as each session is
+// ready, before any of its bytes are sent.
+func pipeline(client *http.Client, jobs []UploadJob, transfers int, opened func(UploadJob, *Session), done func(UploadResult)) error {
+	if transfers < 1 {
+		transfers = 1
+	}
+	ahead := 2 * transfers
+
+	pending := make(chan UploadJob)
+	prepared := make(chan preparedUpload, ahead)
+	var stopOnce sync.Once
+	var stopErr error
+	stop := make(chan struct{})
+	check := func(err error) bool {
+		if errors.Is(err, ErrQuotaExceeded) {
+			stopOnce.Do(func() {
+				stopErr = err
+				close(stop)
+			})
+		}
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// hooks may change what is uploaded, so with them sessions are only
+	// opened once they ran
+	hooked := len(transferHooks()) > 0
+
+	var prep sync.WaitGroup
+	for i := 0; i < ahead; i++ {
+		prep.Add(1)
+		go func() {
+			defer prep.Done()
+			for job := range pending {
+				var s *Session
+				var err error
+				if !hooked {
+					s, err = openSession(client, job)
+				}
+				if check(err) {
+					continue
+				}
+				if s != nil && opened != nil {
+					opened(job, s)
+				}
+				prepared <- preparedUpload{job: job, session: s, err: err}
+			}
+		}()
+	}
+	go func() {
+	feed:
+		for _, job := range jobs {
+			select {
+			case pending <- job:
+			case <-stop:
+				break feed
+			}
+		}
+		close(pending)
+		prep.Wait()
+		close(prepared)
+	}()
+
+	var send sync.WaitGroup
+	for i := 0; i < transfers; i++ {
+		send.Add(1)
+		go func() {
+			defer send.Done()
+			for p := range prepared {
+				// the session stays recorded for the next run
+				if check(nil) {
+					continue
+				}
+				res := UploadResult{Job: p.job, Err: p.err}
+				t := Track(p.job.Progress, TransferInfo{Name: p.job.Path, Size: p.job.Size, Upload: true})
+				switch {
+				case hooked:
+					res.File, res.Err = hookedUpload(client, p.job, t, opened)
+				case p.err == nil:
+					p.session.progress = t
+					t.Set(p.session.Offset)
+					res.File, res.Err = sendSession(p.session, p.job.Path)
+				}
+				t.Done(res.File, res.Err)
+				check(res.Err)
+				done(res)
+			}
+		}()
+	}
+	send.Wait()
+	return stopErr
+}
+
+// openSession resumes the job's earlier session when it still exists,
+// or starts a new one.
+func openSession(client *http.Client, job UploadJob) (s *Session, err error) {
+	if job.SessionURI != "" {
+		s, err = ResumeSession(client, job.SessionURI, job.Size)
+	}
+	if s == nil && job.ContentType != "" {
+		s, err = NewSessionAs(client, job.File, job.Size, job.ContentType)
+	} else if s == nil {
+		s, err = NewSession(client, job.File, job.Size)
+	}
+	if s != nil {
+		s.Bandwidth = job.Bandwidth
+	}
+	return s, err
+}
+
+// hookedUpload uploads job through the hooks added with WithTransferHook,
+// opening its session after they had their say.
+func hookedUpload(client *http.Client, job UploadJob, t *Tracker, opened func(UploadJob, *Session)) (*drive.File, error) {
+	in, err := os.Open(job.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	x := &TransferJob{TransferInfo: TransferInfo{Name: job.Path, Size: job.Size, Upload: true}, File: job.File, Body: in}
+	return RunTransfer(context.Background(), x, func(ctx context.Context, x *TransferJob) (*drive.File, error) {
+		if x.Size != job.Size {
+			// a session of the earlier run was for other content
+			job.SessionURI = ""
+		}
+		job.File, job.Size = x.File, x.Size
+		s, err := openSession(client, job)
+		if err != nil {
+			return nil, err
+		}
+		if opened != nil {
+			opened(job, s)
+		}
+		s.progress = t
+		t.Set(s.Offset)
+		return sendBody(s, x.Body)
+	})
+}
+
+// sendBody sends body through s, skipping the bytes Drive already has;
+// body cannot seek when hooks wrapped it.
+func sendBody(s *Session, body io.Reader) (*drive.File, error) {
+	if s.done != nil {
+		return s.Close()
+	}
+	if _, err := io.CopyN(ioutil.Discard, body, s.Offset); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(s, body); err != nil {
+		s.Cancel()
+		return nil, err
+	}
+	return s.Close()
+}
+
+func sendSession(s *Session, path string) (*drive.File, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		s.Cancel()
+		return nil, err
+	}
+	defer in.Close()
+	if s.Offset > 0 {
+		if _, err = in.Seek(s.Offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	if _, err = io.Copy(s, in); err != nil {
+		s.Cancel()
+		return nil, err
+	}
+	return s.Close()
+}
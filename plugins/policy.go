@@ -0,0 +1,91 @@
+package magic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// UploadPolicy limits what one upload route of the gateway accepts, so it
+// cannot be used to stash arbitrary content. Empty limits allow anything.
+type UploadPolicy struct {
+	// Extensions are accepted file name extensions, ".jpg" style.
+	Extensions []string `yaml:"extensions"`
+	// Types are accepted MIME types, "image/*" style, as declared by the
+	// client or else guessed from the extension.
+	Types []string `yaml:"types"`
+	// MaxSize such as 2G caps the size of one upload.
+	MaxSize string `yaml:"max_size"`
+
+	maxSize int64
+}
+
+// Policies maps gateway upload routes, such as "/files", to their
+// UploadPolicy; the one of "*" covers the routes not listed.
+type Policies map[string]*UploadPolicy
+
+// LoadPolicies reads a YAML file of Policies.
+func LoadPolicies(file string) (Policies, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p Policies
+	if err = yaml.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("unable to parse upload policy %s: %v", file, err)
+	}
+	for route, up := range p {
+		if up == nil {
+			p[route] = &UploadPolicy{}
+			continue
+		}
+		if up.maxSize, err = ParseSize(up.MaxSize); err != nil {
+			return nil, fmt.Errorf("upload policy of %s: %v", route, err)
+		}
+	}
+	return p, nil
+}
+
+// For returns the policy of route, nil when none applies.
+func (p Policies) For(route string) *UploadPolicy {
+	if up, ok := p[route]; ok {
+		return up
+	}
+	return p["*"]
+}
+
+// Check returns a *Rejection naming the broken rule when a file called name
+// of type mimeType and size bytes may not be uploaded; size is negative
+// when not known yet. A nil policy allows everything.
+func (up *UploadPolicy) Check(name string, mimeType string, size int64) error {
+	if up == nil {
+		return nil
+	}
+	if len(up.Extensions) > 0 {
+		ext := strings.ToLower(path.Ext(name))
+		ok := false
+		for _, e := range up.Extensions {
+			e = strings.ToLower(e)
+			if !strings.HasPrefix(e, ".") {
+				e = "." + e
+			}
+			ok = ok || e == ext
+		}
+		if !ok {
+			return &Rejection{Name: name, Rule: "extension", Reason: fmt.Sprintf("extension %q not allowed", ext)}
+		}
+	}
+	if mimeType == "" {
+		mimeType = typeByName(name)
+	}
+	if len(up.Types) > 0 && !matchType(up.Types, mimeType) {
+		return &Rejection{Name: name, Rule: "type", Reason: "content type " + mimeType + " not allowed"}
+	}
+	if up.maxSize > 0 && size > up.maxSize {
+		return &Rejection{Name: name, Rule: "size", Reason: fmt.Sprintf("%d bytes exceed the limit of %s", size, up.MaxSize)}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package magic
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Posix attributes travel as appProperties under these keys. Drive caps a
+// property at 124 bytes (key plus value), so oversized xattrs are dropped.
+const (
+	posixMode  = "posix.mode"
+	posixUID   = "posix.uid"
+	posixGID   = "posix.gid"
+	posixMtime = "posix.mtime"
+	posixLink  = "posix.link"
+	posixXattr = "posix.xattr."
+
+	maxPropertyLen = 124
+)
+
+// PosixProperties collects mode bits, ownership, mtime, symlink target and
+// extended attributes of path as appProperties.
+func PosixProperties(path string) (map[string]string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	props := map[string]string{
+		posixMode:  strconv.FormatUint(uint64(info.Mode().Perm()), 8),
+		posixMtime: strconv.FormatInt(info.ModTime().UnixNano(), 10),
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		setProperty(props, posixLink, target)
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		props[posixUID] = strconv.Itoa(uid)
+		props[posixGID] = strconv.Itoa(gid)
+	}
+	xattrs, err := listXattrs(path)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range xattrs {
+		setProperty(props, posixXattr+name, base64.RawStdEncoding.EncodeToString(value))
+	}
+	return props, nil
+}
+
+func setProperty(props map[string]string, key, value string) {
+	if len(key)+len(value) <= maxPropertyLen {
+		props[key] = value
+	}
+}
+
+// LinkProperties records only a symlink target, for links preserved
+// without the rest of the posix attributes. A target too long for an
+// appProperty is an error: the link would come back as an empty file.
+func LinkProperties(target string) (map[string]string, error) {
+	if len(posixLink)+len(target) > maxPropertyLen {
+		return nil, fmt.Errorf("link target of %d bytes does not fit in Drive metadata", len(target))
+	}
+	return map[string]string{posixLink: target}, nil
+}
+
+// PosixLink returns the symlink target stored in props, if any.
+func PosixLink(props map[string]string) (string, bool) {
+	target, ok := props[posixLink]
+	return target, ok
+}
+
+// RestorePosix applies the attributes stored by PosixProperties to path.
+// Ownership is only restored when permitted (normally as root).
+func RestorePosix(path string, props map[string]string) error {
+	if _, ok := props[posixLink]; ok {
+		// Mode and times of the link itself are not portable; only the
+		// target matters and it was set on creation.
+		return nil
+	}
+	if v, ok := props[posixMode]; ok {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err == nil {
+			if err = os.Chmod(path, os.FileMode(mode)); err != nil {
+				return err
+			}
+		}
+	}
+	uid, uerr := strconv.Atoi(props[posixUID])
+	gid, gerr := strconv.Atoi(props[posixGID])
+	if uerr == nil && gerr == nil {
+		if err := os.Lchown(path, uid, gid); err != nil && !os.IsPermission(err) {
+			return err
+		}
+	}
+	for key, value := range props {
+		if !strings.HasPrefix(key, posixXattr) {
+			continue
+		}
+		b, err := base64.RawStdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		if err = setXattr(path, strings.TrimPrefix(key, posixXattr), b); err != nil {
+			return err
+		}
+	}
+	if v, ok := props[posixMtime]; ok {
+		ns, err := strconv.ParseInt(v, 10, 64)
+		if err == nil {
+			t := time.Unix(0, ns)
+			if err = os.Chtimes(path, t, t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
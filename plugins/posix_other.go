@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package magic
+
+import "os"
+
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+func listXattrs(path string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package magic
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+func listXattrs(path string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size == 0 {
+		// Filesystems without xattr support are not an error.
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, nil
+	}
+	xattrs := map[string][]byte{}
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name == "" {
+			continue
+		}
+		n, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, n)
+		if n, err = unix.Lgetxattr(path, name, value); err != nil {
+			continue
+		}
+		xattrs[name] = value[:n]
+	}
+	return xattrs, nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	err := unix.Lsetxattr(path, name, value, 0)
+	if err == unix.ENOTSUP || err == unix.EPERM {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package magic
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Priority orders Session chunks competing for the Slots scheduler.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1 // bulk work such as backups
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1 // interactive requests
+)
+
+// ParsePriority accepts "high", "normal" (or "") and "low".
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "high":
+		return PriorityHigh, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	}
+	return "normal"
+}
+
+// Scheduler hands out a fixed number of chunk slots. When a slot frees up
+// it goes to the oldest waiter of the highest priority, so a bulk upload
+// yields to interactive ones at every chunk boundary while keeping its
+// session. A nil *Scheduler grants slots immediately.
+type Scheduler struct {
+	mu      sync.Mutex
+	free    int
+	waiting [3][]chan struct{} // by Priority+1
+}
+
+func NewScheduler(slots int) *Scheduler {
+	if slots <= 0 {
+		return nil
+	}
+	return &Scheduler{free: slots}
+}
+
+// Slots, when set, limits how many Session chunks are in flight at once
+// across the process.
+var Slots *Scheduler
+
+func (s *Scheduler) acquire(p Priority) {
+	if s == nil {
+		return
+	}
+	if p < PriorityLow {
+		p = PriorityLow
+	} else if p > PriorityHigh {
+		p = PriorityHigh
+	}
+	s.mu.Lock()
+	if s.free > 0 {
+		s.free--
+		s.mu.Unlock()
+		return
+	}
+	ready := make(chan struct{})
+	s.waiting[p+1] = append(s.waiting[p+1], ready)
+	s.mu.Unlock()
+	<-ready
+}
+
+func (s *Scheduler) release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.waiting) - 1; i >= 0; i-- {
+		if q := s.waiting[i]; len(q) > 0 {
+			s.waiting[i] = q[1:]
+			close(q[0]) // the slot passes straight to the waiter
+			return
+		}
+	}
+	s.free++
+}
@@ -0,0 +1,178 @@
+package magic
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// ProgressReporter follows transfers: OnStart, then OnProgress as bytes
+// move, then OnComplete or OnError. Transfers run concurrently, so the
+// methods are called from several goroutines at once.
+type ProgressReporter interface {
+	OnStart(t TransferInfo)
+	// OnProgress tells the bytes transferred so far, at most a few times
+	// a second.
+	OnProgress(t TransferInfo, done int64)
+	OnComplete(t TransferInfo, f *drive.File)
+	OnError(t TransferInfo, err error)
+}
+
+// TransferInfo is the transfer a ProgressReporter is told about.
+type TransferInfo struct {
+	Name   string // local path
+	ID     string // Drive file, when known
+	Size   int64  // -1 when not known ahead
+	Upload bool
+}
+
+// progressEvery is how often OnProgress is called at most.
+const progressEvery = 250 * time.Millisecond
+
+// Tracker reports one transfer. A nil Tracker, made for a nil reporter,
+// reports nothing.
+type Tracker struct {
+	rep     ProgressReporter
+	info    TransferInfo
+	started bool
+	done    int64
+	last    int64 // UnixNano of the last OnProgress
+}
+
+// Track starts reporting the transfer to rep.
+func Track(rep ProgressReporter, info TransferInfo) *Tracker {
+	t := newTracker(rep, info)
+	t.start(info.Size)
+	return t
+}
+
+func newTracker(rep ProgressReporter, info TransferInfo) *Tracker {
+	if rep == nil {
+		return nil
+	}
+	return &Tracker{rep: rep, info: info}
+}
+
+// start reports the transfer, once its size is known.
+func (t *Tracker) start(size int64) {
+	if t == nil || t.started {
+		return
+	}
+	t.info.Size, t.started = size, true
+	t.rep.OnStart(t.info)
+}
+
+// Set reports done bytes transferred.
+func (t *Tracker) Set(done int64) {
+	if t == nil {
+		return
+	}
+	atomic.StoreInt64(&t.done, done)
+	t.report(done)
+}
+
+func (t *Tracker) add(n int) {
+	if t == nil || n == 0 {
+		return
+	}
+	t.report(atomic.AddInt64(&t.done, int64(n)))
+}
+
+func (t *Tracker) report(done int64) {
+	now, last := time.Now().UnixNano(), atomic.LoadInt64(&t.last)
+	if done != t.info.Size && now-last < int64(progressEvery) || !atomic.CompareAndSwapInt64(&t.last, last, now) {
+		return
+	}
+	t.rep.OnProgress(t.info, done)
+}
+
+// Updater reports the progress of a googleapi media upload.
+func (t *Tracker) Updater() googleapi.ProgressUpdater {
+	return func(current, total int64) { t.Set(current) }
+}
+
+// Done reports the end of the transfer, successful unless err is set.
+func (t *Tracker) Done(f *drive.File, err error) {
+	if t == nil {
+		return
+	}
+	t.start(t.info.Size)
+	if f != nil && t.info.ID == "" {
+		t.info.ID = f.Id
+	}
+	if err != nil {
+		t.rep.OnError(t.info, err)
+		return
+	}
+	t.rep.OnComplete(t.info, f)
+}
+
+// reader counts what is read from r as transferred.
+func (t *Tracker) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &trackedReader{r: r, t: t}
+}
+
+type trackedReader struct {
+	r io.Reader
+	t *Tracker
+}
+
+func (r *trackedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.t.add(n)
+	return n, err
+}
+
+// JSONProgress reports transfers to w as JSON lines, one per event, for
+// other programs to follow.
+func JSONProgress(w io.Writer) ProgressReporter {
+	return &jsonProgress{enc: json.NewEncoder(w)}
+}
+
+type jsonProgress struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type progressEvent struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Name   string    `json:"name"`
+	ID     string    `json:"id,omitempty"`
+	Size   int64     `json:"size"`
+	Upload bool      `json:"upload"`
+	Done   int64     `json:"done,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (p *jsonProgress) emit(event string, t TransferInfo, done int64, err error) {
+	e := progressEvent{Event: event, Time: time.Now().UTC(), Name: t.Name, ID: t.ID, Size: t.Size, Upload: t.Upload, Done: done}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	p.mu.Lock()
+	p.enc.Encode(e)
+	p.mu.Unlock()
+}
+
+func (p *jsonProgress) OnStart(t TransferInfo) { p.emit("start", t, 0, nil) }
+
+func (p *jsonProgress) OnProgress(t TransferInfo, done int64) { p.emit("progress", t, done, nil) }
+
+func (p *jsonProgress) OnComplete(t TransferInfo, f *drive.File) {
+	done := t.Size
+	if done < 0 && f != nil {
+		done = f.Size
+	}
+	p.emit("complete", t, done, nil)
+}
+
+func (p *jsonProgress) OnError(t TransferInfo, err error) { p.emit("error", t, 0, err) }
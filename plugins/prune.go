@@ -0,0 +1,54 @@
+package magic
+
+import (
+	"fmt"
+	"path"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// PruneEmpty trashes the empty folders below the Drive folder id, such as
+// sync deletions leave behind, and returns their paths, deepest first.
+// Folders holding only empty folders are empty too; id itself is kept.
+// Only folders down to depth levels below id are looked into, all for a
+// negative depth. With dryRun nothing is trashed.
+func PruneEmpty(ctx context.Context, srv *drive.Service, id string, depth int, dryRun bool) ([]string, error) {
+	var pruned []string
+	var prune func(id, dir string, level int) (bool, error)
+	prune = func(id, dir string, level int) (bool, error) {
+		var children []*drive.File
+		it := ListIter(ctx, srv, "'"+id+"' in parents and trashed=false", "id, name, mimeType")
+		for it.Next() {
+			children = append(children, it.File())
+		}
+		if err := it.Err(); err != nil {
+			return false, err
+		}
+		left := 0
+		for _, f := range children {
+			if f.MimeType != FolderMime || depth >= 0 && level >= depth {
+				left++
+				continue
+			}
+			p := path.Join(dir, Names.Display(f.Name))
+			empty, err := prune(f.Id, p, level+1)
+			if err != nil {
+				return false, err
+			}
+			if !empty {
+				left++
+				continue
+			}
+			if !dryRun {
+				if _, err = srv.Files.Update(f.Id, &drive.File{Trashed: true}).Fields("id").Context(ctx).Do(); err != nil {
+					return false, fmt.Errorf("%s: %v", p, Classify(err))
+				}
+			}
+			pruned = append(pruned, p)
+		}
+		return left == 0, nil
+	}
+	_, err := prune(id, "", 0)
+	return pruned, err
+}
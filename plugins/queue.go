@@ -0,0 +1,130 @@
+package magic
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Queue is a pending upload list kept on disk, so a crashed or killed run
+// can be restarted where it stopped. Jobs leave the file once uploaded;
+// the resumable session of a job in flight is recorded as soon as it is
+// opened, so a restart continues that upload from Drive's offset.
+type Queue struct {
+	path string
+	// Bandwidth paces jobs that do not carry their own limit.
+	Bandwidth *Bandwidth
+	// Progress is told about the uploads of jobs without a reporter.
+	Progress ProgressReporter
+
+	mu   sync.Mutex
+	jobs []UploadJob
+}
+
+// OpenQueue loads the queue at path; a missing file is an empty queue.
+func OpenQueue(path string) (*Queue, error) {
+	q := &Queue{path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return q, json.Unmarshal(b, &q.jobs)
+}
+
+// Pending returns the jobs not uploaded yet.
+func (q *Queue) Pending() []UploadJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]UploadJob(nil), q.jobs...)
+}
+
+// Push appends jobs and saves the queue. Paths already queued keep their
+// earlier entry, so re-running an interrupted upload does not repeat it.
+func (q *Queue) Push(jobs ...UploadJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := map[string]bool{}
+	for _, j := range q.jobs {
+		queued[j.Path] = true
+	}
+	for _, j := range jobs {
+		if !queued[j.Path] {
+			q.jobs = append(q.jobs, j)
+			queued[j.Path] = true
+		}
+	}
+	return q.save()
+}
+
+// Run uploads every pending job like PipelineUpload, saving progress as
+// it goes. Failed jobs, and those left when a quota ran out, stay queued
+// for the next run.
+func (q *Queue) Run(client *http.Client, transfers int, done func(UploadResult)) error {
+	opened := func(job UploadJob, s *Session) {
+		q.update(job.Path, func(j *UploadJob) { j.SessionURI = s.URI })
+	}
+	jobs := q.Pending()
+	for i := range jobs {
+		if jobs[i].Bandwidth == nil {
+			jobs[i].Bandwidth = q.Bandwidth
+		}
+		if jobs[i].Progress == nil {
+			jobs[i].Progress = q.Progress
+		}
+	}
+	return pipeline(client, jobs, transfers, opened, func(r UploadResult) {
+		if r.Err == nil {
+			q.remove(r.Job.Path)
+		}
+		done(r)
+	})
+}
+
+func (q *Queue) update(path string, fn func(*UploadJob)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.jobs {
+		if q.jobs[i].Path == path {
+			fn(&q.jobs[i])
+		}
+	}
+	q.save()
+}
+
+func (q *Queue) remove(path string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.jobs {
+		if q.jobs[i].Path == path {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			break
+		}
+	}
+	q.save()
+}
+
+// save writes the queue through a temporary file, so a crash mid-write
+// leaves the previous version intact. Callers hold q.mu.
+func (q *Queue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(q.jobs)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
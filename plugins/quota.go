@@ -0,0 +1,64 @@
+package magic
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// QuotaReset is when the daily Drive limits after now are lifted: Google
+// resets them at midnight Pacific time.
+func QuotaReset(now time.Time) time.Time {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		// no tzdata; off by an hour in summer, which only delays the resume
+		loc = time.FixedZone("PST", -8*60*60)
+	}
+	t := now.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+}
+
+// StorageFull reports whether the quota error err is the account or
+// shared drive running out of space, rather than a daily limit.
+func StorageFull(err error) bool {
+	var ge *googleapi.Error
+	if !errors.As(err, &ge) {
+		return false
+	}
+	for _, item := range ge.Errors {
+		if item.Reason == "storageQuotaExceeded" || item.Reason == "teamDriveFileLimitExceeded" {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitQuota blocks until the quota that failed with err is likely back:
+// until QuotaReset for a daily limit, or until need bytes are free, checked
+// every interval, when storage ran out.
+func WaitQuota(ctx context.Context, srv *drive.Service, err error, need int64, interval time.Duration) error {
+	if !StorageFull(err) {
+		select {
+		case <-time.After(time.Until(QuotaReset(time.Now()))):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for {
+		about, err := srv.About.Get().Fields("storageQuota(limit, usage)").Context(ctx).Do()
+		if err == nil {
+			if q := about.StorageQuota; q == nil || q.Limit == 0 || q.Limit-q.Usage >= need {
+				return nil
+			}
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package magic
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// Request IDs tie the Drive calls made for one gateway request or upload
+// to its log lines and audit entries. They are sent to Drive as quotaUser
+// and X-Request-ID, so they also show in Google's reports.
+//
+// A server acting for many clients with one set of credentials sets a
+// quota user, naming the client, instead: Drive then rate limits each
+// client on its own, and one client's burst leaves the others their share.
+
+type requestIDKey struct{}
+
+type quotaUserKey struct{}
+
+// quotaUserMax is the longest quotaUser Drive accepts.
+const quotaUserMax = 40
+
+// WithRequestID returns a context whose Drive calls carry id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id set by WithRequestID, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithQuotaUser returns a context whose Drive calls are counted against
+// the quota of user rather than of the request.
+func WithQuotaUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, quotaUserKey{}, user)
+}
+
+// QuotaUser returns the user set by WithQuotaUser, if any.
+func QuotaUser(ctx context.Context) string {
+	user, _ := ctx.Value(quotaUserKey{}).(string)
+	return user
+}
+
+// RequestClient returns a copy of client whose calls all carry the
+// request ID and quota user of ctx, for work like upload sessions that
+// outlives the request context.
+func RequestClient(ctx context.Context, client *http.Client) *http.Client {
+	c := *client
+	c.Transport = requestIDTransport{base: client.Transport, id: RequestID(ctx), quotaUser: QuotaUser(ctx)}
+	return &c
+}
+
+// requestIDTransport tags requests with its id and quota user, or else
+// those of their context.
+type requestIDTransport struct {
+	base      http.RoundTripper
+	id        string
+	quotaUser string
+}
+
+// quotaUserParam fits user in the quotaUser limit; longer names are
+// hashed, so distinct ones stay distinct.
+func quotaUserParam(user string) string {
+	if len(user) <= quotaUserMax {
+		return user
+	}
+	sum := sha1.Sum([]byte(user))
+	return hex.EncodeToString(sum[:])
+}
+
+func (t requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	id, user := t.id, t.quotaUser
+	if id == "" {
+		id = RequestID(req.Context())
+	}
+	if user == "" {
+		user = QuotaUser(req.Context())
+	}
+	if id == "" && user == "" {
+		return base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if id != "" {
+		req.Header.Set("X-Request-ID", id)
+	}
+	if user == "" && len(id) > quotaUserMax {
+		user = id[:quotaUserMax]
+	} else if user == "" {
+		user = id
+	}
+	q := req.URL.Query()
+	if q.Get("quotaUser") == "" {
+		q.Set("quotaUser", quotaUserParam(user))
+		req.URL.RawQuery = q.Encode()
+	}
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,443 @@
+package magic
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rule routes the uploads its When expression matches: into Folder, with
+// Labels added, and their sync conflicts settled by Conflict. Empty
+// fields leave the defaults; an empty When matches every file.
+//
+// When is an expression over the file:
+//
+//	path  the path as given, with slashes   name  its base name
+//	dir   the directory part of path        ext   lower case, without dot
+//	size  in bytes                          age   since modified, seconds;
+//	                                              0 when not known
+//	host  the machine or client it came from
+//	type  the MIME type of the extension, without parameters
+//
+// compared with == != < <= > >=, matched with =~ and !~ against a regular
+// expression, tested with in against a list like ["jpg", "png"], and
+// combined with && || ! and parentheses. Sizes take the suffixes K, M, G
+// and T (powers of 1024), durations s, m, h, d and w:
+//
+//	ext in ["jpg", "png"] && size > 10M
+//	path =~ "^reports/" || host == "ci-01"
+//	age > 30d && !(dir =~ "keep")
+type Rule struct {
+	When     string     `yaml:"when"`
+	Folder   string     `yaml:"folder"`
+	Labels   []string   `yaml:"labels"` // as -label
+	Conflict Resolution `yaml:"conflict"`
+
+	match ruleExpr
+}
+
+// Rules are tried in order; the first match applies.
+type Rules []*Rule
+
+// RuleFile is what rules are matched against.
+type RuleFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time // zero when not known
+	Host    string
+}
+
+// CompileRules checks and prepares rules for Match.
+func CompileRules(rules []Rule) (Rules, error) {
+	out := make(Rules, len(rules))
+	for i := range rules {
+		r := rules[i]
+		var err error
+		if r.match, err = parseRule(r.When); err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i+1, err)
+		}
+		if r.Conflict != "" {
+			if _, err = ParseResolution(string(r.Conflict)); err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i+1, err)
+			}
+		}
+		out[i] = &r
+	}
+	return out, nil
+}
+
+// Match returns the first rule matching f, or nil.
+func (rs Rules) Match(f RuleFile) *Rule {
+	if len(rs) == 0 {
+		return nil
+	}
+	p := filepath.ToSlash(f.Path)
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(p), "."))
+	typ, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(p)))
+	age := 0.0
+	if !f.ModTime.IsZero() {
+		age = time.Since(f.ModTime).Seconds()
+	}
+	env := map[string]interface{}{
+		"path": p, "name": path.Base(p), "dir": path.Dir(p), "ext": ext,
+		"size": float64(f.Size), "host": f.Host, "type": typ, "age": age,
+	}
+	for _, r := range rs {
+		if v, err := r.match(env); err == nil && v == true {
+			return r
+		}
+	}
+	return nil
+}
+
+type ruleExpr func(env map[string]interface{}) (interface{}, error)
+
+var ruleVars = map[string]bool{"path": true, "name": true, "dir": true, "ext": true, "size": true, "age": true, "host": true, "type": true}
+
+func parseRule(src string) (ruleExpr, error) {
+	if strings.TrimSpace(src) == "" {
+		return constant(true), nil
+	}
+	toks, err := ruleTokens(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &ruleParser{toks: toks}
+	e, err := p.or()
+	if err == nil && p.pos < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.pos].text)
+	}
+	return e, err
+}
+
+type ruleToken struct {
+	kind byte // 's' string, 'n' number, 'i' identifier, 'o' operator
+	text string
+	num  float64
+}
+
+var ruleOps = []string{"||", "&&", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func ruleTokens(src string) ([]ruleToken, error) {
+	var toks []ruleToken
+	r := []rune(src)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(r) && r[j] != c {
+				if r[j] == '\\' && c == '"' {
+					j++
+				}
+				j++
+			}
+			if j >= len(r) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			s := string(r[i+1 : j])
+			if c == '"' {
+				var err error
+				if s, err = strconv.Unquote(string(r[i : j+1])); err != nil {
+					return nil, fmt.Errorf("bad string %s", string(r[i:j+1]))
+				}
+			}
+			toks = append(toks, ruleToken{kind: 's', text: s})
+			i = j + 1
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(r) && (unicode.IsDigit(r[j]) || r[j] == '.') {
+				j++
+			}
+			k := j
+			for k < len(r) && unicode.IsLetter(r[k]) {
+				k++
+			}
+			n, err := ruleNumber(string(r[i:j]), string(r[j:k]))
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, ruleToken{kind: 'n', text: string(r[i:k]), num: n})
+			i = k
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(r) && (unicode.IsLetter(r[j]) || unicode.IsDigit(r[j]) || r[j] == '_') {
+				j++
+			}
+			toks = append(toks, ruleToken{kind: 'i', text: string(r[i:j])})
+			i = j
+		default:
+			op := ""
+			for _, o := range ruleOps {
+				if strings.HasPrefix(string(r[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+			toks = append(toks, ruleToken{kind: 'o', text: op})
+			i += len([]rune(op))
+		}
+	}
+	return toks, nil
+}
+
+// ruleNumber reads a number with an optional size or duration suffix.
+func ruleNumber(n, suffix string) (float64, error) {
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, err
+	}
+	units := map[string]float64{
+		"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40,
+		"s": 1, "m": 60, "h": 3600, "d": 86400, "w": 7 * 86400,
+	}
+	u, ok := units[suffix]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q in %s%s", suffix, n, suffix)
+	}
+	return v * u, nil
+}
+
+type ruleParser struct {
+	toks []ruleToken
+	pos  int
+}
+
+func (p *ruleParser) peek(op string) bool {
+	return p.pos < len(p.toks) && (p.toks[p.pos].kind == 'o' || p.toks[p.pos].kind == 'i') && p.toks[p.pos].text == op
+}
+
+func (p *ruleParser) or() (ruleExpr, error) {
+	l, err := p.and()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var r ruleExpr
+		if r, err = p.and(); err == nil {
+			l = logical(l, r, true)
+		}
+	}
+	return l, err
+}
+
+func (p *ruleParser) and() (ruleExpr, error) {
+	l, err := p.not()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var r ruleExpr
+		if r, err = p.not(); err == nil {
+			l = logical(l, r, false)
+		}
+	}
+	return l, err
+}
+
+// logical is || when or is set, && otherwise, evaluating r only when l
+// does not decide.
+func logical(l, r ruleExpr, or bool) ruleExpr {
+	return func(env map[string]interface{}) (interface{}, error) {
+		a, err := truth(l, env)
+		if err != nil || a == or {
+			return a, err
+		}
+		return truth(r, env)
+	}
+}
+
+func truth(e ruleExpr, env map[string]interface{}) (bool, error) {
+	v, err := e(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not true or false", v)
+	}
+	return b, nil
+}
+
+func (p *ruleParser) not() (ruleExpr, error) {
+	if !p.peek("!") {
+		return p.compare()
+	}
+	p.pos++
+	e, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return func(env map[string]interface{}) (interface{}, error) {
+		b, err := truth(e, env)
+		return !b, err
+	}, nil
+}
+
+func (p *ruleParser) compare() (ruleExpr, error) {
+	l, err := p.primary()
+	if err != nil || p.pos >= len(p.toks) {
+		return l, err
+	}
+	t := p.toks[p.pos]
+	op := t.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~", "in":
+	default:
+		return l, nil
+	}
+	if t.kind == 's' {
+		return l, nil
+	}
+	p.pos++
+	if op == "=~" || op == "!~" {
+		if p.pos >= len(p.toks) || p.toks[p.pos].kind != 's' {
+			return nil, fmt.Errorf("%s needs a quoted regular expression", op)
+		}
+		re, err := regexp.Compile(p.toks[p.pos].text)
+		if err != nil {
+			return nil, err
+		}
+		p.pos++
+		return func(env map[string]interface{}) (interface{}, error) {
+			v, err := l(env)
+			if err != nil {
+				return nil, err
+			}
+			return re.MatchString(fmt.Sprint(v)) == (op == "=~"), nil
+		}, nil
+	}
+	r, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	return func(env map[string]interface{}) (interface{}, error) {
+		a, err := l(env)
+		if err != nil {
+			return nil, err
+		}
+		b, err := r(env)
+		if err != nil {
+			return nil, err
+		}
+		return compareValues(op, a, b)
+	}, nil
+}
+
+func compareValues(op string, a, b interface{}) (interface{}, error) {
+	if op == "in" {
+		list, ok := b.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("in needs a list")
+		}
+		for _, v := range list {
+			if v == a {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	switch op {
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	}
+	if x, ok := a.(float64); ok {
+		if y, ok := b.(float64); ok {
+			switch op {
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			}
+			return x >= y, nil
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			switch op {
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			}
+			return x >= y, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot compare %v %s %v", a, op, b)
+}
+
+func (p *ruleParser) primary() (ruleExpr, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch {
+	case t.kind == 's':
+		return constant(t.text), nil
+	case t.kind == 'n':
+		return constant(t.num), nil
+	case t.kind == 'i' && (t.text == "true" || t.text == "false"):
+		return constant(t.text == "true"), nil
+	case t.kind == 'i':
+		if !ruleVars[t.text] {
+			return nil, fmt.Errorf("unknown name %q", t.text)
+		}
+		name := t.text
+		return func(env map[string]interface{}) (interface{}, error) { return env[name], nil }, nil
+	case t.text == "(":
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case t.text == "[":
+		var items []ruleExpr
+		for !p.peek("]") {
+			e, err := p.primary()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, e)
+			if p.peek(",") {
+				p.pos++
+			} else if !p.peek("]") {
+				return nil, fmt.Errorf("missing ]")
+			}
+		}
+		p.pos++
+		return func(env map[string]interface{}) (interface{}, error) {
+			list := make([]interface{}, len(items))
+			for i, e := range items {
+				v, err := e(env)
+				if err != nil {
+					return nil, err
+				}
+				list[i] = v
+			}
+			return list, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func constant(v interface{}) ruleExpr {
+	return func(map[string]interface{}) (interface{}, error) { return v, nil }
+}
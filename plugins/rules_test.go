@@ -0,0 +1,130 @@
+package magic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRulesMatch(t *testing.T) {
+	jpg := RuleFile{Path: "photos/a.JPG", Size: 11 << 20, Host: "ci-01"}
+	tests := []struct {
+		when string
+		f    RuleFile
+		want bool
+	}{
+		{"", jpg, true},
+		{`ext == "jpg"`, jpg, true},
+		{`name == "a.JPG" && dir == "photos"`, jpg, true},
+		// && binds tighter than ||
+		{`ext == "jpg" || ext == "png" && size > 1T`, jpg, true},
+		{`(ext == "jpg" || ext == "png") && size > 1T`, jpg, false},
+		{`ext == "png" && size > 1M || host == "ci-01"`, jpg, true},
+		// ! applies to the comparison after it
+		{`!ext == "png"`, jpg, true},
+		{`!(ext == "jpg" || ext == "png")`, jpg, false},
+		{`!!true`, jpg, true},
+		{`ext in ["png", "jpg"]`, jpg, true},
+		{`ext in ["png", "gif"]`, jpg, false},
+		{`ext in []`, jpg, false},
+		{`size in [1K, 11M]`, jpg, true},
+		{`path =~ "^photos/"`, jpg, true},
+		{`path !~ "^photos/"`, jpg, false},
+		{`name =~ '\.JPG$'`, jpg, true},
+		{`size > 10M && size <= 11M`, jpg, true},
+		{`size >= 1.5G`, jpg, false},
+		{`host != "ci-02"`, jpg, true},
+		{`type == "text/html"`, RuleFile{Path: "index.html"}, true},
+		{`age > 1h`, RuleFile{Path: "a"}, false},
+		{`age > 1h`, RuleFile{Path: "a", ModTime: time.Now().Add(-2 * time.Hour)}, true},
+		{`age > 1d`, RuleFile{Path: "a", ModTime: time.Now().Add(-2 * time.Hour)}, false},
+		{`age < 1w`, RuleFile{Path: "a", ModTime: time.Now().Add(-6 * 24 * time.Hour)}, true},
+		// a rule failing at evaluation does not match
+		{`size < "big"`, jpg, false},
+		{`size`, jpg, false},
+	}
+	for _, tt := range tests {
+		rs, err := CompileRules([]Rule{{When: tt.when, Folder: "x"}})
+		if err != nil {
+			t.Errorf("%s: %v", tt.when, err)
+			continue
+		}
+		if got := rs.Match(tt.f) != nil; got != tt.want {
+			t.Errorf("%s on %+v = %v, want %v", tt.when, tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestRulesMatchFirst(t *testing.T) {
+	rs, err := CompileRules([]Rule{
+		{When: `size > 1M`, Folder: "big"},
+		{When: `ext == "jpg"`, Folder: "photos"},
+		{Folder: "rest"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for f, want := range map[RuleFile]string{
+		{Path: "a.jpg", Size: 2 << 20}: "big",
+		{Path: "a.jpg"}:                "photos",
+		{Path: "a.txt"}:                "rest",
+	} {
+		if got := rs.Match(f).Folder; got != want {
+			t.Errorf("%+v went to %s, want %s", f, got, want)
+		}
+	}
+}
+
+func TestRulesErrors(t *testing.T) {
+	for _, when := range []string{
+		`path =~ "("`,
+		`path =~ ext`,
+		`ext == "jpg`,
+		`ext == "\q"`,
+		`colour == "red"`,
+		`(ext == "jpg"`,
+		`ext in ["jpg" "png"]`,
+		`size > 10X`,
+		`size > 1.2.3`,
+		`ext ==`,
+		`ext == "jpg")`,
+		`ext = "jpg"`,
+		`size > 1 # comment`,
+	} {
+		if _, err := CompileRules([]Rule{{When: when}}); err == nil {
+			t.Errorf("%s compiled", when)
+		}
+	}
+	if _, err := CompileRules([]Rule{{Conflict: "sometimes"}}); err == nil {
+		t.Error("unknown conflict policy accepted")
+	}
+}
+
+func TestRuleNumber(t *testing.T) {
+	tests := []struct {
+		n, suffix string
+		want      float64
+	}{
+		{"10", "", 10},
+		{"1", "K", 1024},
+		{"1.5", "K", 1536},
+		{"2", "M", 2 << 20},
+		{"1", "G", 1 << 30},
+		{"1", "T", 1 << 40},
+		{"30", "s", 30},
+		{"2", "m", 120},
+		{"1", "h", 3600},
+		{"2", "d", 2 * 86400},
+		{"1", "w", 7 * 86400},
+	}
+	for _, tt := range tests {
+		got, err := ruleNumber(tt.n, tt.suffix)
+		if err != nil || got != tt.want {
+			t.Errorf("ruleNumber(%q, %q) = %v, %v, want %v", tt.n, tt.suffix, got, err, tt.want)
+		}
+	}
+	for _, bad := range [][2]string{{"1", "k"}, {"1", "KB"}, {"1", "y"}, {"1..2", ""}} {
+		if _, err := ruleNumber(bad[0], bad[1]); err == nil {
+			t.Errorf("ruleNumber(%q, %q) accepted", bad[0], bad[1])
+		}
+	}
+}
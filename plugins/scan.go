@@ -0,0 +1,157 @@
+package magic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Scanner inspects content before it is committed to Drive. A non-nil
+// error rejects the content; a *Rejection carries the reason.
+type Scanner interface {
+	Scan(name string, r io.Reader) error
+}
+
+// Rejection is returned by scanners for infected or disallowed content.
+type Rejection struct {
+	Name   string
+	Reason string
+	Rule   string // the UploadPolicy limit broken: extension, type or size
+}
+
+func (e *Rejection) Error() string {
+	return fmt.Sprintf("%s rejected: %s", e.Name, e.Reason)
+}
+
+// Scanners runs every scanner in order over a seekable source.
+func Scanners(name string, src io.ReadSeeker, list ...Scanner) error {
+	for _, s := range list {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.Scan(name, src); err != nil {
+			return err
+		}
+	}
+	_, err := src.Seek(0, io.SeekStart)
+	return err
+}
+
+// TypeScanner sniffs the content type and rejects the denied prefixes,
+// e.g. "application/x-msdownload" or "text/html".
+type TypeScanner struct {
+	Denied []string
+}
+
+func (t TypeScanner) Scan(name string, r io.Reader) error {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	ctype := http.DetectContentType(head[:n])
+	for _, d := range t.Denied {
+		if strings.HasPrefix(ctype, d) {
+			return &Rejection{Name: name, Reason: "content type " + ctype + " not allowed"}
+		}
+	}
+	return nil
+}
+
+// CommandScanner pipes the content to an external command; a non-zero
+// exit status means the content is rejected and its output is the reason.
+type CommandScanner struct {
+	Command string
+	Args    []string
+}
+
+func (c CommandScanner) Scan(name string, r io.Reader) error {
+	cmd := exec.Command(c.Command, c.Args...)
+	cmd.Stdin = r
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return &Rejection{Name: name, Reason: strings.TrimSpace(string(out))}
+		}
+		return err
+	}
+	return nil
+}
+
+// ClamdScanner streams the content to a clamd daemon with INSTREAM.
+type ClamdScanner struct {
+	Network string // "tcp" or "unix"
+	Address string
+	// Timeout is how long clamd may take to accept a chunk or, after the
+	// last one, to answer; ClamdTimeout when zero.
+	Timeout time.Duration
+}
+
+// ClamdTimeout is the default ClamdScanner.Timeout.
+const ClamdTimeout = time.Minute
+
+func (c ClamdScanner) Scan(name string, r io.Reader) error {
+	conn, err := net.DialTimeout(c.Network, c.Address, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = ClamdTimeout
+	}
+	// a clamd that stalls must not hold the upload forever
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err = conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return err
+	}
+	buf := make([]byte, 32*1024)
+	size := make([]byte, 4)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			conn.SetDeadline(time.Now().Add(timeout))
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err = conn.Write(size); err != nil {
+				return err
+			}
+			if _, err = conn.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err = conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	reply = bytes.TrimRight(reply, "\x00\n")
+	// "stream: OK" or "stream: Eicar-Test-Signature FOUND"
+	res := strings.TrimPrefix(string(reply), "stream: ")
+	switch {
+	case res == "OK":
+		return nil
+	case strings.HasSuffix(res, " FOUND"):
+		return &Rejection{Name: name, Reason: "infected: " + strings.TrimSuffix(res, " FOUND")}
+	default:
+		return fmt.Errorf("clamd: %s", res)
+	}
+}
@@ -0,0 +1,157 @@
+package magic
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a bandwidth limit that depends on the time of week, written
+// as comma separated windows and a fallback:
+//
+//	Mon-Fri 09:00-17:00 1M, Sat 10:00-14:00 4M, else off
+//
+// The first matching window wins; "else" (default off) applies outside
+// all of them. A plain rate such as "2M" is a schedule with no windows.
+type Schedule struct {
+	Windows []Window
+	Else    int64
+}
+
+// Window is a weekly time range with its rate in bytes per second, 0 for
+// no limit. End before Start wraps past midnight.
+type Window struct {
+	Days       [7]bool // indexed by time.Weekday
+	Start, End int     // minutes since midnight
+	Rate       int64
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseSchedule reads the syntax described at Schedule.
+func ParseSchedule(s string) (*Schedule, error) {
+	sched := &Schedule{}
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Fields(part)
+		switch {
+		case len(fields) == 0:
+			continue
+		case len(fields) == 1:
+			rate, err := parseRate(fields[0])
+			if err != nil {
+				return nil, err
+			}
+			sched.Else = rate
+		case len(fields) == 2 && strings.EqualFold(fields[0], "else"):
+			rate, err := parseRate(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			sched.Else = rate
+		case len(fields) == 3:
+			w, err := parseWindow(fields)
+			if err != nil {
+				return nil, err
+			}
+			sched.Windows = append(sched.Windows, w)
+		default:
+			return nil, fmt.Errorf("invalid schedule entry %q", strings.TrimSpace(part))
+		}
+	}
+	return sched, nil
+}
+
+func parseRate(s string) (int64, error) {
+	if strings.EqualFold(s, "off") {
+		return 0, nil
+	}
+	return ParseSize(s)
+}
+
+// parseWindow reads "Mon-Fri", "09:00-17:00" and a rate.
+func parseWindow(fields []string) (Window, error) {
+	w := Window{}
+	for _, span := range strings.Split(fields[0], "+") {
+		days := strings.SplitN(strings.ToLower(span), "-", 2)
+		first, ok := weekdays[days[0]]
+		if !ok {
+			return w, fmt.Errorf("unknown weekday %q", days[0])
+		}
+		last := first
+		if len(days) == 2 {
+			if last, ok = weekdays[days[1]]; !ok {
+				return w, fmt.Errorf("unknown weekday %q", days[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid time range %q", fields[1])
+	}
+	var err error
+	if w.Start, err = parseClock(times[0]); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(times[1]); err != nil {
+		return w, err
+	}
+	w.Rate, err = parseRate(fields[2])
+	return w, err
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Rate returns the limit in force at t.
+func (s *Schedule) Rate(t time.Time) int64 {
+	min := t.Hour()*60 + t.Minute()
+	for _, w := range s.Windows {
+		if w.Start <= w.End {
+			if w.Days[t.Weekday()] && min >= w.Start && min < w.End {
+				return w.Rate
+			}
+			continue
+		}
+		// wraps midnight: the evening belongs to today, the morning to
+		// the day before
+		if w.Days[t.Weekday()] && min >= w.Start || w.Days[(t.Weekday()+6)%7] && min < w.End {
+			return w.Rate
+		}
+	}
+	return s.Else
+}
+
+// ParseBandwidth turns a rate or a Schedule into a limit. Schedules are
+// re-evaluated every minute for the life of the process, so a transfer
+// running across a window boundary changes pace.
+func ParseBandwidth(s string) (*Bandwidth, error) {
+	sched, err := ParseSchedule(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(sched.Windows) == 0 {
+		return NewBandwidth(sched.Else), nil
+	}
+	b := &Bandwidth{}
+	b.SetRate(sched.Rate(time.Now()))
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			b.SetRate(sched.Rate(now))
+		}
+	}()
+	return b, nil
+}
@@ -0,0 +1,53 @@
+package magic
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Listing scopes for ListScope.
+const (
+	ScopeMyDrive      = "my-drive"
+	ScopeStarred      = "starred"
+	ScopeSharedWithMe = "shared-with-me"
+	ScopeRecent       = "recent"
+	ScopeTrashed      = "trashed"
+)
+
+// ListScope lists the files of one of the scope views of the Drive UI,
+// or every file visible to the user for "", narrowed by the extra query
+// clause when it is not empty. Starred and
+// recent files include those on shared drives.
+func ListScope(ctx context.Context, srv *drive.Service, scope string, extra string) (*ListIterator, error) {
+	var q, order string
+	allDrives := false
+	switch scope {
+	case "":
+		q = "trashed=false"
+	case ScopeMyDrive:
+		q = "'me' in owners and trashed=false"
+	case ScopeStarred:
+		q, allDrives = "starred=true and trashed=false", true
+	case ScopeSharedWithMe:
+		q = "sharedWithMe=true and trashed=false"
+	case ScopeRecent:
+		q, order, allDrives = "trashed=false and mimeType!='"+FolderMime+"'", "viewedByMeTime desc", true
+	case ScopeTrashed:
+		q = "trashed=true"
+	default:
+		return nil, fmt.Errorf("unknown scope %q", scope)
+	}
+	if extra != "" {
+		q += " and " + extra
+	}
+	it := ListIter(ctx, srv, q)
+	if order != "" {
+		it.Call().OrderBy(order)
+	}
+	if allDrives {
+		it.Call().Corpora("allDrives").IncludeItemsFromAllDrives(true).SupportsAllDrives(true)
+	}
+	return it, nil
+}
@@ -0,0 +1,256 @@
+package magic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+var uploadURL = "https://www.googleapis.com/upload/drive/v3/files?uploadType=resumable&fields=" +
+	url.QueryEscape(FileFields)
+
+const (
+	// ChunkSize is the granularity Drive accepts for non-final chunks.
+	ChunkSize = 256 * 1024
+	// sessionChunk is how much is buffered before a chunk is flushed.
+	sessionChunk = 32 * ChunkSize
+)
+
+// Session is an open Drive resumable upload. Bytes written to it are
+// buffered and sent to Drive in chunk-aligned PUTs; Close sends the tail.
+type Session struct {
+	URI    string
+	Size   int64
+	Offset int64 // bytes committed to Drive
+	// Priority decides which chunk gets the next free Slots slot.
+	Priority Priority
+	// Bandwidth, when set, paces the bytes sent.
+	Bandwidth *Bandwidth
+
+	client   *http.Client
+	buf      []byte
+	done     *drive.File // set when a resumed session had already finished
+	progress *Tracker
+}
+
+// NewSession starts a resumable upload of size bytes for the file metadata f.
+func NewSession(client *http.Client, f *drive.File, size int64) (*Session, error) {
+	return NewSessionAs(client, f, size, f.MimeType)
+}
+
+// NewSessionAs is NewSession for content of a type other than f.MimeType,
+// as when f is a native Google type the upload is converted to.
+func NewSessionAs(client *http.Client, f *drive.File, size int64, contentType string) (*Session, error) {
+	meta, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", uploadURL, bytes.NewReader(meta))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+	if contentType != "" {
+		req.Header.Set("X-Upload-Content-Type", contentType)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, responseError("unable to start upload session", res)
+	}
+	uri := res.Header.Get("Location")
+	if uri == "" {
+		return nil, errors.New("upload session has no location")
+	}
+	return &Session{URI: uri, Size: size, client: client}, nil
+}
+
+// ResumeSession reopens the session at uri, left behind by an earlier
+// process, and asks Drive how many bytes it already holds. Writes then
+// continue from Offset.
+func ResumeSession(client *http.Client, uri string, size int64) (*Session, error) {
+	req, err := http.NewRequest("PUT", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	s := &Session{URI: uri, Size: size, client: client}
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		s.done = &drive.File{}
+		if err = json.NewDecoder(res.Body).Decode(s.done); err != nil {
+			return nil, err
+		}
+		s.Offset = size
+	case 308:
+		if s.Offset, err = rangeEnd(res.Header.Get("Range")); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, responseError("unable to resume upload session", res)
+	}
+	return s, nil
+}
+
+// rangeEnd turns a "bytes=0-n" Range header into the n+1 bytes it
+// acknowledges; no header means nothing was received.
+func rangeEnd(r string) (int64, error) {
+	i := strings.LastIndex(r, "-")
+	if r == "" || i < 0 {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(r[i+1:], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n + 1, nil
+}
+
+// Buffered reports bytes accepted by Write but not yet sent to Drive.
+func (s *Session) Buffered() int64 {
+	return int64(len(s.buf))
+}
+
+func (s *Session) Write(p []byte) (int, error) {
+	if s.Offset+s.Buffered()+int64(len(p)) > s.Size {
+		return 0, errors.New("write beyond declared upload size")
+	}
+	s.buf = append(s.buf, p...)
+	// Hold back at least one byte so Drive only finalizes the file on Close.
+	for len(s.buf) > sessionChunk {
+		if _, err := s.put(s.buf[:sessionChunk], false); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Close sends the remaining bytes and returns the created Drive file.
+func (s *Session) Close() (*drive.File, error) {
+	if s.done != nil {
+		return s.done, nil
+	}
+	if s.Offset+s.Buffered() != s.Size {
+		return nil, fmt.Errorf("upload incomplete: %d of %d bytes", s.Offset+s.Buffered(), s.Size)
+	}
+	return s.put(s.buf, true)
+}
+
+// Cancel abandons the session on the Drive side.
+func (s *Session) Cancel() error {
+	req, err := http.NewRequest("DELETE", s.URI, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// put sends chunk, a prefix of s.buf, riding out network failures: after
+// one, Drive is asked what it kept and only the rest is sent again.
+func (s *Session) put(chunk []byte, final bool) (*drive.File, error) {
+	start, stalled := time.Now(), 0
+	for {
+		f, err := s.send(chunk, final)
+		if !NetworkError(err) {
+			return f, err
+		}
+		if errors.Is(err, ErrStalled) {
+			// the stall took its time already; retry at once, a few times
+			if stalled++; stalled > stallRetries {
+				return nil, err
+			}
+			start = time.Now()
+		}
+		if err = rideOut(start, err); err != nil {
+			return nil, err
+		}
+		before := s.Offset
+		if f, err = s.send(nil, false); f != nil || err != nil && !NetworkError(err) {
+			return f, err
+		}
+		if err == nil {
+			chunk = s.buf[:len(chunk)-int(s.Offset-before)]
+			if len(chunk) == 0 && !final {
+				return nil, nil
+			}
+		}
+	}
+}
+
+func (s *Session) send(chunk []byte, final bool) (*drive.File, error) {
+	waitTransfers()
+	Slots.acquire(s.Priority)
+	defer Slots.release()
+	ctx, watch := watchStall(context.Background())
+	defer watch.cancel()
+	req, err := http.NewRequest("PUT", s.URI, watch.reader(s.Bandwidth.Reader(bytes.NewReader(chunk))))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = int64(len(chunk))
+	if len(chunk) == 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", s.Size))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", s.Offset, s.Offset+int64(len(chunk))-1, s.Size))
+	}
+	res, err := s.client.Do(req)
+	if err = watch.err(err); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		f := &drive.File{}
+		if err = json.NewDecoder(res.Body).Decode(f); err != nil {
+			return nil, err
+		}
+		s.Offset = s.Size
+		s.buf = nil
+		s.progress.Set(s.Offset)
+		return f, nil
+	case 308: // Resume Incomplete
+		// Drive may have kept fewer bytes than sent; rewind to what it has.
+		acked, err := rangeEnd(res.Header.Get("Range"))
+		if err != nil {
+			return nil, err
+		}
+		sent := acked - s.Offset
+		if sent < 0 || sent > int64(len(s.buf)) {
+			return nil, fmt.Errorf("unexpected upload range %q", res.Header.Get("Range"))
+		}
+		s.buf = s.buf[sent:]
+		s.Offset = acked
+		s.progress.Set(s.Offset)
+		if final {
+			return nil, errors.New("upload session not finished by final chunk")
+		}
+		return nil, nil
+	default:
+		return nil, responseError("upload chunk failed", res)
+	}
+}
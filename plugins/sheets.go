@@ -0,0 +1,68 @@
+package magic
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/sheets/v4"
+)
+
+// SheetsScope must be granted, in addition to the Drive scope, for
+// AppendCSV to work.
+const SheetsScope = sheets.SpreadsheetsScope
+
+// appendBatch is the number of rows sent per append call, which keeps
+// requests well below the API's payload limit.
+const appendBatch = 1000
+
+// AppendCSV appends the CSV rows read from r after the last row of the
+// table at rangeA1 (e.g. "Sheet1" or "Log!A:D") of the spreadsheet id, and
+// returns the number of rows appended. With raw set values are stored as
+// given; otherwise they are parsed as if typed into the UI, so numbers,
+// dates and formulas work.
+func AppendCSV(ctx context.Context, client *http.Client, id string, rangeA1 string, r io.Reader, raw bool) (int64, error) {
+	srv, err := sheets.New(client)
+	if err != nil {
+		return 0, err
+	}
+	input := "USER_ENTERED"
+	if raw {
+		input = "RAW"
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var appended int64
+	for done := false; !done; {
+		var rows [][]interface{}
+		for len(rows) < appendBatch {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return appended, fmt.Errorf("unable to read CSV: %v", err)
+			}
+			row := make([]interface{}, len(rec))
+			for i, v := range rec {
+				row[i] = v
+			}
+			rows = append(rows, row)
+		}
+		if len(rows) == 0 {
+			break
+		}
+		res, err := srv.Spreadsheets.Values.Append(id, rangeA1, &sheets.ValueRange{Values: rows}).
+			ValueInputOption(input).InsertDataOption("INSERT_ROWS").Context(ctx).Do()
+		if err != nil {
+			return appended, fmt.Errorf("unable to append to spreadsheet: %v", err)
+		}
+		if res.Updates != nil {
+			appended += res.Updates.UpdatedRows
+		}
+	}
+	return appended, nil
+}
@@ -0,0 +1,43 @@
+package magic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// GPG is the gpg binary Sign runs.
+var GPG = "gpg"
+
+// SignatureExt is appended to a file's name for its detached signature.
+const SignatureExt = ".asc"
+
+// Sign returns an ASCII armored detached signature of the content of r
+// made with the secret key key (an ID, fingerprint or email). gpg must
+// be able to use the key without a prompt, through gpg-agent or an
+// unprotected key.
+func Sign(key string, r io.Reader) ([]byte, error) {
+	cmd := exec.Command(GPG, "--batch", "--yes", "--armor", "--local-user", key, "--detach-sign", "--output", "-")
+	var out, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = r, &out, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unable to sign: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out.Bytes(), nil
+}
+
+// UploadSignature signs the content of r with key and uploads the
+// signature as name+SignatureExt into the Drive folder parent.
+func UploadSignature(ctx context.Context, srv *drive.Service, key string, r io.Reader, name string, parent string) (*drive.File, error) {
+	sig, err := Sign(key, r)
+	if err != nil {
+		return nil, err
+	}
+	f := &drive.File{Name: name + SignatureExt, MimeType: "application/pgp-signature", Parents: []string{parent}}
+	return srv.Files.Create(f).Media(bytes.NewReader(sig)).Fields(FileFields).Context(ctx).Do()
+}
@@ -0,0 +1,96 @@
+package magic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+)
+
+// Sparse files are uploaded packed: a header line naming the logical size
+// and the data extents, followed by the extents' bytes. Holes are not sent
+// and are recreated on download by only writing the extents.
+
+// SparseKey marks packed sparse uploads in appProperties.
+const SparseKey = "sparse"
+
+const sparseMagic = "MSPARSE1"
+
+type Extent struct {
+	Offset int64 `json:"o"`
+	Length int64 `json:"l"`
+}
+
+type sparseHeader struct {
+	Size    int64    `json:"size"`
+	Extents []Extent `json:"extents"`
+}
+
+// SparseUpload returns the packed representation of f when f has holes,
+// and ok=false (with f rewound) when it is dense.
+func SparseUpload(f *os.File) (r io.Reader, ok bool, err error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	extents, err := dataExtents(f, info.Size())
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	var data int64
+	for _, e := range extents {
+		data += e.Length
+	}
+	if data == info.Size() {
+		return f, false, nil
+	}
+
+	var head bytes.Buffer
+	head.WriteString(sparseMagic + "\n")
+	if err = json.NewEncoder(&head).Encode(sparseHeader{Size: info.Size(), Extents: extents}); err != nil {
+		return nil, false, err
+	}
+	readers := []io.Reader{&head}
+	for _, e := range extents {
+		readers = append(readers, io.NewSectionReader(f, e.Offset, e.Length))
+	}
+	return io.MultiReader(readers...), true, nil
+}
+
+// RestoreSparse unpacks a SparseUpload stream into out, leaving holes
+// where no data was stored.
+func RestoreSparse(out *os.File, r io.Reader) error {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if line != sparseMagic+"\n" {
+		return errors.New("not a packed sparse file")
+	}
+	var h sparseHeader
+	hline, err := br.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(hline, &h); err != nil {
+		return err
+	}
+	if err = out.Truncate(h.Size); err != nil {
+		return err
+	}
+	for _, e := range h.Extents {
+		if _, err = out.Seek(e.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = io.CopyN(out, br, e.Length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
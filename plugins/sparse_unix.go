@@ -0,0 +1,34 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package magic
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataExtents walks f with SEEK_DATA/SEEK_HOLE. Filesystems without hole
+// reporting yield one extent covering the whole file.
+func dataExtents(f *os.File, size int64) ([]Extent, error) {
+	var extents []Extent
+	fd := int(f.Fd())
+	for off := int64(0); off < size; {
+		data, err := unix.Seek(fd, off, unix.SEEK_DATA)
+		if err == unix.ENXIO {
+			// only a hole remains
+			break
+		}
+		if err != nil {
+			return []Extent{{0, size}}, nil
+		}
+		hole, err := unix.Seek(fd, data, unix.SEEK_HOLE)
+		if err != nil {
+			return []Extent{{0, size}}, nil
+		}
+		extents = append(extents, Extent{Offset: data, Length: hole - data})
+		off = hole
+	}
+	return extents, nil
+}
@@ -0,0 +1,86 @@
+package magic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// StallTimeout is how long a transfer request may move no bytes before it
+// is aborted and retried; 0 waits forever.
+var StallTimeout = 60 * time.Second
+
+// ErrStalled is a transfer request aborted after StallTimeout without
+// progress. It counts as a network error.
+var ErrStalled = errors.New("transfer stalled")
+
+// stallRetries is how many stalls in a row a chunk or range is retried
+// before the transfer fails.
+const stallRetries = 3
+
+var stalls int64
+
+// Stalls is the number of transfer requests aborted as stalled so far.
+func Stalls() int64 {
+	return atomic.LoadInt64(&stalls)
+}
+
+// stallWatch cancels the context of a request when its reader goes
+// StallTimeout without a read.
+type stallWatch struct {
+	timer   *time.Timer
+	stalled int32
+	cancel  context.CancelFunc
+}
+
+func watchStall(parent context.Context) (context.Context, *stallWatch) {
+	ctx, cancel := context.WithCancel(parent)
+	w := &stallWatch{cancel: cancel}
+	if StallTimeout > 0 {
+		w.timer = time.AfterFunc(StallTimeout, func() {
+			if atomic.CompareAndSwapInt32(&w.stalled, 0, 1) {
+				atomic.AddInt64(&stalls, 1)
+				cancel()
+			}
+		})
+	}
+	return ctx, w
+}
+
+func (w *stallWatch) touch() {
+	if w.timer != nil && atomic.LoadInt32(&w.stalled) == 0 {
+		w.timer.Reset(StallTimeout)
+	}
+}
+
+// reader returns r, rearming the watch on every read.
+func (w *stallWatch) reader(r io.Reader) io.Reader {
+	return &stallReader{r: r, w: w}
+}
+
+// err stops the watch and returns the request's err, as ErrStalled if the
+// watch aborted it.
+func (w *stallWatch) err(err error) error {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if err != nil && atomic.LoadInt32(&w.stalled) == 1 {
+		return fmt.Errorf("%w: no progress for %v", ErrStalled, StallTimeout)
+	}
+	return err
+}
+
+type stallReader struct {
+	r io.Reader
+	w *stallWatch
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.w.touch()
+	return n, err
+}
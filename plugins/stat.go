@@ -0,0 +1,41 @@
+package magic
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// statFields are the file attributes Stat fetches.
+const statFields = FileFields + ", description, createdTime, parents, owners(emailAddress, displayName), " +
+	"permissions(type, role, emailAddress, domain, expirationTime), quotaBytesUsed, version, " +
+	"starred, trashed, webViewLink, webContentLink"
+
+// FileStat is all Stat tells about a file.
+type FileStat struct {
+	File      *drive.File `json:"file"`
+	Paths     []string    `json:"paths"`
+	Revisions int         `json:"revisions"` // -1 when they may not be listed
+}
+
+// Stat returns the metadata of the Drive file id, the paths it is found
+// at and how many revisions it has.
+func Stat(ctx context.Context, srv *drive.Service, id string) (*FileStat, error) {
+	f, err := srv.Files.Get(id).Fields(statFields).Context(ctx).Do()
+	if err != nil {
+		return nil, Classify(err)
+	}
+	st := &FileStat{File: f}
+	if st.Paths, err = Paths(ctx, srv, id); err != nil {
+		return nil, err
+	}
+	if f.MimeType != FolderMime {
+		err = srv.Revisions.List(id).Fields("nextPageToken, revisions(id)").Pages(ctx, func(r *drive.RevisionList) error {
+			st.Revisions += len(r.Revisions)
+			return nil
+		})
+		if err != nil {
+			st.Revisions = -1
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,172 @@
+package magic
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// StateDB is the local bolt database holding run history.
+var StateDB = filepath.Join("cache", "bolt.db")
+
+var statsBucket = []byte("runs")
+
+// RunStats counts what one run of the tool transferred.
+type RunStats struct {
+	mu sync.Mutex
+
+	Command   string    `json:"command"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	BytesUp   int64     `json:"bytesUp"`
+	BytesDown int64     `json:"bytesDown"`
+	Files     int       `json:"files"`
+	Failures  int       `json:"failures"`
+	Stalls    int       `json:"stalls,omitempty"` // transfer requests retried after StallTimeout
+
+	stallsBefore int64
+}
+
+func NewRun(command string) *RunStats {
+	return &RunStats{Command: command, Start: time.Now(), stallsBefore: Stalls()}
+}
+
+// Uploaded counts a file of n bytes sent to Drive.
+func (r *RunStats) Uploaded(n int64) {
+	r.mu.Lock()
+	r.BytesUp += n
+	r.Files++
+	r.mu.Unlock()
+}
+
+// Downloaded counts a file of n bytes fetched from Drive.
+func (r *RunStats) Downloaded(n int64) {
+	r.mu.Lock()
+	r.BytesDown += n
+	r.Files++
+	r.mu.Unlock()
+}
+
+func (r *RunStats) Failed() {
+	r.mu.Lock()
+	r.Failures++
+	r.mu.Unlock()
+}
+
+// Save stamps the end of the run and stores it in StateDB. Runs that
+// moved nothing and failed nothing are not recorded.
+func (r *RunStats) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Files == 0 && r.Failures == 0 {
+		return nil
+	}
+	r.End = time.Now()
+	r.Stalls = int(Stalls() - r.stallsBefore)
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	db, err := openStateDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists(statsBucket)
+		if err != nil {
+			return err
+		}
+		// RFC 3339 keys in UTC sort chronologically
+		return bk.Put([]byte(r.Start.UTC().Format(time.RFC3339Nano)), b)
+	})
+}
+
+func openStateDB() (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(StateDB), 0755); err != nil {
+		return nil, err
+	}
+	return bolt.Open(StateDB, 0600, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+// LoadRuns returns the runs started at or after since, oldest first.
+func LoadRuns(since time.Time) ([]*RunStats, error) {
+	db, err := openStateDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	var runs []*RunStats
+	err = db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(statsBucket)
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		for k, v := c.Seek([]byte(since.UTC().Format(time.RFC3339Nano))); k != nil; k, v = c.Next() {
+			r := &RunStats{}
+			if err := json.Unmarshal(v, r); err != nil {
+				return err
+			}
+			runs = append(runs, r)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// StatsPeriod sums the runs of one day or week.
+type StatsPeriod struct {
+	Start     time.Time
+	Runs      int
+	BytesUp   int64
+	BytesDown int64
+	Files     int
+	Failures  int
+	Stalls    int
+	Busy      time.Duration // summed run time
+}
+
+// Throughput is the average bytes per second while runs were active.
+func (p StatsPeriod) Throughput() float64 {
+	if p.Busy <= 0 {
+		return 0
+	}
+	return float64(p.BytesUp+p.BytesDown) / p.Busy.Seconds()
+}
+
+// Totals groups runs by local day, or by week starting Monday, newest
+// period first.
+func Totals(runs []*RunStats, weekly bool) []StatsPeriod {
+	byStart := map[time.Time]*StatsPeriod{}
+	for _, r := range runs {
+		t := r.Start.Local()
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		if weekly {
+			day = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		}
+		p := byStart[day]
+		if p == nil {
+			p = &StatsPeriod{Start: day}
+			byStart[day] = p
+		}
+		p.Runs++
+		p.BytesUp += r.BytesUp
+		p.BytesDown += r.BytesDown
+		p.Files += r.Files
+		p.Failures += r.Failures
+		p.Stalls += r.Stalls
+		p.Busy += r.End.Sub(r.Start)
+	}
+	periods := make([]StatsPeriod, 0, len(byStart))
+	for _, p := range byStart {
+		periods = append(periods, *p)
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Start.After(periods[j].Start) })
+	return periods
+}
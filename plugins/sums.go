@@ -0,0 +1,101 @@
+package magic
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// Sums collects checksums of uploaded files into a manifest in the format
+// of sha256sum and friends, so recipients can check downloads with
+// "sha256sum -c SHA256SUMS" without this tool. A nil *Sums collects
+// nothing.
+type Sums struct {
+	Algo string // one of the Checksum constants
+	Root string // names are given relative to this local directory
+	Pin  bool   // keep every revision of the uploaded manifest forever
+
+	mu   sync.Mutex
+	sums map[string]string // name -> hex digest
+}
+
+func NewSums(algo string, root string) *Sums {
+	return &Sums{Algo: algo, Root: root, sums: map[string]string{}}
+}
+
+// Add hashes the local file path under its name relative to Root.
+func (s *Sums) Add(path string) error {
+	if s == nil {
+		return nil
+	}
+	name, err := filepath.Rel(s.Root, path)
+	if err != nil {
+		return err
+	}
+	return s.AddAs(filepath.ToSlash(name), path)
+}
+
+// AddAs hashes the local file path under name.
+func (s *Sums) AddAs(name string, path string) error {
+	if s == nil {
+		return nil
+	}
+	sum, err := LocalChecksum(path, s.Algo)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.sums[name] = sum
+	s.mu.Unlock()
+	return nil
+}
+
+// Len is the number of files collected.
+func (s *Sums) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sums)
+}
+
+// Bytes renders the manifest, one "<digest>  <name>" line per file
+// sorted by name.
+func (s *Sums) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.sums))
+	for n := range s.sums {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, n := range names {
+		fmt.Fprintf(&b, "%s  %s\n", s.sums[n], n)
+	}
+	return b.Bytes()
+}
+
+// Upload writes the manifest as name into the Drive folder parent,
+// replacing the content of a file of that name already there; with Pin
+// the replaced content stays as a revision.
+func (s *Sums) Upload(ctx context.Context, srv *drive.Service, parent string, name string) (*drive.File, error) {
+	q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", QueryString(name), parent)
+	list, err := srv.Files.List().Q(q).Fields("files(id)").PageSize(1).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	body := bytes.NewReader(s.Bytes())
+	if len(list.Files) > 0 {
+		return srv.Files.Update(list.Files[0].Id, &drive.File{}).Media(body).KeepRevisionForever(s.Pin).
+			Fields(FileFields).Context(ctx).Do()
+	}
+	f := &drive.File{Name: name, MimeType: "text/plain", Parents: []string{parent}}
+	return srv.Files.Create(f).Media(body).KeepRevisionForever(s.Pin).Fields(FileFields).Context(ctx).Do()
+}
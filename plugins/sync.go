@@ -0,0 +1,792 @@
+package magic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/text/unicode/norm"
+	"google.golang.org/api/drive/v3"
+)
+
+// SyncStateName is the file Sync keeps in the local directory to remember
+// what both sides looked like after the last run.
+const SyncStateName = ".magicsync.json"
+
+// SnapshotsName is the folder in the synced Drive folder that
+// ImmutableSnapshot runs write changed files to. It is not synced itself.
+const SnapshotsName = ".snapshots"
+
+// SyncTrashName is the folder in the synced Drive folder that holds what
+// sync deleted, with SyncOptions.TrashDays. It is not synced itself.
+const SyncTrashName = ".sync-trash"
+
+// SyncRecord is the last synced version of one file.
+type SyncRecord struct {
+	ID      string    `json:"id"`
+	MD5     string    `json:"md5"`
+	Size    int64     `json:"size"`    // local size
+	ModTime time.Time `json:"modTime"` // local modification time
+}
+
+type syncState struct {
+	Folder string                `json:"folder"`
+	Files  map[string]SyncRecord `json:"files"`
+	Names  NameMap               `json:"names,omitempty"` // files kept under another local name
+}
+
+// Conflict is a file changed on both sides since the last sync.
+type Conflict struct {
+	Path       string
+	LocalSize  int64
+	LocalTime  time.Time
+	RemoteSize int64
+	RemoteTime time.Time
+	RemoteID   string
+	// false when that side deleted the file
+	LocalExists  bool
+	RemoteExists bool
+}
+
+// Resolution is the side kept for a Conflict.
+type Resolution string
+
+const (
+	KeepLocal  Resolution = "local"
+	KeepRemote Resolution = "remote"
+	KeepBoth   Resolution = "both" // the local copy is renamed and uploaded
+	KeepSkip   Resolution = "skip" // left alone until the next run
+)
+
+// ParseResolution accepts the names of the Resolution constants.
+func ParseResolution(s string) (Resolution, error) {
+	switch r := Resolution(s); r {
+	case KeepLocal, KeepRemote, KeepBoth, KeepSkip:
+		return r, nil
+	}
+	return "", fmt.Errorf("unknown conflict resolution %q", s)
+}
+
+type SyncOptions struct {
+	// Resolve picks the outcome of each conflict; conflicts are skipped
+	// when it is nil.
+	Resolve func(Conflict) Resolution
+	// Log, when set, is called for every action taken.
+	Log func(op, path string)
+	// Folder is the FolderConfig of the Drive folder, loaded by Sync when
+	// nil. Its conflict policy applies when Resolve is nil, and files of
+	// types it does not allow are not uploaded.
+	Folder *FolderConfig
+	// TrashDays, when positive, makes deletions on Drive move files into
+	// a folder per day below SyncTrashName instead of trashing them, and
+	// has those folders trashed after that many days.
+	TrashDays int
+	// MaxDelete and MaxDeletePercent, when positive, make Sync fail with
+	// a *DeleteLimitError instead of deleting more files than that, or
+	// more than that share of the files, on either side.
+	MaxDelete        int
+	MaxDeletePercent float64
+	// Immutable, ImmutableRevisions or ImmutableSnapshot, makes backup
+	// runs leave the files on Drive alone: nothing there is deleted, and
+	// changed files become pinned revisions or go into a snapshot folder.
+	Immutable string
+	// Walk selects the local files synced, IgnoreFile patterns always
+	// honored. A local file it leaves out is left alone on both sides.
+	Walk WalkOptions
+	// Download is how files are fetched from Drive. With Posix set,
+	// uploads carry PosixProperties as well, and with Identities, whose
+	// content is decrypted locally, uploads over encrypted files are
+	// marked plain.
+	Download DownloadOptions
+	// Bandwidth, when set, paces uploads.
+	Bandwidth *Bandwidth
+	// Checksum, when set, verifies uploads against Drive's digest for
+	// that algorithm.
+	Checksum string
+}
+
+// Immutable modes of SyncOptions, for backup destinations that must
+// survive a compromised or mistaken client.
+const (
+	// ImmutableRevisions uploads changes as new revisions kept forever,
+	// so every earlier content stays retrievable. Drive pins at most 200
+	// revisions per file.
+	ImmutableRevisions = "revisions"
+	// ImmutableSnapshot uploads changed files as new files below a folder
+	// named after the run in SnapshotsName, leaving the synced copy as it
+	// was.
+	ImmutableSnapshot = "snapshot"
+)
+
+// ParseImmutable accepts "" and the Immutable modes.
+func ParseImmutable(s string) (string, error) {
+	switch s {
+	case "", ImmutableRevisions, ImmutableSnapshot:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown immutable mode %q", s)
+}
+
+type SyncResult struct {
+	Uploaded   []string
+	Downloaded []string
+	Deleted    []string
+	Conflicts  []string
+	Skipped    []string
+}
+
+// Sync makes the Drive folder id and the local directory dir hold the
+// same files. Changes are detected against the state left by the previous
+// run: a side that changed wins, deletions are propagated when the other
+// side is untouched, and files changed on both sides are handed to
+// opt.Resolve. Names are synced as they are, so Sync refuses to run
+// while Names encrypts them.
+func Sync(ctx context.Context, srv *drive.Service, id string, dir string, opt SyncOptions) (*SyncResult, error) {
+	res, err := syncFolder(ctx, srv, id, dir, opt)
+	return res, Classify(err)
+}
+
+func syncFolder(ctx context.Context, srv *drive.Service, id string, dir string, opt SyncOptions) (*SyncResult, error) {
+	if Names != nil {
+		return nil, fmt.Errorf("unable to sync with encrypted names")
+	}
+	dir = filepath.Clean(dir)
+	statePath := filepath.Join(dir, SyncStateName)
+	state := syncState{Folder: id, Files: map[string]SyncRecord{}}
+	if b, err := ioutil.ReadFile(statePath); err == nil {
+		if err = json.Unmarshal(b, &state); err != nil {
+			return nil, fmt.Errorf("unable to read sync state: %v", err)
+		}
+		if state.Folder != id {
+			return nil, fmt.Errorf("%s was synced with folder %s, not %s", dir, state.Folder, id)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if opt.Folder == nil {
+		folder, err := LoadFolderConfig(ctx, srv, id)
+		if err != nil {
+			return nil, err
+		}
+		opt.Folder = folder
+	}
+	if opt.Resolve == nil && opt.Folder.Conflict != "" {
+		policy := opt.Folder.Conflict
+		opt.Resolve = func(Conflict) Resolution { return policy }
+	}
+	remote, _, err := LocalTree(ctx, srv, id)
+	if err != nil {
+		return nil, err
+	}
+	// the folder's settings are not synced, in either direction
+	delete(remote, FolderConfigName)
+	// what the sync-trash and snapshots hold is not synced, but their
+	// folders are reused
+	aside := map[string]*drive.File{}
+	for p, f := range remote {
+		if inFolder(p, SyncTrashName) || inFolder(p, SnapshotsName) {
+			aside[p] = f
+			delete(remote, p)
+		}
+	}
+	walk := opt.Walk
+	walk.Ignore = true
+	walked, err := WalkWith(dir, walk)
+	if err != nil {
+		return nil, err
+	}
+
+	rels := make([]string, len(walked))
+	for i, fi := range walked {
+		rel, err := filepath.Rel(dir, fi.Path)
+		if err != nil {
+			return nil, err
+		}
+		rels[i] = filepath.ToSlash(rel)
+	}
+	remote = preferKnown(localKeys(remote, rels), state.Files)
+	state.Names = NameMap{}
+	for p, f := range remote {
+		if path.Base(p) != f.Name {
+			state.Names[p] = NameRecord{ID: f.Id, Name: f.Name}
+		}
+	}
+
+	s := &syncer{ctx: ctx, srv: srv, dir: dir, opt: opt, state: state.Files, remote: remote,
+		folders: map[string]string{"": id}, local: map[string]os.FileInfo{}, res: &SyncResult{}}
+	for p, f := range remote {
+		if f.MimeType == FolderMime {
+			s.folders[p] = f.Id
+		}
+	}
+	for p, f := range aside {
+		if f.MimeType == FolderMime {
+			s.folders[p] = f.Id
+		}
+	}
+	var paths []string
+	for i, fi := range walked {
+		rel := rels[i]
+		if rel == "." || strings.HasPrefix(rel, SyncStateName) || rel == FolderConfigName || inFolder(rel, SyncTrashName) || inFolder(rel, SnapshotsName) {
+			continue
+		}
+		if fi.IsDir {
+			if _, err := s.folder(rel); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		info, err := os.Stat(fi.Path)
+		if err != nil {
+			return nil, err
+		}
+		s.local[rel] = info
+		paths = append(paths, rel)
+	}
+	for p, f := range remote {
+		if _, ok := s.local[p]; ok || f.MimeType == FolderMime || IsGoogleDoc(f) {
+			continue
+		}
+		if _, err := os.Lstat(s.localPath(p)); err == nil {
+			// there, but filtered out or past a walk limit
+			continue
+		}
+		paths = append(paths, p)
+	}
+	for p := range state.Files {
+		if _, ok := s.local[p]; !ok {
+			if _, ok := remote[p]; !ok {
+				delete(state.Files, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+	if err := s.checkDeletes(paths); err != nil {
+		return s.res, err
+	}
+	if opt.TrashDays > 0 {
+		if err := s.purge(aside); err != nil {
+			return s.res, err
+		}
+	}
+
+	for _, p := range paths {
+		err = s.sync(p)
+		if err == nil {
+			err = s.save(statePath, state)
+		}
+		if err != nil {
+			return s.res, fmt.Errorf("%s: %v", p, err)
+		}
+	}
+	return s.res, s.save(statePath, state)
+}
+
+// localKeys respells the keys of a LocalTree like the local files and
+// folders already there when they compare equal under foldName, so
+// neither side looks like a new file every run.
+func localKeys(remote map[string]*drive.File, local []string) map[string]*drive.File {
+	spelling := map[string]string{}
+	for _, p := range local {
+		spelling[foldName(p)] = p
+	}
+	paths := make([]string, 0, len(remote))
+	for p := range remote {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	out := make(map[string]*drive.File, len(remote))
+	for _, p := range paths {
+		k := ""
+		for _, name := range strings.Split(p, "/") {
+			k = path.Join(k, name)
+			if spelled, ok := spelling[foldName(k)]; ok {
+				k = spelled
+			} else {
+				spelling[foldName(k)] = k
+			}
+		}
+		if _, dup := out[k]; !dup {
+			out[k] = remote[p]
+		}
+	}
+	return out
+}
+
+// preferKnown swaps files of one folder sharing a Drive name so each sits
+// at the path the sync state recorded its ID for. LocalTree numbers such
+// duplicates by ID alone, and a newly added twin must not take over the
+// path of the file synced so far.
+func preferKnown(remote map[string]*drive.File, state map[string]SyncRecord) map[string]*drive.File {
+	at := make(map[string]string, len(remote))
+	for p, f := range remote {
+		at[f.Id] = p
+	}
+	for p, r := range state {
+		q, ok := at[r.ID]
+		f := remote[p]
+		if !ok || q == p || f == nil || f.MimeType == FolderMime || path.Dir(q) != path.Dir(p) || f.Name != remote[q].Name {
+			continue
+		}
+		remote[p], remote[q] = remote[q], f
+		at[remote[p].Id], at[f.Id] = p, q
+	}
+	return remote
+}
+
+// foldName maps names that denote the same local file to one key. macOS
+// stores names decomposed (NFD) while Drive and most other systems keep
+// them composed (NFC), and some file systems ignore case.
+func foldName(p string) string {
+	p = norm.NFC.String(p)
+	if caseInsensitiveFS {
+		p = strings.ToLower(p)
+	}
+	return p
+}
+
+type syncer struct {
+	ctx     context.Context
+	srv     *drive.Service
+	dir     string
+	opt     SyncOptions
+	state   map[string]SyncRecord
+	remote  map[string]*drive.File
+	local   map[string]os.FileInfo
+	folders map[string]string // relative path -> Drive folder ID
+	res     *SyncResult
+	run     string // folder of SnapshotsName, once one was needed
+}
+
+// syncAction is what a run does with one path.
+type syncAction int
+
+const (
+	syncNone syncAction = iota
+	syncForget
+	syncCompare // new on both sides, a conflict unless equal
+	syncConflict
+	syncUpload
+	syncTrash
+	syncDownload
+	syncRemoveLocal
+)
+
+// plan decides the syncAction of p without changing anything.
+func (s *syncer) plan(p string) syncAction {
+	info, hasLocal := s.local[p]
+	f, hasRemote := s.remote[p]
+	prev, known := s.state[p]
+
+	// a deletion counts as a change of that side
+	localChanged := !hasLocal && known ||
+		hasLocal && (!known || info.Size() != prev.Size || !info.ModTime().Equal(prev.ModTime))
+	remoteChanged := !hasRemote && known ||
+		hasRemote && (!known || f.Id != prev.ID || f.Md5Checksum != prev.MD5)
+
+	switch {
+	case !hasLocal && !hasRemote:
+		return syncForget
+	case hasLocal && hasRemote && !known && info.Size() == f.Size:
+		return syncCompare
+	case localChanged && remoteChanged:
+		return syncConflict
+	case localChanged && hasLocal:
+		return syncUpload
+	case localChanged:
+		return syncTrash
+	case remoteChanged && hasRemote:
+		return syncDownload
+	case remoteChanged:
+		return syncRemoveLocal
+	}
+	return syncNone
+}
+
+func (s *syncer) sync(p string) error {
+	info := s.local[p]
+	f := s.remote[p]
+	switch s.plan(p) {
+	case syncForget:
+		delete(s.state, p)
+	case syncCompare:
+		// first sync of a file present on both sides: equal content is
+		// not a conflict
+		sum, err := LocalChecksum(s.localPath(p), ChecksumMD5)
+		if err != nil {
+			return err
+		}
+		if sum == f.Md5Checksum {
+			s.record(p, f)
+			return nil
+		}
+		return s.conflict(p, info, f)
+	case syncConflict:
+		return s.conflict(p, info, f)
+	case syncUpload:
+		return s.upload(p, p, f)
+	case syncTrash:
+		return s.trash(p, f)
+	case syncDownload:
+		return s.download(p, f)
+	case syncRemoveLocal:
+		return s.removeLocal(p)
+	}
+	return nil
+}
+
+// DeleteLimitError is returned by Sync, before any file is changed, when
+// the run would delete more files on one side than SyncOptions allow. An
+// emptied or unmounted directory looks just like every file deleted.
+type DeleteLimitError struct {
+	Side    string // "Drive" or "local"
+	Deletes int
+	Total   int // files on that side
+}
+
+func (e *DeleteLimitError) Error() string {
+	return fmt.Sprintf("sync would delete %d of %d %s files", e.Deletes, e.Total, e.Side)
+}
+
+// checkDeletes enforces opt.MaxDelete and opt.MaxDeletePercent over the
+// paths of the run.
+func (s *syncer) checkDeletes(paths []string) error {
+	if s.opt.MaxDelete <= 0 && s.opt.MaxDeletePercent <= 0 {
+		return nil
+	}
+	var remoteDeletes, localDeletes, remoteFiles int
+	for _, p := range paths {
+		switch s.plan(p) {
+		case syncTrash:
+			if s.opt.Immutable == "" {
+				remoteDeletes++
+			}
+		case syncRemoveLocal:
+			localDeletes++
+		}
+	}
+	for _, f := range s.remote {
+		if f.MimeType != FolderMime && !IsGoogleDoc(f) {
+			remoteFiles++
+		}
+	}
+	for _, e := range []*DeleteLimitError{
+		{Side: "Drive", Deletes: remoteDeletes, Total: remoteFiles},
+		{Side: "local", Deletes: localDeletes, Total: len(s.local)},
+	} {
+		if e.Deletes == 0 {
+			continue
+		}
+		if s.opt.MaxDelete > 0 && e.Deletes > s.opt.MaxDelete ||
+			s.opt.MaxDeletePercent > 0 && float64(e.Deletes)*100 > s.opt.MaxDeletePercent*float64(e.Total) {
+			return e
+		}
+	}
+	return nil
+}
+
+// conflict settles a file changed on both sides; info or f is nil when
+// that side deleted it.
+func (s *syncer) conflict(p string, info os.FileInfo, f *drive.File) error {
+	c := Conflict{Path: p}
+	if f != nil {
+		c.RemoteExists, c.RemoteSize, c.RemoteID = true, f.Size, f.Id
+		c.RemoteTime, _ = time.Parse(time.RFC3339, f.ModifiedTime)
+	}
+	if info != nil {
+		c.LocalExists, c.LocalSize, c.LocalTime = true, info.Size(), info.ModTime()
+	}
+	r := KeepSkip
+	if s.opt.Resolve != nil {
+		r = s.opt.Resolve(c)
+	}
+	s.res.Conflicts = append(s.res.Conflicts, p)
+	switch {
+	case r == KeepLocal && c.LocalExists:
+		return s.upload(p, p, f)
+	case r == KeepLocal:
+		return s.trash(p, f)
+	case r == KeepRemote && c.RemoteExists:
+		return s.download(p, f)
+	case r == KeepRemote:
+		return s.removeLocal(p)
+	case r == KeepBoth && c.LocalExists && c.RemoteExists:
+		moved := conflictName(p, c.LocalTime)
+		local := s.localPath(p)
+		if err := os.Rename(local, s.localPath(moved)); err != nil {
+			return err
+		}
+		s.local[moved] = info
+		if err := s.upload(moved, moved, nil); err != nil {
+			return err
+		}
+		return s.download(p, f)
+	case r == KeepBoth && c.LocalExists:
+		return s.upload(p, p, nil)
+	case r == KeepBoth:
+		return s.download(p, f)
+	}
+	s.res.Skipped = append(s.res.Skipped, p)
+	s.log("skip", p)
+	return nil
+}
+
+// localPath is where the file at the slash separated path p is kept.
+func (s *syncer) localPath(p string) string {
+	return LongPath(filepath.Join(s.dir, filepath.FromSlash(p)))
+}
+
+// conflictName renames "dir/a.txt" to "dir/a.conflict-20060102-150405.txt".
+func conflictName(p string, t time.Time) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + ".conflict-" + t.Format("20060102-150405") + ext
+}
+
+// upload sends the local file at p to Drive as remote path as, replacing
+// the content of existing when it is set.
+func (s *syncer) upload(p string, as string, existing *drive.File) error {
+	if !s.opt.Folder.AllowsName(as) {
+		s.res.Skipped = append(s.res.Skipped, as)
+		s.log("skip, type not allowed", as)
+		return nil
+	}
+	local := s.localPath(p)
+	in, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	body := s.opt.Bandwidth.Reader(in)
+	var props map[string]string
+	if s.opt.Download.Posix {
+		if props, err = PosixProperties(local); err != nil {
+			return err
+		}
+	}
+	pin := s.opt.Immutable != ""
+	var f *drive.File
+	switch {
+	case existing != nil && s.opt.Immutable == ImmutableSnapshot:
+		return s.snapshot(as, body, existing)
+	case existing != nil:
+		if len(s.opt.Download.Identities) > 0 {
+			if props == nil {
+				props = map[string]string{}
+			}
+			props[EncryptedKey] = "0"
+		}
+		f, err = s.srv.Files.Update(existing.Id, &drive.File{AppProperties: props}).Media(body).KeepRevisionForever(pin).
+			Fields(FileFields).Context(s.ctx).Do()
+	default:
+		var parent string
+		if parent, err = s.folder(path.Dir(as)); err != nil {
+			return err
+		}
+		// composed, as Drive clients on other systems expect
+		meta := &drive.File{Name: norm.NFC.String(path.Base(as)), Parents: []string{parent}, AppProperties: props}
+		f, err = s.srv.Files.Create(meta).Media(body).KeepRevisionForever(pin).Fields(FileFields).Context(s.ctx).Do()
+	}
+	if err != nil {
+		return err
+	}
+	if s.opt.Checksum != "" {
+		if err = Verify(local, f, s.opt.Checksum); err != nil {
+			return err
+		}
+	}
+	s.record(as, f)
+	s.res.Uploaded = append(s.res.Uploaded, as)
+	s.log("upload", as)
+	return nil
+}
+
+// snapshot uploads the changed local file p below this run's folder in
+// SnapshotsName. The synced copy existing stays as it was, and stays what
+// the state records for p, so the next run sees neither side changed.
+func (s *syncer) snapshot(p string, in io.Reader, existing *drive.File) error {
+	if s.run == "" {
+		s.run = time.Now().Format(snapshotRun)
+	}
+	parent, err := s.folder(path.Join(SnapshotsName, s.run, path.Dir(p)))
+	if err != nil {
+		return err
+	}
+	meta := &drive.File{Name: norm.NFC.String(path.Base(p)), Parents: []string{parent}}
+	if _, err = s.srv.Files.Create(meta).Media(in).Fields("id").Context(s.ctx).Do(); err != nil {
+		return err
+	}
+	s.record(p, existing)
+	s.res.Uploaded = append(s.res.Uploaded, p)
+	s.log("snapshot", p)
+	return nil
+}
+
+// snapshotRun names the folders of SnapshotsName.
+const snapshotRun = "2006-01-02_150405"
+
+func (s *syncer) download(p string, f *drive.File) error {
+	local := s.localPath(p)
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	if _, err := Download(s.srv, f.Id, local, s.opt.Download); err != nil {
+		return err
+	}
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	s.local[p] = info
+	s.record(p, f)
+	s.res.Downloaded = append(s.res.Downloaded, p)
+	s.log("download", p)
+	return nil
+}
+
+func (s *syncer) removeLocal(p string) error {
+	if err := os.Remove(s.localPath(p)); err != nil {
+		return err
+	}
+	delete(s.state, p)
+	s.res.Deleted = append(s.res.Deleted, p)
+	s.log("delete local", p)
+	return nil
+}
+
+func (s *syncer) trash(p string, f *drive.File) error {
+	if s.opt.Immutable != "" {
+		// the state keeps p, so the file is not downloaded again either
+		s.res.Skipped = append(s.res.Skipped, p)
+		s.log("keep remote, immutable", p)
+		return nil
+	}
+	if s.opt.TrashDays > 0 {
+		return s.moveToSyncTrash(p, f)
+	}
+	_, err := s.srv.Files.Update(f.Id, &drive.File{Trashed: true}).Fields("id").Context(s.ctx).Do()
+	auditLocal("trash", f.Id, f.Name, map[string]string{"reason": "sync", "path": p}, err)
+	if err != nil {
+		return err
+	}
+	delete(s.state, p)
+	s.res.Deleted = append(s.res.Deleted, p)
+	s.log("trash remote", p)
+	return nil
+}
+
+// moveToSyncTrash moves f to its path p below today's folder in
+// SyncTrashName, where it can be restored from until purge.
+func (s *syncer) moveToSyncTrash(p string, f *drive.File) error {
+	dest, err := s.folder(path.Join(SyncTrashName, time.Now().Format(syncTrashDay), path.Dir(p)))
+	if err != nil {
+		return err
+	}
+	from, err := s.folder(path.Dir(p))
+	if err != nil {
+		return err
+	}
+	_, err = s.srv.Files.Update(f.Id, &drive.File{}).AddParents(dest).RemoveParents(from).Fields("id").Context(s.ctx).Do()
+	auditLocal("move", f.Id, f.Name, map[string]string{"reason": "sync", "path": p, "folder": dest}, err)
+	if err != nil {
+		return err
+	}
+	delete(s.state, p)
+	s.res.Deleted = append(s.res.Deleted, p)
+	s.log("move to "+SyncTrashName, p)
+	return nil
+}
+
+// syncTrashDay names the folders of SyncTrashName.
+const syncTrashDay = "2006-01-02"
+
+// inFolder reports whether the relative path p is dir or below it.
+func inFolder(p string, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// purge trashes the days of SyncTrashName older than opt.TrashDays.
+func (s *syncer) purge(aside map[string]*drive.File) error {
+	cutoff := time.Now().AddDate(0, 0, -s.opt.TrashDays)
+	for p, f := range aside {
+		if path.Dir(p) != SyncTrashName || f.MimeType != FolderMime {
+			continue
+		}
+		day, err := time.ParseInLocation(syncTrashDay, path.Base(p), time.Local)
+		if err != nil || !day.Before(cutoff) {
+			continue
+		}
+		_, err = s.srv.Files.Update(f.Id, &drive.File{Trashed: true}).Fields("id").Context(s.ctx).Do()
+		auditLocal("trash", f.Id, f.Name, map[string]string{"reason": "sync purge", "path": p}, err)
+		if err != nil {
+			return fmt.Errorf("%s: %v", p, err)
+		}
+		s.log("purge", p)
+	}
+	return nil
+}
+
+// record notes the local file p and its Drive copy f as in sync. The size
+// and time are those of the local file, which the next run compares them
+// with; f may be an older copy, as for a snapshot.
+func (s *syncer) record(p string, f *drive.File) {
+	r := SyncRecord{ID: f.Id, MD5: f.Md5Checksum, Size: f.Size}
+	if info, ok := s.local[p]; ok {
+		if fresh, err := os.Stat(s.localPath(p)); err == nil {
+			info = fresh
+		}
+		r.Size, r.ModTime = info.Size(), info.ModTime()
+	}
+	s.state[p] = r
+}
+
+// folder returns the ID of the Drive folder at relative path p, creating
+// it and its parents as needed.
+func (s *syncer) folder(p string) (string, error) {
+	if p == "." {
+		p = ""
+	}
+	if id, ok := s.folders[p]; ok {
+		return id, nil
+	}
+	parent, err := s.folder(path.Dir(p))
+	if err != nil {
+		return "", err
+	}
+	f, err := s.srv.Files.Create(&drive.File{Name: norm.NFC.String(path.Base(p)), MimeType: FolderMime, Parents: []string{parent}}).
+		Fields("id").Context(s.ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	s.folders[p] = f.Id
+	s.log("mkdir", p)
+	return f.Id, nil
+}
+
+func (s *syncer) log(op, p string) {
+	if s.opt.Log != nil {
+		s.opt.Log(op, p)
+	}
+}
+
+// save writes the state after every file, so an interrupted run does not
+// mistake its own transfers for changes next time.
+func (s *syncer) save(statePath string, state syncState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := statePath + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath)
+}
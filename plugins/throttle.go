@@ -0,0 +1,128 @@
+package magic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth caps the combined throughput of every reader it wraps. A nil
+// *Bandwidth does not limit. Limits nest: bytes read through a Child
+// count against the child and every ancestor, so per-job caps share one
+// global cap.
+type Bandwidth struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second, 0 for no limit of its own
+	next   time.Time
+	parent *Bandwidth
+}
+
+// NewBandwidth allows about rate bytes per second.
+func NewBandwidth(rate int64) *Bandwidth {
+	if rate <= 0 {
+		return nil
+	}
+	return &Bandwidth{rate: float64(rate)}
+}
+
+// Child returns a limit of rate bytes per second (none if rate <= 0)
+// that also draws from b.
+func (b *Bandwidth) Child(rate int64) *Bandwidth {
+	if b == nil {
+		return NewBandwidth(rate)
+	}
+	if rate < 0 {
+		rate = 0
+	}
+	return &Bandwidth{rate: float64(rate), parent: b}
+}
+
+// ParseProfiles reads per-profile limits written as "backup=2M,sync=500K"
+// into children of parent. A rate of 0 or "off" leaves only parent's cap.
+func ParseProfiles(s string, parent *Bandwidth) (map[string]*Bandwidth, error) {
+	profiles := map[string]*Bandwidth{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid bandwidth profile %q", p)
+		}
+		var rate int64
+		if v := strings.TrimSpace(kv[1]); v != "off" {
+			var err error
+			if rate, err = ParseSize(v); err != nil {
+				return nil, err
+			}
+		}
+		profiles[kv[0]] = parent.Child(rate)
+	}
+	return profiles, nil
+}
+
+// SetRate changes the limit to rate bytes per second, 0 for none of its
+// own; transfers in progress pick it up with their next read.
+func (b *Bandwidth) SetRate(rate int64) {
+	if b == nil {
+		return
+	}
+	if rate < 0 {
+		rate = 0
+	}
+	b.mu.Lock()
+	b.rate = float64(rate)
+	b.mu.Unlock()
+}
+
+// Reader wraps r so reads are paced to the shared rate.
+func (b *Bandwidth) Reader(r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &throttledReader{r: r, b: b}
+}
+
+// take books n bytes and sleeps until they are due here and in every
+// ancestor.
+func (b *Bandwidth) take(n int) {
+	for ; b != nil; b = b.parent {
+		b.wait(n)
+	}
+}
+
+func (b *Bandwidth) wait(n int) {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	wait := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(float64(n) / b.rate * float64(time.Second)))
+	b.mu.Unlock()
+	time.Sleep(wait)
+}
+
+type throttledReader struct {
+	r io.Reader
+	b *Bandwidth
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// small reads keep the pacing smooth across streams
+	if len(p) > 32<<10 {
+		p = p[:32<<10]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.b.take(n)
+	}
+	return n, err
+}
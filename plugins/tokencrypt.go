@@ -0,0 +1,152 @@
+package magic
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// TokenEncrypt makes the cached token be written encrypted with a key
+// derived from TokenPassphrase, for machines without an OS keyring. Token
+// files already encrypted stay encrypted either way.
+var TokenEncrypt bool
+
+// TokenPassphraseEnv names the environment variable checked for the
+// passphrase before prompting for it.
+const TokenPassphraseEnv = "GDRIVE_TOKEN_PASSPHRASE"
+
+// TokenPassphrase supplies the passphrase of encrypted token files. It is
+// called at most once per process.
+var TokenPassphrase = Passphrase("")
+
+// sealedToken is the on-disk form of an encrypted token: AES-256-GCM under
+// a scrypt key of the passphrase.
+type sealedToken struct {
+	Cipher string `json:"cipher"` // always "scrypt-aes-256-gcm"
+	N      int    `json:"n"`
+	R      int    `json:"r"`
+	P      int    `json:"p"`
+	Salt   []byte `json:"salt"`
+	Nonce  []byte `json:"nonce"`
+	Data   []byte `json:"data"`
+}
+
+const sealCipher = "scrypt-aes-256-gcm"
+
+var (
+	passphraseOnce sync.Once
+	passphrase     []byte
+	passphraseErr  error
+	// tokenSealed is set once an encrypted token file was read, so it is
+	// saved encrypted again.
+	tokenSealed bool
+)
+
+// Passphrase returns a TokenPassphrase reading the first line of keyFile,
+// or, when keyFile is empty, TokenPassphraseEnv or else a prompt on the
+// terminal.
+func Passphrase(keyFile string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if keyFile != "" {
+			b, err := ioutil.ReadFile(keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read token key file: %v", err)
+			}
+			return bytes.TrimRight(bytes.SplitN(b, []byte("\n"), 2)[0], "\r"), nil
+		}
+		if s := os.Getenv(TokenPassphraseEnv); s != "" {
+			return []byte(s), nil
+		}
+		fmt.Fprint(os.Stderr, "Token passphrase: ")
+		defer fmt.Fprintln(os.Stderr)
+		if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+			return term.ReadPassword(fd)
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("unable to read token passphrase: %v", err)
+		}
+		return []byte(strings.TrimRight(line, "\r\n")), nil
+	}
+}
+
+func tokenKey() ([]byte, error) {
+	passphraseOnce.Do(func() {
+		passphrase, passphraseErr = TokenPassphrase()
+		if passphraseErr == nil && len(passphrase) == 0 {
+			passphraseErr = errors.New("empty token passphrase")
+		}
+	})
+	return passphrase, passphraseErr
+}
+
+func sealToken(plain []byte) ([]byte, error) {
+	key, err := tokenKey()
+	if err != nil {
+		return nil, err
+	}
+	s := sealedToken{Cipher: sealCipher, N: 1 << 15, R: 8, P: 1, Salt: make([]byte, 16)}
+	if _, err = rand.Read(s.Salt); err != nil {
+		return nil, err
+	}
+	gcm, err := tokenCipher(key, s)
+	if err != nil {
+		return nil, err
+	}
+	s.Nonce = make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(s.Nonce); err != nil {
+		return nil, err
+	}
+	s.Data = gcm.Seal(nil, s.Nonce, plain, nil)
+	return json.Marshal(s)
+}
+
+// openToken decrypts b when it is an encrypted token file, and returns
+// it unchanged otherwise.
+func openToken(b []byte) ([]byte, error) {
+	var s sealedToken
+	if json.Unmarshal(b, &s) != nil || s.Cipher == "" {
+		return b, nil
+	}
+	if s.Cipher != sealCipher {
+		return nil, fmt.Errorf("unknown token cipher %q", s.Cipher)
+	}
+	tokenSealed = true
+	key, err := tokenKey()
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := tokenCipher(key, s)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, s.Nonce, s.Data, nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt token: wrong passphrase or damaged file")
+	}
+	return plain, nil
+}
+
+func tokenCipher(passphrase []byte, s sealedToken) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, s.Salt, s.N, s.R, s.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
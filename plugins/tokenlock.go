@@ -0,0 +1,60 @@
+package magic
+
+import (
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// Several processes may share TokenFile. Refreshes take a lock on a
+// sibling file and re-read the cache first, so only one of them asks
+// Google for a new token and the others pick it up, and writes replace the
+// file atomically so nobody reads half a token.
+
+func tokenLockPath() string {
+	return TokenFile + ".lock"
+}
+
+// sharedToken is a TokenSource refreshing through the token cache file.
+type sharedToken struct {
+	ctx    context.Context
+	config *oauth2.Config
+	tok    *oauth2.Token
+	scopes []string
+}
+
+// Token is called by oauth2.ReuseTokenSource, which serializes callers
+// within the process, whenever its token expired.
+func (s *sharedToken) Token() (*oauth2.Token, error) {
+	unlock, err := lockToken()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if fresh, scopes, err := tokenFromFile(TokenFile); err == nil && fresh.Valid() {
+		s.tok, s.scopes = fresh, scopes
+		return fresh, nil
+	}
+	tok, err := s.config.TokenSource(s.ctx, s.tok).Token()
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	if err = saveToken(TokenFile, tok, s.scopes); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// tokenClient returns a client for tok whose refreshes are shared with
+// other processes through TokenFile; a token from TokenEnv is refreshed in
+// memory only.
+func tokenClient(ctx context.Context, config *oauth2.Config, tok *oauth2.Token, scopes []string) *http.Client {
+	if os.Getenv(TokenEnv) != "" {
+		return config.Client(ctx, tok)
+	}
+	src := &sharedToken{ctx: ctx, config: config, tok: tok, scopes: scopes}
+	return oauth2.NewClient(ctx, oauth2.ReuseTokenSource(tok, src))
+}
@@ -0,0 +1,33 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package magic
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// staleLock is how old a lock file must be before it is taken to be left
+// behind by a crashed process.
+const staleLock = time.Minute
+
+// lockToken blocks until this process created the token lock file.
+func lockToken() (unlock func(), err error) {
+	os.MkdirAll(filepath.Dir(tokenLockPath()), 0700)
+	for deadline := time.Now().Add(2 * staleLock); time.Now().Before(deadline); {
+		f, err := os.OpenFile(tokenLockPath(), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(tokenLockPath()) }, nil
+		}
+		if info, err := os.Stat(tokenLockPath()); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(tokenLockPath())
+			continue
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, errors.New("unable to lock token: timed out")
+}
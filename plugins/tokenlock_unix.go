@@ -0,0 +1,28 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package magic
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockToken blocks until this process holds the token cache lock.
+func lockToken() (unlock func(), err error) {
+	os.MkdirAll(filepath.Dir(tokenLockPath()), 0700)
+	f, err := os.OpenFile(tokenLockPath(), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open token lock: %v", err)
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to lock token: %v", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,134 @@
+package magic
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// RemoteTree lists the Drive folder id recursively, keyed by slash
+// separated path relative to it. Folders are included; when names repeat
+// within a folder the first one listed wins.
+func RemoteTree(ctx context.Context, srv *drive.Service, id string) (map[string]*drive.File, error) {
+	tree := map[string]*drive.File{}
+	queue := []string{""}
+	folders := map[string]string{"": id}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		q := "'" + folders[dir] + "' in parents and trashed=false"
+		it := ListIter(ctx, srv, q)
+		for it.Next() {
+			f := it.File()
+			p := path.Join(dir, f.Name)
+			if _, dup := tree[p]; dup {
+				continue
+			}
+			tree[p] = f
+			if f.MimeType == FolderMime {
+				folders[p] = f.Id
+				queue = append(queue, p)
+			}
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// IsGoogleDoc reports whether f is a native Docs/Sheets/... file, which
+// has neither a byte size nor a checksum.
+func IsGoogleDoc(f *drive.File) bool {
+	return strings.HasPrefix(f.MimeType, "application/vnd.google-apps.") && f.MimeType != FolderMime
+}
+
+// ErrNoPath is returned by ResolvePath for paths that do not exist.
+var ErrNoPath = errors.New("no such path")
+
+// ResolvePath walks the slash separated path p down from the Drive folder
+// id; an empty path is the folder itself. When names repeat within a
+// folder the first one listed wins, as in RemoteTree.
+func ResolvePath(ctx context.Context, srv *drive.Service, id string, p string) (*drive.File, error) {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		f, err := srv.Files.Get(id).Fields(FileFields).Context(ctx).Do()
+		if isNotFound(err) {
+			return nil, ErrNoPath
+		}
+		return f, err
+	}
+	var f *drive.File
+	for _, name := range strings.Split(p, "/") {
+		if f != nil && f.MimeType != FolderMime {
+			return nil, ErrNoPath
+		}
+		q := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", QueryString(name), id)
+		list, err := srv.Files.List().Q(q).Fields("files(" + FileFields + ")").PageSize(1).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		if len(list.Files) == 0 {
+			return nil, ErrNoPath
+		}
+		f = list.Files[0]
+		id = f.Id
+	}
+	return f, nil
+}
+
+// MyDrive is the name paths give the root of My Drive.
+const MyDrive = "My Drive"
+
+// Paths returns every slash separated path of the Drive file id, one per
+// chain of parents, from the root of My Drive or else from the topmost
+// folder that can be seen, like "My Drive/Backups/db.sql.gz".
+func Paths(ctx context.Context, srv *drive.Service, id string) ([]string, error) {
+	root, err := srv.Files.Get("root").Fields("id").Context(ctx).Do()
+	if err != nil {
+		return nil, Classify(err)
+	}
+	known := map[string]*drive.File{}
+	var up func(id string, seen map[string]bool) ([]string, error)
+	up = func(id string, seen map[string]bool) ([]string, error) {
+		if id == root.Id {
+			return []string{MyDrive}, nil
+		}
+		f, ok := known[id]
+		if !ok {
+			var err error
+			if f, err = srv.Files.Get(id).Fields("id, name, parents").Context(ctx).Do(); err != nil {
+				return nil, Classify(err)
+			}
+			known[id] = f
+		}
+		name := Names.Display(f.Name)
+		var paths []string
+		for _, p := range f.Parents {
+			if seen[p] {
+				continue
+			}
+			seen[p] = true
+			above, err := up(p, seen)
+			delete(seen, p)
+			if errors.Is(err, ErrNotFound) {
+				continue // a parent shared no further than the file
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range above {
+				paths = append(paths, a+"/"+name)
+			}
+		}
+		if len(paths) == 0 {
+			paths = []string{name}
+		}
+		return paths, nil
+	}
+	return up(id, map[string]bool{id: true})
+}
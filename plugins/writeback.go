@@ -0,0 +1,162 @@
+package magic
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// DefaultQuiet is how long a WriteBack waits after the last save of a file
+// before uploading it.
+const DefaultQuiet = 5 * time.Second
+
+// WriteBack is the write cache of a mount: writes go to a local copy of
+// each Drive file, and the copy is uploaded once no save of it came for
+// Quiet. Editors that save through temporary files and several writes
+// thus make one revision per save instead of dozens.
+type WriteBack struct {
+	srv *drive.Service
+	dir string
+	// Quiet is the pause after the last Release that triggers the upload.
+	Quiet time.Duration
+	// Log, when set, is told of every upload.
+	Log func(id string, f *drive.File, err error)
+
+	mu    sync.Mutex
+	files map[string]*cachedFile // by Drive file ID
+	wg    sync.WaitGroup
+}
+
+type cachedFile struct {
+	path      string
+	timer     *time.Timer
+	open      int // Opens not yet Released
+	dirty     bool
+	uploading bool
+}
+
+// NewWriteBack returns a WriteBack keeping its copies in the directory dir.
+func NewWriteBack(srv *drive.Service, dir string) (*WriteBack, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &WriteBack{srv: srv, dir: dir, Quiet: DefaultQuiet, files: map[string]*cachedFile{}}, nil
+}
+
+// Open returns the local copy of the Drive file id opened for writing,
+// fetching the content first unless trunc is set or a copy is cached.
+func (w *WriteBack) Open(id string, trunc bool) (*os.File, error) {
+	w.mu.Lock()
+	c, ok := w.files[id]
+	if !ok {
+		c = &cachedFile{path: filepath.Join(w.dir, id)}
+		w.files[id] = c
+	}
+	c.open++
+	w.mu.Unlock()
+	flags := os.O_RDWR | os.O_CREATE
+	if trunc {
+		flags |= os.O_TRUNC
+	} else if !ok {
+		if _, err := Download(w.srv, id, c.path, DownloadOptions{}); err != nil {
+			w.mu.Lock()
+			delete(w.files, id)
+			w.mu.Unlock()
+			return nil, err
+		}
+	}
+	out, err := os.OpenFile(c.path, flags, 0600)
+	if err != nil {
+		w.mu.Lock()
+		c.open--
+		w.mu.Unlock()
+	}
+	return out, err
+}
+
+// Cached returns the path of the local copy of id, which reads must use
+// while an upload of it is pending.
+func (w *WriteBack) Cached(id string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c, ok := w.files[id]
+	if !ok {
+		return "", false
+	}
+	return c.path, true
+}
+
+// Release notes a save of id, closing the file Open returned, and
+// schedules the upload Quiet from now, replacing a schedule not yet due.
+func (w *WriteBack) Release(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c, ok := w.files[id]
+	if !ok {
+		return
+	}
+	c.open--
+	c.dirty = true
+	if c.uploading {
+		// uploaded again once the running upload ends
+		return
+	}
+	if c.timer != nil && !c.timer.Stop() {
+		// due already: the upload starting reads this save too
+		return
+	}
+	if c.timer == nil {
+		w.wg.Add(1)
+	}
+	c.timer = time.AfterFunc(w.Quiet, func() { w.upload(id, c) })
+}
+
+// upload sends the copy of id to Drive, and again when saves came in
+// meanwhile.
+func (w *WriteBack) upload(id string, c *cachedFile) {
+	w.mu.Lock()
+	c.timer, c.dirty, c.uploading = nil, false, true
+	w.mu.Unlock()
+
+	var f *drive.File
+	in, err := os.Open(c.path)
+	if err == nil {
+		f, err = w.srv.Files.Update(id, &drive.File{}).Media(in).Fields(FileFields).Context(context.Background()).Do()
+		in.Close()
+		err = Classify(err)
+	}
+	if w.Log != nil {
+		w.Log(id, f, err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	c.uploading = false
+	if c.dirty {
+		c.timer = time.AfterFunc(w.Quiet, func() { w.upload(id, c) })
+		return
+	}
+	if err == nil && c.open == 0 {
+		os.Remove(c.path)
+		delete(w.files, id)
+	}
+	w.wg.Done()
+}
+
+// Flush uploads every pending copy now and waits for the uploads, as on
+// unmount.
+func (w *WriteBack) Flush() {
+	w.mu.Lock()
+	for id, c := range w.files {
+		if c.timer != nil && c.timer.Stop() {
+			id, c := id, c
+			go w.upload(id, c)
+		}
+	}
+	w.mu.Unlock()
+	w.wg.Wait()
+}
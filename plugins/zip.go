@@ -0,0 +1,74 @@
+package magic
+
+import (
+	"archive/zip"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
+)
+
+// WriteZip streams the Drive folder id to w as a zip archive, downloading
+// each file straight into its entry under the name LocalTree gives it. Native Google documents have no bytes
+// to download and are left out. Already compressed media are stored
+// rather than deflated again.
+func WriteZip(ctx context.Context, srv *drive.Service, id string, w io.Writer) error {
+	tree, _, err := LocalTree(ctx, srv, id)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(tree))
+	for p := range tree {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	zw := zip.NewWriter(w)
+	for _, p := range paths {
+		f := tree[p]
+		if IsGoogleDoc(f) {
+			continue
+		}
+		h := &zip.FileHeader{Name: p, Method: zip.Deflate}
+		if t, err := time.Parse(time.RFC3339, f.ModifiedTime); err == nil {
+			h.Modified = t
+		}
+		if f.MimeType == FolderMime {
+			h.Name += "/"
+			h.Method = zip.Store
+		} else if compressed(f.MimeType) {
+			h.Method = zip.Store
+		}
+		entry, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		if f.MimeType == FolderMime {
+			continue
+		}
+		res, err := srv.Files.Get(f.Id).Context(ctx).Download()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// compressed reports whether deflating files of mimeType would gain
+// next to nothing.
+func compressed(mimeType string) bool {
+	switch mimeType {
+	case "image/svg+xml", "image/bmp", "image/tiff":
+		return false
+	case "application/zip", "application/gzip", "application/x-7z-compressed", "application/x-rar-compressed", "application/x-xz", "application/x-bzip2":
+		return true
+	}
+	return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/")
+}
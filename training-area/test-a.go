@@ -1,107 +1,86 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"mime"
 	"net/http"
-	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	magic "../plugins"
 	"golang.org/x/net/context"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/drive/v2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
 )
 
 var (
-	inputPath  *string
-	outputFile *string
-	folderName *string
+	inputPath       *string
+	outputFile      *string
+	folderName      *string
+	auditLog        *string
+	posix           *bool
+	downloadID      *string
+	links           *string
+	sparse          *bool
+	atomic          *bool
+	immutable       *string
+	ignore          *bool
+	filter          magic.Filter
+	maxDepth        *int
+	maxFiles        *int
+	limitWarn       *bool
+	scanners        *int
+	transfers       *int
+	packSize        int64
+	packBelow       int64
+	unpackID        *string
+	packPath        *string
+	downloads       magic.DownloadOptions
+	checksum        *string
+	conflicts       *string
+	queue           *magic.Queue
+	quotaResume     *bool
+	run             *magic.RunStats
+	labelFlags      listFlag
+	labels          []*magic.LabelSpec
+	asGoogleDoc     *bool
+	fullAccess      *bool
+	timestampFolder *bool
+	sumsName        *string
+	signKey         *string
+	sums            *magic.Sums
+	timestampFormat *string
+	destConfig      *magic.FolderConfig // of the upload destination
+	recipients      []*magic.Recipient  // of -encrypt-to
+	progress        magic.ProgressReporter
+	extPlugins      []*magic.ExternalPlugin // of -plugin-dir
+	rules           magic.Rules             // of -config
+	ruleLabels      map[*magic.Rule][]*magic.LabelSpec
+	hostname, _     = os.Hostname()
+	listColumns     string // of -columns or -long
+	listOrder       string // of -sort, as a Drive orderBy
+	copyWhat        *string
+	client          *http.Client
 )
 
-// getClient uses a Context and Config to retrieve a Token
-// then generate a Client. It returns the generated Client.
-func getClient(ctx context.Context, config *oauth2.Config) *http.Client {
-	cacheFile, err := tokenCacheFile()
-	if err != nil {
-		log.Fatalf("Unable to get path to cached credential file. %v", err)
-	}
-	tok, err := tokenFromFile(cacheFile)
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(cacheFile, tok)
-	}
-	return config.Client(ctx, tok)
-}
-
-// getTokenFromWeb uses Config to request a Token.
-// It returns the retrieved Token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-	fmt.Printf("Enter Verfication Code:\n")
-
-	var code string
-	if _, err := fmt.Scan(&code); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
-	}
-
-	tok, err := config.Exchange(oauth2.NoContext, code)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
-	}
-	return tok
-}
-
-// tokenCacheFile generates credential file path/filename.
-// It returns the generated credential path/filename.
-func tokenCacheFile() (string, error) {
-	usr, err := user.Current()
-	if err != nil {
-		// return "", err
-		return usr.HomeDir, err
-	}
-	// tokenCacheDir := filepath.Join(usr.HomeDir, ".credentials")
-	tokenCacheDir := ".credentials"
-	os.MkdirAll(tokenCacheDir, 0700)
-	return filepath.Join(tokenCacheDir,
-		url.QueryEscape("drive-api-cert.json")), err
-}
+// listFlag collects a flag given several times.
+type listFlag []string
 
-// tokenFromFile retrieves a Token from a given file path.
-// It returns the retrieved Token and any read error encountered.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	t := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(t)
-	defer f.Close()
-	return t, err
-}
-
-// saveToken uses a file path to create a file and store the
-// token in it.
-func saveToken(file string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", file)
-	f, err := os.Create(file)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error { *l = append(*l, v); return nil }
 
 func Comma(v int64) string {
 	sign := ""
@@ -158,133 +137,2709 @@ func MeasureTransferRate() func(int64) string {
 	}
 }
 
-func getOrCreateFolder(d *drive.Service, folderName string) string {
-	folderId := ""
-	if folderName == "" {
-		return ""
+// consoleProgress shows the rate and bytes of the running transfer on one
+// line. With several transfers at once the line would garble, so it only
+// shows while a single one runs.
+type consoleProgress struct {
+	mu     sync.Mutex
+	rates  map[string]func(int64) string
+	active int
+}
+
+func (p *consoleProgress) OnStart(t magic.TransferInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rates == nil {
+		p.rates = map[string]func(int64) string{}
 	}
-	q := fmt.Sprintf("title=\"%s\" and mimeType=\"application/vnd.google-apps.folder\"", folderName)
+	p.rates[t.Name] = MeasureTransferRate()
+	p.active++
+}
 
-	r, err := d.Files.List().Q(q).MaxResults(1).Do()
-	if err != nil {
-		log.Fatalf("Unable to retrieve foldername.", err)
+func (p *consoleProgress) OnProgress(t magic.TransferInfo, done int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate := p.rates[t.Name]
+	if p.active != 1 || rate == nil {
+		return
+	}
+	verb := "Downloaded"
+	if t.Upload {
+		verb = "Uploaded"
+	}
+	if t.Size < 0 {
+		fmt.Printf("%s at %s, %s\r", verb, rate(done), Comma(done))
+		return
 	}
+	fmt.Printf("%s at %s, %s/%s\r", verb, rate(done), Comma(done), Comma(t.Size))
+}
 
-	if len(r.Items) > 0 {
-		folderId = r.Items[0].Id
-	} else {
-		// no folder found create new
+func (p *consoleProgress) OnComplete(t magic.TransferInfo, f *drive.File) { p.end(t) }
+
+func (p *consoleProgress) OnError(t magic.TransferInfo, err error) { p.end(t) }
+
+func (p *consoleProgress) end(t magic.TransferInfo) {
+	p.mu.Lock()
+	delete(p.rates, t.Name)
+	p.active--
+	p.mu.Unlock()
+}
+
+// newProgress returns the reporter -progress names.
+func newProgress(kind string) (magic.ProgressReporter, error) {
+	switch kind {
+	case "plain":
+		return &consoleProgress{}, nil
+	case "json":
+		return magic.JSONProgress(os.Stderr), nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%q is not plain, json or none", kind)
+}
+
+func getOrCreateFolder(d *drive.Service, folderName string, parentId string) string {
+	if folderName == "" {
+		return parentId
+	}
+	folderId, dups, err := magic.GetOrCreateFolder(context.Background(), d, parentId, folderName)
+	if err != nil {
+		log.Fatalf("Unable to retrieve foldername: %v", err)
+	}
+	switch {
+	case dups == 0:
 		fmt.Printf("Folder not found. Create new folder : %s\n", folderName)
-		f := &drive.File{Title: folderName, Description: "Auto Create by gdrive-upload", MimeType: "application/vnd.google-apps.folder"}
-		r, err := d.Files.Insert(f).Do()
+	case dups > 1:
+		fmt.Printf("Warning: %d folders named %s; using %s (see dedupe-names)\n", dups, folderName, folderId)
+	}
+	return folderId
+}
+
+func mimeTypeOf(filename string) string {
+	ext := filepath.Ext(filename)
+	mimeType := "application/octet-stream"
+	if ext != "" {
+		mimeType = mime.TypeByExtension(ext)
+	}
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return mimeType
+}
+
+func currentUser() string {
+	if usr, err := user.Current(); err == nil {
+		return usr.Username
+	}
+	return ""
+}
+
+// ruleFor returns the -config rule matching the local file, or nil.
+func ruleFor(filename string) *magic.Rule {
+	rf := magic.RuleFile{Path: filename, Host: hostname}
+	if info, err := os.Stat(filename); err == nil {
+		rf.Size, rf.ModTime = info.Size(), info.ModTime()
+	}
+	return rules.Match(rf)
+}
+
+// rulesLabel reports whether any rule applies labels.
+func rulesLabel() bool {
+	for _, r := range rules {
+		if len(r.Labels) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// routed reports whether a rule sends filename to a folder of its own.
+func routed(filename string) bool {
+	r := ruleFor(filename)
+	return r != nil && r.Folder != ""
+}
+
+// labelsFor returns the -label labels and those of the rule for filename.
+func labelsFor(filename string) []*magic.LabelSpec {
+	extra := ruleLabels[ruleFor(filename)]
+	if len(extra) == 0 {
+		return labels
+	}
+	return append(append([]*magic.LabelSpec(nil), labels...), extra...)
+}
+
+// fileMeta prepares the Drive metadata for uploading filename.
+func fileMeta(title string, description string, parentId string, mimeType string, filename string) (*drive.File, error) {
+	if r := ruleFor(filename); r != nil && r.Folder != "" {
+		parentId = r.Folder
+	}
+	f := &drive.File{Name: magic.Names.Encrypt(title), Description: description, MimeType: mimeType}
+	if parentId != "" {
+		f.Parents = []string{parentId}
+	}
+	if *posix {
+		props, err := magic.PosixProperties(filename)
 		if err != nil {
-			fmt.Printf("An error occurred when create folder: %v\n", err)
+			return nil, err
 		}
-		folderId = r.Id
+		f.AppProperties = props
 	}
-	return folderId
+	return f, nil
+}
+
+// converts reports whether filename becomes a native Google document
+// under -as-google-doc.
+func converts(filename string) bool {
+	target, _ := magic.ImportMime(filename)
+	return *asGoogleDoc && target != ""
+}
+
+// convertMeta turns f into a native Google document under -as-google-doc,
+// dropping the extension from its name, and returns the content type to
+// send the file's bytes as; "" leaves f unchanged.
+func convertMeta(f *drive.File, filename string) string {
+	if !converts(filename) {
+		return ""
+	}
+	target, source := magic.ImportMime(filename)
+	f.MimeType = target
+	f.Name = strings.TrimSuffix(f.Name, filepath.Ext(f.Name))
+	return source
 }
 
 func uploadFile(d *drive.Service, title string, description string,
-	parentName string, mimeType string, filename string) (*drive.File, error) {
+	parentId string, mimeType string, filename string) (*drive.File, error) {
 	input, err := os.Open(filename)
 	if err != nil {
 		fmt.Printf("An error occurred: %v\n", err)
 		return nil, err
 	}
-	// Grab file info
-	inputInfo, err := input.Stat()
+	defer input.Close()
+
+	fmt.Println("Start upload")
+	f, err := fileMeta(title, description, parentId, mimeType, filename)
 	if err != nil {
 		return nil, err
 	}
-
-	parentId := getOrCreateFolder(d, parentName)
-
-	fmt.Println("Start upload")
-	f := &drive.File{Title: title, Description: description, MimeType: mimeType}
-	if parentId != "" {
-		p := &drive.ParentReference{Id: parentId}
-		f.Parents = []*drive.ParentReference{p}
+	var body io.Reader = input
+	if len(recipients) > 0 {
+		env, err := magic.NewEnvelope(recipients)
+		if err != nil {
+			return nil, err
+		}
+		if f.AppProperties == nil {
+			f.AppProperties = map[string]string{}
+		}
+		for k, v := range env.Properties() {
+			f.AppProperties[k] = v
+		}
+		sealed := env.EncryptReader(input)
+		defer sealed.Close()
+		body = sealed
+		mimeType = "application/octet-stream"
+		f.MimeType = mimeType
+		fmt.Printf("Encrypting to %d recipients\n", len(recipients))
+	} else if *sparse {
+		packed, ok, err := magic.SparseUpload(input)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fmt.Println("Sparse file, uploading data extents only")
+			body = packed
+			if f.AppProperties == nil {
+				f.AppProperties = map[string]string{}
+			}
+			f.AppProperties[magic.SparseKey] = "1"
+		}
+	}
+	encrypted := magic.IsEncrypted(f)
+	if f.AppProperties[magic.SparseKey] != "1" && !encrypted {
+		if source := convertMeta(f, filename); source != "" {
+			mimeType = source
+		}
+	}
+	name := f.Name
+	if *atomic {
+		f.Name = magic.StagingName(name)
 	}
 	getRate := MeasureTransferRate()
-
-	// progress call back
-	showProgress := func(current, total int64) {
-		fmt.Printf("Uploaded at %s, %s/%s\r", getRate(current), Comma(current), Comma(total))
+	size := int64(-1)
+	if info, err := input.Stat(); err == nil && body == input {
+		size = info.Size()
 	}
+	track := magic.Track(progress, magic.TransferInfo{Name: filename, Size: size, Upload: true})
 
-	r, err := d.Files.Insert(f).ResumableMedia(context.Background(), input, inputInfo.Size(), mimeType).ProgressUpdater(showProgress).Do()
+	x := &magic.TransferJob{TransferInfo: magic.TransferInfo{Name: filename, Size: size, Upload: true}, File: f, Body: body}
+	r, err := magic.RunTransfer(context.Background(), x, func(ctx context.Context, x *magic.TransferJob) (*drive.File, error) {
+		return d.Files.Create(x.File).Media(x.Body, googleapi.ContentType(mimeType)).ProgressUpdater(track.Updater()).Fields(magic.FileFields).Context(ctx).Do()
+	})
+	track.Done(r, err)
 	if err != nil {
 		fmt.Printf("An error occurred: %v\n", err)
+		run.Failed()
 		return nil, err
 	}
+	run.Uploaded(r.Size)
+	if err := magic.ApplyLabels(context.Background(), d, r.Id, labelsFor(filename)); err != nil {
+		return r, err
+	}
 
 	// Total bytes transferred
-	bytes := r.FileSize
+	bytes := r.Size
 	// Print information about uploaded file
-	fmt.Printf("Uploaded '%s' at %s, total %s\n", r.Title, getRate(bytes), FileSizeFormat(bytes, false))
+	fmt.Printf("Uploaded '%s' at %s, total %s\n", magic.Names.Display(r.Name), getRate(bytes), FileSizeFormat(bytes, false))
 	fmt.Printf("Upload Done. ID : %s\n", r.Id)
+	// neither sparse packs, encrypted files nor converted documents keep
+	// the file's bytes
+	algo := *checksum
+	if algo == "" && *atomic {
+		algo = magic.ChecksumMD5
+	}
+	if algo != "" && f.AppProperties[magic.SparseKey] != "1" && !encrypted && !magic.IsGoogleDoc(r) {
+		if err := magic.Verify(filename, r, algo); err != nil {
+			fmt.Printf("Verification of %s failed: %v\n", filename, err)
+			if *atomic {
+				if derr := magic.Discard(context.Background(), d, r); derr != nil {
+					fmt.Printf("An error occurred removing %s: %v\n", r.Name, derr)
+				}
+			}
+			return r, err
+		}
+		fmt.Printf("Verified %s checksum\n", algo)
+	}
+	if *atomic {
+		if r, err = magic.Publish(context.Background(), d, r, name); err != nil {
+			return nil, fmt.Errorf("unable to publish %s: %v", name, err)
+		}
+		fmt.Printf("Published as '%s'\n", r.Name)
+	}
+
+	// a rule may have routed the file elsewhere
+	if len(f.Parents) > 0 {
+		parentId = f.Parents[0]
+	}
+	if f.AppProperties[magic.SparseKey] != "1" && !magic.IsGoogleDoc(r) {
+		addSum(filename, title)
+		if err := signUpload(d, filename, r.Name, parentId); err != nil {
+			return r, err
+		}
+	}
+
+	magic.Audit(magic.AuditEntry{
+		Actor:  currentUser(),
+		Op:     "upload",
+		FileID: r.Id,
+		Name:   r.Name,
+		Params: map[string]string{"source": filename, "folder": parentId},
+	})
 	return r, nil
 }
 
-func main() {
+// addSum records an uploaded file for -checksum-manifest: under its Drive
+// name for single file uploads, relative to the tree otherwise.
+func addSum(filename string, title string) {
+	if sums == nil {
+		return
+	}
+	var err error
+	if sums.Root == "" {
+		err = sums.AddAs(title, filename)
+	} else {
+		err = sums.Add(filename)
+	}
+	if err != nil {
+		fmt.Printf("An error occurred hashing %s: %v\n", filename, err)
+	}
+}
 
-	inputPath = flag.String("i", "./index.html", "input file path")
-	outputFile = flag.String("o", "", "output filename")
-	folderName = flag.String("f", "./user1", "folder name")
-	flag.Parse()
+// signUpload uploads a -sign-key signature of the local file next to its
+// Drive copy name.
+func signUpload(d *drive.Service, filename string, name string, parent string) error {
+	if *signKey == "" {
+		return nil
+	}
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	sig, err := magic.UploadSignature(context.Background(), d, *signKey, in, name, parent)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed '%s'. ID : %s\n", name, sig.Id)
+	return nil
+}
 
-	// fmt.Println("input: %s", *inputPath)
-	// fmt.Println("output: %s", *outputFile)
-	// fmt.Println("folder: %s", *folderName)
+// uploadSums writes the -checksum-manifest of this run into the Drive
+// folder parent.
+func uploadSums(d *drive.Service, parent string) (*drive.File, error) {
+	if sums.Len() == 0 {
+		return nil, nil
+	}
+	sums.Pin = *immutable != ""
+	m, err := sums.Upload(context.Background(), d, parent, *sumsName)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Wrote %s for %d files. ID : %s\n", *sumsName, sums.Len(), m.Id)
+	if *signKey != "" {
+		sig, err := magic.UploadSignature(context.Background(), d, *signKey, bytes.NewReader(sums.Bytes()), *sumsName, parent)
+		if err != nil {
+			return m, err
+		}
+		fmt.Printf("Signed '%s'. ID : %s\n", *sumsName, sig.Id)
+	}
+	return m, nil
+}
 
-	ctx := context.Background()
+// uploadLink stores a preserved symlink as an empty file carrying its
+// target, so downloads can recreate the link.
+func uploadLink(d *drive.Service, title string, parentId string, filename string, target string) (*drive.File, error) {
+	link, err := magic.LinkProperties(target)
+	if err != nil {
+		return nil, fmt.Errorf("unable to preserve %s: %v", filename, err)
+	}
+	f := &drive.File{Name: magic.Names.Encrypt(title), MimeType: "application/octet-stream", AppProperties: link}
+	if parentId != "" {
+		f.Parents = []string{parentId}
+	}
+	if *posix {
+		props, err := magic.PosixProperties(filename)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range link {
+			props[k] = v
+		}
+		f.AppProperties = props
+	}
+	r, err := d.Files.Create(f).Fields("id, name").Do()
+	if err != nil {
+		fmt.Printf("An error occurred: %v\n", err)
+		return nil, err
+	}
+	fmt.Printf("Linked '%s' -> %s. ID : %s\n", r.Name, target, r.Id)
+	magic.Audit(magic.AuditEntry{
+		Actor:  currentUser(),
+		Op:     "upload",
+		FileID: r.Id,
+		Name:   r.Name,
+		Params: map[string]string{"source": filename, "folder": parentId, "link": target},
+	})
+	return r, nil
+}
+
+// uploadTree mirrors the local directory root as the folder title inside
+// parentId, applying the -links policy to symlinks met on the way.
+func uploadTree(d *drive.Service, root string, title string, parentId string) error {
+	root = filepath.Clean(root)
+	files, err := magic.WalkWith(root, magic.WalkOptions{
+		Links:     *links,
+		Ignore:    *ignore,
+		Filter:    filter,
+		Accept:    magic.PluginFilter(extPlugins),
+		MaxDepth:  *maxDepth,
+		MaxFiles:  *maxFiles,
+		LimitWarn: *limitWarn,
+		Workers:   *scanners,
+		Progress: func(files, dirs int) {
+			fmt.Printf("scanning: %d files, %d dirs\r", files, dirs)
+		},
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	// local directory -> Drive folder id
+	folders := map[string]string{root: getOrCreateFolder(d, magic.Names.Encrypt(title), parentId)}
+	var jobs []magic.UploadJob
+	var packer *magic.Packer
+	var packed []int // of files, counted once the packs are uploaded
+	if packSize > 0 {
+		packer = magic.NewPacker(client, folders[root], packSize)
+	}
+	for i, fi := range files {
+		if fi.Path == root {
+			continue
+		}
+		parent := folders[filepath.Dir(fi.Path)]
+		switch {
+		case !fi.IsDir && !destConfig.Allows(mimeTypeOf(fi.Path)):
+			fmt.Printf("Skipped %s: type %s is not allowed in the destination\n", fi.Path, mimeTypeOf(fi.Path))
+		case packer != nil && !fi.IsDir && fi.Link == "" && fi.Size < packBelow && !converts(fi.Path) && len(recipients) == 0 && !routed(fi.Path):
+			rel, err := filepath.Rel(root, fi.Path)
+			if err != nil {
+				return err
+			}
+			if err = packer.Add(rel, fi.Path); err != nil {
+				return err
+			}
+			packed = append(packed, i)
+		case fi.IsDir:
+			folders[fi.Path] = getOrCreateFolder(d, magic.Names.Encrypt(fi.Name), parent)
+		case fi.Link != "":
+			if _, err := uploadLink(d, fi.Name, parent, fi.Path, fi.Link); err != nil {
+				return err
+			}
+		case *sparse, len(recipients) > 0:
+			// packed and encrypted content is only known while reading, so
+			// no pipelining
+			if _, err := uploadFile(d, fi.Name, "", parent, mimeTypeOf(fi.Path), fi.Path); err != nil {
+				return err
+			}
+		default:
+			f, err := fileMeta(fi.Name, "", parent, mimeTypeOf(fi.Path), fi.Path)
+			if err != nil {
+				return err
+			}
+			source := convertMeta(f, fi.Path)
+			job := magic.UploadJob{Path: fi.Path, File: f, Size: fi.Size, ContentType: source}
+			if *atomic {
+				job.PublishAs, f.Name = f.Name, magic.StagingName(f.Name)
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	if err := queue.Push(jobs...); err != nil {
+		return err
+	}
+	if err := runQueue(d); err != nil {
+		return err
+	}
+
+	if packer != nil {
+		m, err := packer.Close()
+		if err != nil {
+			return err
+		}
+		for _, i := range packed {
+			run.Uploaded(files[i].Size)
+			addSum(files[i].Path, files[i].Name)
+		}
+		fmt.Printf("Packed small files, manifest ID : %s\n", m.Id)
+		magic.Audit(magic.AuditEntry{
+			Actor:  currentUser(),
+			Op:     "upload",
+			FileID: m.Id,
+			Name:   m.Name,
+			Params: map[string]string{"source": root, "folder": folders[root], "packed": "true"},
+		})
+	}
+	if _, err := uploadSums(d, folders[root]); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runQueue uploads everything in the transfer queue, including jobs left
+// over by an interrupted earlier run. It stops when a Drive quota runs
+// out, leaving the rest queued, or waits for it with -quota-resume.
+func runQueue(d *drive.Service) error {
+	var mu sync.Mutex
+	var failed error
+	done := func(r magic.UploadResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Err != nil {
+			fmt.Printf("An error occurred uploading %s: %v\n", r.Job.Path, r.Err)
+			failed = r.Err
+			run.Failed()
+			return
+		}
+		algo := *checksum
+		if algo == "" && r.Job.PublishAs != "" {
+			algo = magic.ChecksumMD5
+		}
+		if algo != "" && r.Job.ContentType == "" {
+			if err := magic.Verify(r.Job.Path, r.File, algo); err != nil {
+				fmt.Printf("An error occurred uploading %s: %v\n", r.Job.Path, err)
+				failed = err
+				run.Failed()
+				if r.Job.PublishAs != "" {
+					if derr := magic.Discard(context.Background(), d, r.File); derr != nil {
+						fmt.Printf("An error occurred removing %s: %v\n", r.File.Name, derr)
+					}
+				}
+				return
+			}
+		}
+		run.Uploaded(r.File.Size)
+		fmt.Printf("Uploaded '%s', %s. ID : %s\n", r.Job.Path, FileSizeFormat(r.File.Size, false), r.File.Id)
+		if err := magic.ApplyLabels(context.Background(), d, r.File.Id, labelsFor(r.Job.Path)); err != nil {
+			fmt.Printf("An error occurred labeling %s: %v\n", r.Job.Path, err)
+			failed = err
+		}
+		if r.Job.PublishAs != "" {
+			f, err := magic.Publish(context.Background(), d, r.File, r.Job.PublishAs)
+			if err != nil {
+				fmt.Printf("An error occurred publishing %s: %v\n", r.Job.Path, err)
+				failed = err
+				run.Failed()
+				return
+			}
+			r.File = f
+		}
+		if r.Job.ContentType == "" {
+			addSum(r.Job.Path, r.File.Name)
+			var parent string
+			if len(r.Job.File.Parents) > 0 {
+				parent = r.Job.File.Parents[0]
+			}
+			if err := signUpload(d, r.Job.Path, r.File.Name, parent); err != nil {
+				fmt.Printf("An error occurred signing %s: %v\n", r.Job.Path, err)
+				failed = err
+			}
+		}
+		magic.Audit(magic.AuditEntry{
+			Actor:  currentUser(),
+			Op:     "upload",
+			FileID: r.File.Id,
+			Name:   r.File.Name,
+			Params: map[string]string{"source": r.Job.Path, "folder": strings.Join(r.Job.File.Parents, ",")},
+		})
+	}
+	for {
+		err := queue.Run(client, *transfers, done)
+		if err == nil {
+			return failed
+		}
+		left := queue.Pending()
+		fmt.Printf("Stopped: %v; %d uploads left queued\n", err, len(left))
+		if !*quotaResume {
+			fmt.Println("Run resume once the quota allows, or pass -quota-resume to wait for it")
+			return err
+		}
+		var need int64
+		for _, job := range left {
+			need += job.Size
+		}
+		if magic.StorageFull(err) {
+			fmt.Printf("Waiting for %s of free space on Drive\n", FileSizeFormat(need, false))
+		} else {
+			fmt.Printf("Waiting for the daily quota to reset at %s\n", magic.QuotaReset(time.Now()).Local().Format("2006-01-02 15:04"))
+		}
+		if err := magic.WaitQuota(context.Background(), d, err, need, 10*time.Minute); err != nil {
+			return err
+		}
+	}
+}
 
-	//get google client secret
-	b, err := ioutil.ReadFile("client_secret.json")
+// unpack restores packed files from the manifest id into the directory
+// dest, either all of them or only the one at path.
+func unpack(d *drive.Service, id string, path string, dest string) error {
+	m, err := magic.ReadPackManifest(d, id)
 	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+		return err
+	}
+	found := false
+	for _, e := range m.Entries {
+		if path != "" && e.Path != filepath.ToSlash(path) {
+			continue
+		}
+		found = true
+		target := filepath.Join(dest, filepath.FromSlash(e.Path))
+		if err := magic.Unpack(d, e, target); err != nil {
+			return err
+		}
+		run.Downloaded(e.Size)
+		fmt.Printf("Unpacked %s\n", target)
+	}
+	if !found {
+		return fmt.Errorf("%s is not in the pack manifest", path)
+	}
+	return nil
+}
+
+// offline commands run without Drive credentials and get a nil service.
+var offline = map[string]bool{"stats": true, "doctor": true, "auth": true, "keygen": true, "crypt": true, "replay": true, "plugins": true}
+
+// commandScopes are the scopes a command needs beyond Drive's.
+var commandScopes = map[string][]string{
+	"activity":     {magic.ActivityScope},
+	"labels":       {magic.LabelsScope},
+	"append-sheet": {magic.SheetsScope},
+	"photos":       {magic.PhotosScope},
+}
+
+// scopes returns the scopes to authorize for this run: drive.file unless
+// -full-access is set, plus whatever the command and flags need.
+func scopes() []string {
+	s := []string{drive.DriveFileScope}
+	if *fullAccess {
+		s[0] = drive.DriveScope
+	}
+	s = append(s, commandScopes[flag.Arg(0)]...)
+	if len(labelFlags) > 0 && flag.Arg(0) != "labels" {
+		s = append(s, magic.LabelsScope)
+	}
+	return s
+}
+
+// scopeHint explains a not found error under drive.file, which hides
+// every file the tool did not create, and refused token refreshes.
+func scopeHint(err error) error {
+	err = magic.ExplainAuthError(err)
+	if errors.Is(err, magic.ErrNotFound) && !*fullAccess {
+		return fmt.Errorf("%v (only files created by this tool are visible; use -full-access for others)", err)
 	}
+	return err
+}
+
+// commands are run as "test-a [flags] <command> args...".
+var commands = map[string]func(ctx context.Context, d *drive.Service, args []string) error{
+	"check":        checkCommand,
+	"diff":         diffCommand,
+	"sync":         syncCommand,
+	"stats":        statsCommand,
+	"doctor":       doctorCommand,
+	"activity":     activityCommand,
+	"comments":     commentsCommand,
+	"labels":       labelsCommand,
+	"ls":           lsCommand,
+	"link":         linkCommand,
+	"export-all":   exportAllCommand,
+	"append-sheet": appendSheetCommand,
+	"photos":       photosCommand,
+	"auth":         authCommand,
+	"publish":      publishCommand,
+	"dedupe-names": dedupeNamesCommand,
+	"keygen":       keygenCommand,
+	"rekey":        rekeyCommand,
+	"crypt":        cryptCommand,
+	"bundle":       bundleCommand,
+	"gc":           gcCommand,
+	"du":           duCommand,
+	"count":        countCommand,
+	"biggest":      biggestCommand,
+	"cleanup":      cleanupCommand,
+	"orphans":      orphansCommand,
+	"prune-empty":  pruneEmptyCommand,
+	"stat":         statCommand,
+	"resolve":      resolveCommand,
+	"path":         pathCommand,
+	"pick":         pickCommand,
+	"open":         openCommand,
+	"replay":       replayCommand,
+	"plugins":      pluginsCommand,
+	"resume": func(ctx context.Context, d *drive.Service, args []string) error {
+		fmt.Printf("Resuming %d queued uploads\n", len(queue.Pending()))
+		return runQueue(d)
+	},
+}
 
-	config, err := google.ConfigFromJSON(b, drive.DriveScope)
+// checkCommand compares a Drive folder with a local directory and prints
+// the differences as JSON, failing if there are any.
+func checkCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: check <driveFolderId> <localDir>")
+	}
+	algo := *checksum
+	if algo == "" {
+		algo = magic.ChecksumMD5
+	}
+	r, err := magic.Check(ctx, d, args[0], args[1], algo)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return err
+	}
+	fmt.Println(string(b))
+	if !r.OK() {
+		return fmt.Errorf("%d missing, %d extra, %d size and %d checksum mismatches",
+			len(r.Missing), len(r.Extra), len(r.Size), len(r.Content))
 	}
-	client := getClient(ctx, config)
+	return nil
+}
 
-	srv, err := drive.New(client)
+// diffCommand compares two Drive folders, given as remote:<id> or bare
+// IDs, and prints the differences as JSON, failing if there are any.
+func diffCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: diff remote:<folderId> remote:<folderId>")
+	}
+	a := strings.TrimPrefix(args[0], "remote:")
+	b := strings.TrimPrefix(args[1], "remote:")
+	r, err := magic.Diff(ctx, d, a, d, b)
 	if err != nil {
-		log.Fatalf("Unable to retrieve drive Client %v", err)
+		return err
+	}
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	if !r.OK() {
+		return fmt.Errorf("%d only in %s, %d only in %s, %d differ", len(r.OnlyA), a, len(r.OnlyB), b, len(r.Differ))
 	}
+	return nil
+}
 
-	fmt.Printf("Read file: %s\n", *inputPath)
-	outputTitle := *outputFile
-	if outputTitle == "" {
-		outputTitle = filepath.Base(*inputPath)
+// syncCommand syncs a Drive folder with a local directory both ways.
+func syncCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	trashDays := fs.Int("trash-days", 0, "move files deleted on Drive into the folder's "+magic.SyncTrashName+" and purge them after this many days, instead of trashing them")
+	maxDelete := fs.Int("max-delete", 0, "abort before deleting more than this many files on either side (0 is unlimited)")
+	maxPercent := fs.Float64("max-delete-percent", 50, "abort before deleting more than this percentage of the files on either side (0 is unlimited)")
+	force := fs.Bool("force", false, "ignore -max-delete and -max-delete-percent")
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
-	fmt.Printf("Output name: %s\n", outputTitle)
+	args = fs.Args()
+	if len(args) != 2 {
+		return fmt.Errorf("usage: sync [-trash-days n] [-max-delete n] [-max-delete-percent x] [-force] <driveFolderId> <localDir>")
+	}
+	if magic.Names != nil {
+		return fmt.Errorf("sync keeps names as they are; run it without -encrypt-names")
+	}
+	opt := magic.SyncOptions{
+		Resolve: askConflict,
+		Log: func(op, path string) {
+			fmt.Printf("%s: %s\n", op, path)
+		},
+		TrashDays: *trashDays,
+		Immutable: *immutable,
+		Walk: magic.WalkOptions{
+			Filter:    filter,
+			Accept:    magic.PluginFilter(extPlugins),
+			MaxDepth:  *maxDepth,
+			MaxFiles:  *maxFiles,
+			LimitWarn: *limitWarn,
+			Workers:   *scanners,
+		},
+		Download:  downloads,
+		Bandwidth: queue.Bandwidth,
+		Checksum:  *checksum,
+	}
+	if !*force {
+		opt.MaxDelete, opt.MaxDeletePercent = *maxDelete, *maxPercent
+	}
+	folder, err := magic.LoadFolderConfig(ctx, d, args[0])
+	if err != nil {
+		return err
+	}
+	opt.Folder = folder
+	if *conflicts == "" && folder.Conflict != "" {
+		*conflicts = string(folder.Conflict)
+		fmt.Printf("Conflicts are settled as %s by the folder's %s\n", folder.Conflict, magic.FolderConfigName)
+	}
+	if *conflicts != "" {
+		r, err := magic.ParseResolution(*conflicts)
+		if err != nil {
+			return err
+		}
+		opt.Resolve = func(c magic.Conflict) magic.Resolution {
+			fmt.Printf("conflict: %s, keeping %s\n", c.Path, r)
+			return r
+		}
+	}
+	if len(rules) > 0 {
+		resolve := opt.Resolve
+		opt.Resolve = func(c magic.Conflict) magic.Resolution {
+			rf := magic.RuleFile{Path: c.Path, Size: c.LocalSize, ModTime: c.LocalTime, Host: hostname}
+			if r := rules.Match(rf); r != nil && r.Conflict != "" {
+				fmt.Printf("conflict: %s, keeping %s by rule\n", c.Path, r.Conflict)
+				return r.Conflict
+			}
+			return resolve(c)
+		}
+	}
+	res, err := magic.Sync(ctx, d, args[0], args[1], opt)
+	if _, ok := err.(*magic.DeleteLimitError); ok {
+		return fmt.Errorf("%v; no file was changed, check that %s is the right directory or use -force", err, args[1])
+	}
+	if res != nil {
+		fmt.Printf("%d uploaded, %d downloaded, %d deleted, %d conflicts (%d skipped)\n",
+			len(res.Uploaded), len(res.Downloaded), len(res.Deleted), len(res.Conflicts), len(res.Skipped))
+	}
+	return err
+}
 
-	ext := filepath.Ext(*inputPath)
-	mimeType := "application/octet-stream"
-	if ext != "" {
-		mimeType = mime.TypeByExtension(ext)
+var stdin = bufio.NewReader(os.Stdin)
+
+// askConflict shows both versions of a conflicting file and asks which
+// one to keep.
+func askConflict(c magic.Conflict) magic.Resolution {
+	side := func(exists bool, size int64, t time.Time) string {
+		if !exists {
+			return "deleted"
+		}
+		return fmt.Sprintf("%s, modified %s", FileSizeFormat(size, false), t.Local().Format("2006-01-02 15:04:05"))
 	}
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	fmt.Printf("\nConflict: %s\n", c.Path)
+	fmt.Printf("  local:  %s\n", side(c.LocalExists, c.LocalSize, c.LocalTime))
+	fmt.Printf("  remote: %s\n", side(c.RemoteExists, c.RemoteSize, c.RemoteTime))
+	for {
+		fmt.Print("Keep [l]ocal, [r]emote, [b]oth or [s]kip? ")
+		line, err := stdin.ReadString('\n')
+		if err != nil {
+			return magic.KeepSkip
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "l", "local":
+			return magic.KeepLocal
+		case "r", "remote":
+			return magic.KeepRemote
+		case "b", "both":
+			return magic.KeepBoth
+		case "s", "skip":
+			return magic.KeepSkip
+		}
+	}
+}
+
+// saveRun records this run's transfer counts for the stats command.
+func saveRun() {
+	if err := run.Save(); err != nil {
+		log.Printf("Unable to save run statistics: %v", err)
 	}
-	fmt.Printf("Mime : %s\n", mimeType)
+}
+
+// fail ends a run that failed, keeping its statistics.
+func fail(format string, args ...interface{}) {
+	run.Failed()
+	saveRun()
+	magic.CloseAudit()
+	log.Fatalf(format, args...)
+}
 
-	uploadFile(srv, outputTitle, "", *folderName, mimeType, *inputPath)
+// statsCommand prints transfer totals per day, or per week with "week",
+// over the last n periods (default 14).
+func statsCommand(ctx context.Context, d *drive.Service, args []string) error {
+	weekly := len(args) > 0 && args[0] == "week"
+	if len(args) > 0 && args[0] != "week" && args[0] != "day" {
+		return fmt.Errorf("usage: stats [day|week] [periods]")
+	}
+	n := 14
+	if len(args) > 1 {
+		var err error
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 1 {
+			return fmt.Errorf("invalid period count %q", args[1])
+		}
+	}
+	since := time.Now().AddDate(0, 0, -n)
+	if weekly {
+		since = time.Now().AddDate(0, 0, -7*n)
+	}
+	runs, err := magic.LoadRuns(since)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-10s %5s %10s %10s %7s %8s %6s %12s\n", "period", "runs", "up", "down", "files", "failures", "stalls", "throughput")
+	for _, p := range magic.Totals(runs, weekly) {
+		fmt.Printf("%-10s %5d %10s %10s %7d %8d %6d %10s/s\n", p.Start.Format("2006-01-02"), p.Runs,
+			FileSizeFormat(p.BytesUp, false), FileSizeFormat(p.BytesDown, false), p.Files, p.Failures, p.Stalls,
+			FileSizeFormat(int64(p.Throughput()), false))
+	}
+	return nil
+}
+
+// doctorCommand diagnoses the setup, optionally including write access
+// to a Drive folder, and fails if any check does.
+func doctorCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: doctor [driveFolderId]")
+	}
+	folder := ""
+	if len(args) == 1 {
+		folder = args[0]
+	}
+	failed := 0
+	for _, r := range magic.Doctor(ctx, folder, ".") {
+		if r.OK {
+			fmt.Printf("ok    %-40s %s\n", r.Check, r.Detail)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %-40s %s\n      fix: %s\n", r.Check, r.Detail, r.Fix)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
+
+// authCommand prints what the cached credentials are, or revokes them.
+func authCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) != 1 || args[0] != "status" && args[0] != "revoke" {
+		return fmt.Errorf("usage: auth status|revoke")
+	}
+	if args[0] == "revoke" {
+		if err := magic.RevokeToken(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Token revoked and %s removed\n", magic.TokenFile)
+		return nil
+	}
+	info, err := magic.AuthStatus(ctx)
+	fmt.Printf("location:  %s\n", info.Location)
+	if info.Federated {
+		fmt.Println("type:      workload identity federation")
+	} else if info.Scopes != nil {
+		fmt.Printf("encrypted: %v\n", info.Encrypted)
+		fmt.Printf("scopes:    %s\n", strings.Join(info.Scopes, " "))
+		fmt.Printf("expiry:    %s (refresh token: %v)\n", info.Expiry.Local().Format("2006-01-02 15:04:05"), info.Refresh)
+	}
+	if info.Account != "" {
+		fmt.Printf("account:   %s\n", info.Account)
+	}
+	return err
+}
+
+// activityCommand prints who did what to a file, or to anything inside a
+// folder, newest first.
+func activityCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: activity <fileOrFolderId> [limit]")
+	}
+	limit := 50
+	if len(args) == 2 {
+		var err error
+		if limit, err = strconv.Atoi(args[1]); err != nil {
+			return fmt.Errorf("invalid limit %q", args[1])
+		}
+	}
+	f, err := d.Files.Get(args[0]).Fields("id, name, mimeType").Do()
+	if err != nil {
+		return err
+	}
+	list, err := magic.ItemActivity(ctx, client, f.Id, f.MimeType == magic.FolderMime, limit)
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		fmt.Printf("%s  %-8s %-30s %s", a.Time.Local().Format("2006-01-02 15:04:05"), a.Action, a.Target, strings.Join(a.Actors, ", "))
+		if a.Detail != "" {
+			fmt.Printf("  (%s)", a.Detail)
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// commentsCommand lists a file's comments, exports them as JSON or
+// Markdown, or resolves one or all of them.
+func commentsCommand(ctx context.Context, d *drive.Service, args []string) error {
+	usage := fmt.Errorf("usage: comments <fileId> [list|json|markdown|resolve <commentId>|resolve-all]")
+	if len(args) < 1 {
+		return usage
+	}
+	id, action := args[0], "list"
+	if len(args) > 1 {
+		action = args[1]
+	}
+	list, err := magic.Comments(ctx, d, id)
+	if err != nil {
+		return err
+	}
+	switch {
+	case action == "list" && len(args) <= 2:
+		for _, c := range list {
+			state := "open"
+			if c.Resolved {
+				state = "resolved"
+			}
+			author := "unknown"
+			if c.Author != nil {
+				author = c.Author.DisplayName
+			}
+			fmt.Printf("%s  %-8s %s: %s (%d replies)\n", c.Id, state, author, c.Content, len(c.Replies))
+		}
+	case action == "json" && len(args) == 2:
+		b, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	case action == "markdown" && len(args) == 2:
+		f, err := d.Files.Get(id).Fields("name").Do()
+		if err != nil {
+			return err
+		}
+		return magic.WriteCommentsMarkdown(os.Stdout, f.Name, list)
+	case action == "resolve" && len(args) == 3:
+		if err := magic.ResolveComment(ctx, d, id, args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("Resolved %s\n", args[2])
+	case action == "resolve-all" && len(args) == 2:
+		for _, c := range list {
+			if c.Resolved {
+				continue
+			}
+			if err := magic.ResolveComment(ctx, d, id, c.Id); err != nil {
+				return err
+			}
+			fmt.Printf("Resolved %s\n", c.Id)
+		}
+	default:
+		return usage
+	}
+	return nil
+}
+
+// labelsCommand lists the labels that can be applied, shows those on a
+// file, or finds the files carrying a label value.
+func labelsCommand(ctx context.Context, d *drive.Service, args []string) error {
+	catalog, err := magic.LoadLabels(ctx, client)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(args) == 2 && args[0] == "get":
+		list, err := catalog.FileLabels(ctx, d, args[1])
+		if err != nil {
+			return err
+		}
+		for _, l := range list {
+			fmt.Println(l)
+		}
+	case len(args) >= 2 && args[0] == "find":
+		fs := flag.NewFlagSet("labels find", flag.ContinueOnError)
+		format := fs.String("format", "", formatUsage)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: labels find [-format f] <Label[.Field]=Value>")
+		}
+		out, err := newListing(*format)
+		if err != nil {
+			return err
+		}
+		spec, err := catalog.Resolve(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		q, err := spec.Query()
+		if err != nil {
+			return err
+		}
+		it := magic.ListIter(ctx, d, q+" and trashed=false")
+		listCall(it.Call())
+		for it.Next() {
+			if err := out.Write(it.File()); err != nil {
+				return err
+			}
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		return it.Err()
+	default:
+		return fmt.Errorf("usage: labels get <fileId> | labels find [-format f] <Label[.Field]=Value>")
+	}
+	return nil
+}
+
+// fileWriter is the output of a listing: a -format, or else a table of
+// -columns.
+type fileWriter interface {
+	Write(f *drive.File) error
+	Flush() error
+}
+
+func newListing(format string) (fileWriter, error) {
+	out, err := magic.NewFileFormat(os.Stdout, format)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		return out, nil
+	}
+	return magic.NewTable(os.Stdout, listColumns)
+}
+
+// listCall has a listing fetch what the columns show, sorted by -sort.
+func listCall(call *drive.FilesListCall) {
+	call.Fields(googleapi.Field("nextPageToken, files(" + magic.ColumnFields + ")"))
+	if listOrder != "" {
+		call.OrderBy(listOrder)
+	}
+}
+
+// formatUsage describes the -format of listing commands.
+const formatUsage = "print csv, tsv or a Go template over each file, like '{{.Id}}\\t{{.Name}}', instead of text"
+
+// lsCommand lists files of a scope (my-drive, starred, shared-with-me,
+// recent or trashed), or the contents of a folder, applying the size and
+// age filters.
+func lsCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	scope := fs.String("scope", "", "my-drive (the default without a folder), starred, shared-with-me, recent or trashed")
+	limit := fs.Int("n", 100, "list at most this many files (0 for all)")
+	format := fs.String("format", "", formatUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	out, err := newListing(*format)
+	if err != nil {
+		return err
+	}
+	extra := filter.Query()
+	if fs.NArg() == 1 {
+		in := "'" + magic.QueryString(fs.Arg(0)) + "' in parents"
+		if extra != "" {
+			in += " and " + extra
+		}
+		extra = in
+	} else if fs.NArg() > 1 {
+		return fmt.Errorf("usage: ls [-scope name] [-n limit] [-format f] [folderId]")
+	} else if *scope == "" {
+		*scope = magic.ScopeMyDrive
+	}
+	it, err := magic.ListScope(ctx, d, *scope, extra)
+	if err != nil {
+		return err
+	}
+	listCall(it.Call())
+	n := 0
+	for it.Next() && (*limit == 0 || n < *limit) {
+		f := it.File()
+		mtime, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+		if f.MimeType != magic.FolderMime && !filter.Match(f.Size, mtime) {
+			continue
+		}
+		n++
+		if err := out.Write(f); err != nil {
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	return it.Err()
+}
+
+// linkCommand prints a file's links, optionally sharing it first with
+// anyone who has the link or with one account, until -expire.
+func linkCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("link", flag.ContinueOnError)
+	share := fs.Bool("share", false, "turn on anyone-with-link access")
+	email := fs.String("email", "", "share with this account instead of anyone")
+	role := fs.String("role", "reader", "access granted: reader, commenter or writer (with -email)")
+	expire := fs.String("expire", "", "remove the access after this long, e.g. 7d")
+	sweep := fs.Bool("revoke-expired", false, "revoke expired anyone-with-link access on all files and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *sweep {
+		ids, err := magic.RevokeExpiredLinks(ctx, d)
+		for _, id := range ids {
+			fmt.Printf("Revoked link access to %s\n", id)
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: link [-share|-email addr] [-role r] [-expire d] <fileId> | link -revoke-expired")
+	}
+	id := fs.Arg(0)
+	var expires time.Time
+	if *expire != "" {
+		age, err := magic.ParseAge(*expire)
+		if err != nil {
+			return err
+		}
+		expires = time.Now().Add(age)
+	}
+	switch {
+	case *email != "":
+		if err := magic.ShareWith(ctx, d, id, *email, *role, expires); err != nil {
+			return err
+		}
+	case *share:
+		if err := magic.ShareLink(ctx, d, id, *role, expires); err != nil {
+			return err
+		}
+		if !expires.IsZero() {
+			fmt.Printf("Link access expires %s; run \"link -revoke-expired\" periodically to enforce it\n", expires.Local().Format("2006-01-02 15:04"))
+		}
+	case *expire != "":
+		return fmt.Errorf("-expire needs -share or -email")
+	}
+	if *share || *email != "" {
+		magic.Audit(magic.AuditEntry{
+			Actor:  currentUser(),
+			Op:     "share",
+			FileID: id,
+			Params: map[string]string{"email": *email, "role": *role, "expire": *expire},
+		})
+	}
+	f, err := magic.Links(ctx, d, id)
+	if err != nil {
+		return err
+	}
+	if *copyWhat != "" {
+		copyOut(ctx, d, id)
+	}
+	fmt.Printf("view:     %s\n", f.WebViewLink)
+	if f.WebContentLink != "" {
+		fmt.Printf("download: %s\n", f.WebContentLink)
+	}
+	return nil
+}
+
+// exportAllCommand exports the native Google documents of a folder tree
+// to local files.
+func exportAllCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("export-all", flag.ContinueOnError)
+	format := fs.String("format", "pdf", "pdf, docx, xlsx, pptx, odt, ods, odp, txt, csv, png or svg")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: export-all [-format pdf] <driveFolderId> <localDir>")
+	}
+	var failed error
+	err := magic.ExportAll(ctx, client, d, fs.Arg(0), fs.Arg(1), *format, func(path string, err error) {
+		switch {
+		case err == magic.ErrNoExport:
+			fmt.Printf("Skipped %s: %v\n", path, err)
+		case err != nil:
+			fmt.Printf("An error occurred exporting %s: %v\n", path, err)
+			run.Failed()
+			failed = err
+		default:
+			if info, err := os.Stat(path); err == nil {
+				run.Downloaded(info.Size())
+			}
+			fmt.Printf("Exported %s\n", path)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return failed
+}
+
+// dedupeNamesCommand makes the names within a folder tree unique, renaming
+// or merging the entries Drive lets share a name.
+func dedupeNamesCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("dedupe-names", flag.ContinueOnError)
+	merge := fs.Bool("merge", false, "merge duplicate folders and trash identical files instead of renaming")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: dedupe-names [-merge] [-dry-run] <driveFolderId>")
+	}
+	n, err := magic.DedupeNames(ctx, d, fs.Arg(0), magic.DedupeOptions{
+		Merge:  *merge,
+		DryRun: *dryRun,
+		Log:    func(op, p string) { fmt.Printf("%-6s %s\n", op, p) },
+	})
+	if err != nil {
+		return scopeHint(err)
+	}
+	if n == 0 {
+		fmt.Println("No duplicate names")
+	}
+	return nil
+}
+
+// keygenCommand prints a new identity for -encrypt-to and -identity, and
+// the recipient to share.
+func keygenCommand(ctx context.Context, d *drive.Service, args []string) error {
+	id, err := magic.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("# recipient: %s\n%s\n", id.Recipient(), id)
+	return nil
+}
+
+// rekeyCommand wraps the file keys of encrypted uploads to new recipients
+// without uploading them again. Only the keys in appProperties change,
+// which downloads use over those in the content's header.
+func rekeyCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	var to listFlag
+	fs.Var(&to, "to", "recipient, or file of recipients, the files are encrypted to from now on; repeatable")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || len(to) == 0 || len(downloads.Identities) == 0 {
+		return fmt.Errorf("usage: -identity <file> rekey -to <recipient>... <driveFileOrFolderId>")
+	}
+	rs, err := magic.ParseRecipients(to)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	n, err := magic.Rekey(ctx, d, fs.Arg(0), downloads.Identities, rs, func(name string, err error) {
+		if err != nil {
+			failed++
+			fmt.Printf("Unable to rekey %s: %v\n", magic.Names.Display(name), err)
+			return
+		}
+		fmt.Printf("Rekeyed %s\n", magic.Names.Display(name))
+	})
+	if err != nil {
+		return scopeHint(err)
+	}
+	fmt.Printf("Rekeyed %d files to %d recipients\n", n, len(rs))
+	if n > 0 {
+		fmt.Println("Only the keys in Drive's metadata changed: the content still has the old ones in its header, so re-upload to revoke a recipient")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be rekeyed", failed)
+	}
+	return nil
+}
+
+// cryptCommand encrypts or decrypts a local file, or stdin given "-", in
+// the format of -encrypt-to uploads, for content moved by other means
+// than this tool. Decrypting uses the keys in the header, which a rekey
+// does not change.
+func cryptCommand(ctx context.Context, d *drive.Service, args []string) error {
+	const usage = "usage: crypt encrypt [-to <recipient>]... [-o <file>] <file>\n       -identity <file> crypt decrypt [-o <file>] <file>"
+	if len(args) == 0 || args[0] != "encrypt" && args[0] != "decrypt" {
+		return fmt.Errorf(usage)
+	}
+	fs := flag.NewFlagSet("crypt "+args[0], flag.ContinueOnError)
+	output := fs.String("o", "-", "write to this file instead of stdout")
+	var to listFlag
+	if args[0] == "encrypt" {
+		fs.Var(&to, "to", "recipient, or file of recipients, to encrypt to instead of those of -encrypt-to; repeatable")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf(usage)
+	}
+	var in io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if *output != "-" {
+		var err error
+		if file, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	var err error
+	if args[0] == "encrypt" {
+		rs := recipients
+		if len(to) > 0 {
+			if rs, err = magic.ParseRecipients(to); err != nil {
+				return err
+			}
+		}
+		var env *magic.Envelope
+		if env, err = magic.NewEnvelope(rs); err == nil {
+			err = env.Encrypt(out, in)
+		}
+	} else {
+		if len(downloads.Identities) == 0 {
+			return fmt.Errorf(usage)
+		}
+		err = magic.Decrypt(out, in, downloads.Identities, nil)
+	}
+	if err == nil && file != nil {
+		err = file.Close()
+	}
+	if err != nil && file != nil {
+		// a partial output would pass for a whole one
+		os.Remove(*output)
+	}
+	return err
+}
+
+// bundleCommand uploads local files as one password-protected zip and
+// prints a link to share it with anyone.
+func bundleCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ContinueOnError)
+	name := fs.String("name", "", "name of the bundle in Drive (default the first file's, with .zip)")
+	folder := fs.String("folder", "", "Drive folder ID to upload into instead of the root")
+	passwordFile := fs.String("password-file", "", "read the password from the first line of this file instead of generating one")
+	expire := fs.String("expire", "", "remove the link access after this long, e.g. 7d")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: bundle [-name n] [-folder id] [-password-file f] [-expire d] <path>...")
+	}
+	var expires time.Time
+	if *expire != "" {
+		age, err := magic.ParseAge(*expire)
+		if err != nil {
+			return err
+		}
+		expires = time.Now().Add(age)
+	}
+	var password []byte
+	if *passwordFile != "" {
+		var err error
+		if password, err = magic.ReadPassword(*passwordFile); err != nil {
+			return fmt.Errorf("unable to read password file: %v", err)
+		}
+	} else {
+		p, err := magic.GeneratePassword(20)
+		if err != nil {
+			return err
+		}
+		password = []byte(p)
+	}
+	if *name == "" {
+		base := filepath.Base(filepath.Clean(fs.Arg(0)))
+		*name = strings.TrimSuffix(base, filepath.Ext(base)) + ".zip"
+	}
+	f, err := magic.UploadBundle(ctx, d, fs.Args(), password, *name, *folder)
+	if err != nil {
+		return err
+	}
+	run.Uploaded(f.Size)
+	if err = magic.ShareLink(ctx, d, f.Id, "reader", expires); err != nil {
+		return err
+	}
+	magic.Audit(magic.AuditEntry{
+		Actor:  currentUser(),
+		Op:     "share",
+		FileID: f.Id,
+		Name:   f.Name,
+		Params: map[string]string{"bundle": strings.Join(fs.Args(), ","), "role": "reader", "expire": *expire},
+	})
+	l, err := magic.Links(ctx, d, f.Id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Uploaded '%s', %s. ID : %s\n", f.Name, FileSizeFormat(f.Size, false), f.Id)
+	fmt.Printf("link:     %s\n", l.WebViewLink)
+	if *passwordFile == "" {
+		fmt.Printf("password: %s\n", password)
+	}
+	if !expires.IsZero() {
+		fmt.Printf("Link access expires %s; run \"link -revoke-expired\" periodically to enforce it\n", expires.Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Println("Send the password by another channel than the link")
+	return nil
+}
+
+// gcCommand trashes the packs of -pack-small-files uploads that no
+// manifest references any more.
+func gcCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	grace := fs.String("grace", "1d", "mark unreferenced packs at least this old, and trash them on a later run once marked this long")
+	dryRun := fs.Bool("dry-run", false, "only print what would be changed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: gc [-grace d] [-dry-run] <driveFolderId>")
+	}
+	age, err := magic.ParseAge(*grace)
+	if err != nil {
+		return err
+	}
+	st, err := magic.GC(ctx, d, fs.Arg(0), magic.GCOptions{
+		Grace:  age,
+		DryRun: *dryRun,
+		Log: func(op string, f *drive.File) {
+			fmt.Printf("%-6s %s %s (%s)\n", op, f.Id, f.Name, FileSizeFormat(f.Size, false))
+		},
+	})
+	if err != nil {
+		return scopeHint(err)
+	}
+	fmt.Printf("%d packs: %d referenced, %d marked, %d trashed freeing %s\n",
+		st.Packs, st.Referenced, st.Marked, st.Trashed, FileSizeFormat(st.Freed, false))
+	if st.Trashed > 0 && !*dryRun {
+		magic.Audit(magic.AuditEntry{
+			Actor:  currentUser(),
+			Op:     "gc",
+			FileID: fs.Arg(0),
+			Params: map[string]string{"trashed": strconv.Itoa(st.Trashed)},
+		})
+	}
+	return nil
+}
+
+// duCommand prints the bytes and files below a Drive folder and its
+// subfolders down to -depth, with their share of the storage quota.
+func duCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("du", flag.ContinueOnError)
+	depth := fs.Int("depth", 1, "show subfolders down to this many levels (-1 for all)")
+	parallel := fs.Int("parallel", 8, "folders listed at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: du [-depth n] [-parallel n] <driveFolderId>")
+	}
+	u, err := magic.DiskUsage(ctx, d, fs.Arg(0), *parallel)
+	if err != nil {
+		return scopeHint(err)
+	}
+	var limit int64
+	if about, err := d.About.Get().Fields("storageQuota(limit)").Context(ctx).Do(); err == nil && about.StorageQuota != nil {
+		limit = about.StorageQuota.Limit
+	}
+	u.Path = fs.Arg(0)
+	u.Walk(*depth, func(u *magic.Usage, level int) {
+		share := "-"
+		if limit > 0 {
+			share = fmt.Sprintf("%.1f%%", float64(u.Bytes)*100/float64(limit))
+		}
+		fmt.Printf("%10s %8d files %7s  %s\n", FileSizeFormat(u.Bytes, false), u.Files, share, u.Path)
+	})
+	return nil
+}
+
+// countCommand prints how many files match a Drive query and their
+// total size, failing below -min so that monitoring can alert on it.
+func countCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("count", flag.ContinueOnError)
+	query := fs.String("query", "", "Drive search query, e.g. \"name contains 'backup'\"")
+	folder := fs.String("folder", "", "only count files directly in this folder")
+	atLeast := fs.Int("min", 0, "fail when fewer files match")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: count [-query q] [-folder id] [-min n]")
+	}
+	q := []string{"trashed=false"}
+	for _, c := range []string{*query, filter.Query()} {
+		if c != "" {
+			q = append(q, "("+c+")")
+		}
+	}
+	if *folder != "" {
+		q = append(q, "'"+magic.QueryString(*folder)+"' in parents")
+	}
+	n, bytes, err := magic.Count(ctx, d, strings.Join(q, " and "))
+	if err != nil {
+		return scopeHint(err)
+	}
+	fmt.Printf("%d files, %d bytes (%s)\n", n, bytes, FileSizeFormat(bytes, false))
+	if n < *atLeast {
+		return fmt.Errorf("%d files match, want at least %d", n, *atLeast)
+	}
+	return nil
+}
+
+// biggestCommand lists the largest files below a Drive folder, or of the
+// whole Drive, to show what uses up the storage quota.
+func biggestCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("biggest", flag.ContinueOnError)
+	top := fs.Int("top", 50, "list this many files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 || *top < 1 {
+		return fmt.Errorf("usage: biggest [-top n] [driveFolderId]")
+	}
+	list, err := magic.Biggest(ctx, d, fs.Arg(0), *top)
+	if err != nil {
+		return scopeHint(err)
+	}
+	for _, b := range list {
+		size := b.File.Size
+		if b.File.QuotaBytesUsed > size {
+			size = b.File.QuotaBytesUsed
+		}
+		fmt.Printf("%10s  %-33s  %s\n", FileSizeFormat(size, false), b.File.Id, b.Path)
+	}
+	return nil
+}
+
+// cleanupCommand trashes or deletes old files matching a query. It only
+// shows what it would remove unless given -apply.
+func cleanupCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	olderThan := fs.String("older-than", "", "only files unmodified this long, e.g. 90d")
+	query := fs.String("query", "", "only files also matching this Drive search, e.g. \"mimeType contains 'video'\"")
+	folder := fs.String("folder", "", "only files in the tree below this folder, instead of all you own")
+	del := fs.Bool("delete", false, "delete for good instead of moving to the trash")
+	apply := fs.Bool("apply", false, "remove the files; without it they are only listed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *olderThan == "" && *query == "" {
+		return fmt.Errorf("usage: cleanup -older-than d | -query q [-folder id] [-delete] [-apply]")
+	}
+	opt := magic.CleanupOptions{Query: *query, Folder: *folder, Delete: *del, DryRun: !*apply}
+	if *olderThan != "" {
+		age, err := magic.ParseAge(*olderThan)
+		if err != nil {
+			return err
+		}
+		opt.OlderThan = age
+	}
+	opt.Log = func(op string, f *drive.File) {
+		fmt.Printf("%-12s %s %s (%s)\n", op, f.Id, magic.Names.Display(f.Name), FileSizeFormat(f.Size, false))
+	}
+	st, err := magic.Cleanup(ctx, d, opt)
+	if st.Files > 0 && *apply {
+		magic.Audit(magic.AuditEntry{
+			Actor:  currentUser(),
+			Op:     "cleanup",
+			FileID: *folder,
+			Params: map[string]string{"older-than": *olderThan, "query": *query, "delete": strconv.FormatBool(*del), "files": strconv.Itoa(st.Files)},
+		})
+	}
+	if err != nil {
+		return scopeHint(err)
+	}
+	if !*apply {
+		fmt.Printf("%d files, %s would be removed; run again with -apply to remove them\n", st.Files, FileSizeFormat(st.Bytes, false))
+		return nil
+	}
+	fmt.Printf("%d files removed, freeing %s\n", st.Files, FileSizeFormat(st.Bytes, false))
+	return nil
+}
+
+// orphansCommand lists the files owned but reachable from no folder, and
+// moves them into a "Recovered" folder or deletes them.
+func orphansCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("orphans", flag.ContinueOnError)
+	move := fs.Bool("recover", false, "move them into the "+magic.RecoveredName+" folder of My Drive")
+	del := fs.Bool("delete", false, "delete them for good, orphaned folders with what they hold")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *move && *del {
+		return fmt.Errorf("usage: orphans [-recover | -delete]")
+	}
+	list, err := magic.Orphans(ctx, d)
+	if err != nil {
+		return scopeHint(err)
+	}
+	var bytes int64
+	for _, f := range list {
+		bytes += f.Size
+		fmt.Printf("%-33s %10s  %s\n", f.Id, FileSizeFormat(f.Size, false), magic.Names.Display(f.Name))
+	}
+	fmt.Printf("%d orphans, %s\n", len(list), FileSizeFormat(bytes, false))
+	switch {
+	case len(list) == 0:
+		return nil
+	case *move:
+		id, err := magic.Recover(ctx, d, list)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Moved into %s (%s)\n", magic.RecoveredName, id)
+	case *del:
+		for _, f := range list {
+			if err := d.Files.Delete(f.Id).Context(ctx).Do(); err != nil {
+				return fmt.Errorf("unable to delete %s: %v", f.Id, err)
+			}
+		}
+		fmt.Printf("Deleted, freeing %s\n", FileSizeFormat(bytes, false))
+	default:
+		return nil
+	}
+	op := "recover-orphans"
+	if *del {
+		op = "delete-orphans"
+	}
+	magic.Audit(magic.AuditEntry{
+		Actor:  currentUser(),
+		Op:     op,
+		Params: map[string]string{"files": strconv.Itoa(len(list))},
+	})
+	return nil
+}
+
+// pruneEmptyCommand trashes the empty folders below a Drive folder.
+func pruneEmptyCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("prune-empty", flag.ContinueOnError)
+	depth := fs.Int("depth", -1, "only look this many levels down (-1 for all)")
+	dryRun := fs.Bool("dry-run", false, "only print what would be trashed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: prune-empty [-depth n] [-dry-run] <driveFolderId>")
+	}
+	pruned, err := magic.PruneEmpty(ctx, d, fs.Arg(0), *depth, *dryRun)
+	op := "Trashed"
+	if *dryRun {
+		op = "Would trash"
+	}
+	for _, p := range pruned {
+		fmt.Printf("%s %s/\n", op, p)
+	}
+	if len(pruned) > 0 && !*dryRun {
+		magic.Audit(magic.AuditEntry{
+			Actor:  currentUser(),
+			Op:     "prune-empty",
+			FileID: fs.Arg(0),
+			Params: map[string]string{"folders": strconv.Itoa(len(pruned))},
+		})
+	}
+	return scopeHint(err)
+}
+
+// statCommand prints what Drive knows about one file.
+func statCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: stat [-json] <fileId>")
+	}
+	st, err := magic.Stat(ctx, d, fs.Arg(0))
+	if err != nil {
+		return scopeHint(err)
+	}
+	if *asJSON {
+		b, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	f := st.File
+	line := func(k, v string) {
+		if v != "" {
+			fmt.Printf("%-12s %s\n", k+":", v)
+		}
+	}
+	line("name", magic.Names.Display(f.Name))
+	line("id", f.Id)
+	line("type", f.MimeType)
+	if f.MimeType != magic.FolderMime && !magic.IsGoogleDoc(f) {
+		line("size", fmt.Sprintf("%s (%s bytes)", FileSizeFormat(f.Size, false), Comma(f.Size)))
+	}
+	if f.QuotaBytesUsed > 0 {
+		line("quota used", FileSizeFormat(f.QuotaBytesUsed, false))
+	}
+	line("md5", f.Md5Checksum)
+	line("sha1", f.Sha1Checksum)
+	line("sha256", f.Sha256Checksum)
+	line("created", f.CreatedTime)
+	line("modified", f.ModifiedTime)
+	line("version", strconv.FormatInt(f.Version, 10))
+	line("description", f.Description)
+	for _, o := range f.Owners {
+		line("owner", o.EmailAddress)
+	}
+	for _, p := range st.Paths {
+		line("path", p)
+	}
+	for _, p := range f.Permissions {
+		who := p.Type
+		switch {
+		case p.EmailAddress != "":
+			who = p.EmailAddress
+		case p.Domain != "":
+			who = p.Domain
+		}
+		access := who + " " + p.Role
+		if p.ExpirationTime != "" {
+			access += " until " + p.ExpirationTime
+		}
+		line("access", access)
+	}
+	if st.Revisions >= 0 {
+		line("revisions", strconv.Itoa(st.Revisions))
+	}
+	if f.Starred {
+		line("starred", "yes")
+	}
+	if f.Trashed {
+		line("trashed", "yes")
+	}
+	line("view", f.WebViewLink)
+	line("download", f.WebContentLink)
+	return nil
+}
+
+// resolveCommand prints the IDs of the files at a Drive path, all of them
+// when names are shared.
+func resolveCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: resolve <%s/path>", magic.DrivePrefix)
+	}
+	list, err := magic.ResolveAll(ctx, d, args[0])
+	if err != nil {
+		return err
+	}
+	for _, f := range list {
+		fmt.Println(f.Id)
+	}
+	return nil
+}
+
+// pathCommand prints every path of a Drive file, one per parent chain.
+func pathCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: path <fileId>")
+	}
+	paths, err := magic.DrivePaths(ctx, d, args[0])
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		fmt.Println(p)
+	}
+	return nil
+}
+
+// pickCommand lets the user search the remote files by fuzzy name while
+// they are still being listed, and prints the ID, or path, of the one
+// chosen. Prompts go to stderr, so that $(test-a pick) takes the choice.
+func pickCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("pick", flag.ContinueOnError)
+	asPath := fs.Bool("path", false, "print the "+magic.DrivePrefix+" path instead of the ID")
+	shown := fs.Int("n", 10, "matches shown at once")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: pick [-path] [-n shown] [query]")
+	}
+	var (
+		mu      sync.Mutex
+		files   []*drive.File
+		names   []string
+		listing = true
+		listErr error
+	)
+	go func() {
+		it := magic.ListIter(ctx, d, "trashed=false", "id, name, mimeType")
+		for it.Next() {
+			mu.Lock()
+			files = append(files, it.File())
+			names = append(names, magic.Names.Display(it.File().Name))
+			mu.Unlock()
+		}
+		mu.Lock()
+		listing, listErr = false, it.Err()
+		mu.Unlock()
+	}()
+	query := fs.Arg(0)
+	var matches []*drive.File
+	for {
+		mu.Lock()
+		if listErr != nil {
+			mu.Unlock()
+			return listErr
+		}
+		matches = matches[:0]
+		for _, i := range magic.FuzzyFind(query, names, *shown) {
+			matches = append(matches, files[i])
+		}
+		status := fmt.Sprintf("%d files", len(files))
+		if listing {
+			status += ", still listing"
+		}
+		mu.Unlock()
+		if query != "" {
+			for i, f := range matches {
+				kind := ""
+				if f.MimeType == magic.FolderMime {
+					kind = "/"
+				}
+				fmt.Fprintf(os.Stderr, "%3d  %s%s  (%s)\n", i+1, magic.Names.Display(f.Name), kind, f.Id)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "[%s] search, or pick a number (empty to quit): ", status)
+		line, err := stdin.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("nothing picked")
+		}
+		n, nerr := strconv.Atoi(line)
+		if nerr != nil || n < 1 || n > len(matches) || query == "" {
+			query = line
+			continue
+		}
+		f := matches[n-1]
+		if !*asPath {
+			fmt.Println(f.Id)
+			return nil
+		}
+		paths, err := magic.DrivePaths(ctx, d, f.Id)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+		return nil
+	}
+}
+
+// openCommand shows a Drive file in the browser.
+func openCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: open <fileId|%s/path>", magic.DrivePrefix)
+	}
+	f, err := magic.Links(ctx, d, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Opening %s\n", f.WebViewLink)
+	return magic.OpenBrowser(f.WebViewLink)
+}
+
+// copyOut puts the ID or the view link of the Drive file id on the
+// clipboard, as -copy asks; failing to is only reported.
+func copyOut(ctx context.Context, d *drive.Service, id string) {
+	text := id
+	if *copyWhat == "link" {
+		f, err := magic.Links(ctx, d, id)
+		if err != nil {
+			fmt.Printf("Not copied: %v\n", err)
+			return
+		}
+		text = f.WebViewLink
+	}
+	if err := magic.CopyToClipboard(text); err != nil {
+		fmt.Printf("Not copied: %v\n", err)
+		return
+	}
+	fmt.Printf("Copied %s to the clipboard\n", *copyWhat)
+}
+
+// resolveArgs turns the drive: paths among the arguments of a command,
+// given alone or as -flag=drive:..., into file IDs.
+func resolveArgs(ctx context.Context, d *drive.Service, args []string) ([]string, error) {
+	out := make([]string, len(args))
+	for i, a := range args {
+		prefix, value := "", a
+		if strings.HasPrefix(a, "-") {
+			if eq := strings.Index(a, "="); eq > 0 {
+				prefix, value = a[:eq+1], a[eq+1:]
+			}
+		}
+		id, err := magic.ResolveID(ctx, d, value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = prefix + id
+	}
+	return out, nil
+}
+
+// queueOffline records this run for replay when it is an upload or a
+// sync and Google cannot be reached, reporting whether it did.
+func queueOffline() bool {
+	op := magic.OfflineOp{Args: os.Args[1:]}
+	switch {
+	case flag.Arg(0) == "sync" && flag.NArg() > 1:
+		op.Op, op.Path = "sync", flag.Arg(flag.NArg()-1)
+	case flag.NArg() == 0 && *downloadID == "" && *unpackID == "":
+		op.Op, op.Path, op.Folder, op.Title = "upload", *inputPath, *folderName, *outputFile
+		if op.Title == "" {
+			op.Title = filepath.Base(*inputPath)
+		}
+	default:
+		return false
+	}
+	reach := magic.Reachable()
+	if reach == nil {
+		if ops, err := magic.OfflineOps(); err == nil && len(ops) > 0 {
+			fmt.Printf("%d runs were queued while offline; run \"replay\" to send them\n", len(ops))
+		}
+		return false
+	}
+	op.Dir, _ = os.Getwd()
+	op, err := magic.QueueOffline(op)
+	if err != nil {
+		log.Fatalf("Google is unreachable (%v) and the %s could not be queued: %v", reach, op.Op, err)
+	}
+	fmt.Printf("Google is unreachable (%v); queued the %s of %s as #%d, run \"replay\" once online\n", reach, op.Op, op.Path, op.ID)
+	return true
+}
+
+// pluginsCommand lists the external plugins of -plugin-dir and the hooks
+// they serve.
+func pluginsCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: plugins")
+	}
+	if len(extPlugins) == 0 {
+		fmt.Println("No plugins")
+	}
+	for _, p := range extPlugins {
+		fmt.Printf("%-20s %-30s %s\n", p.Name, strings.Join(p.Hooks, ","), p.Path)
+	}
+	return nil
+}
+
+// replayCommand runs the uploads and syncs queued while offline, oldest
+// first. Syncs settle what changed meanwhile like any sync; uploads of
+// files changed on Drive after they were queued are held back.
+func replayCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	wait := fs.Bool("wait", false, "wait for the network to come back instead of failing")
+	interval := fs.Duration("interval", time.Minute, "with -wait, how often to check the network")
+	list := fs.Bool("list", false, "only list the queued runs")
+	force := fs.Bool("force", false, "upload files even if they changed on Drive after they were queued")
+	drop := fs.Uint64("drop", 0, "forget the queued run with this number")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *drop != 0 {
+		return magic.DropOffline(*drop)
+	}
+	ops, err := magic.OfflineOps()
+	if err != nil {
+		return err
+	}
+	if *list || len(ops) == 0 {
+		for _, op := range ops {
+			fmt.Printf("#%d %s %s  queued %s: %s\n", op.ID, op.Op, op.Path, op.Queued.Format("2006-01-02 15:04"), strings.Join(op.Args, " "))
+		}
+		if len(ops) == 0 {
+			fmt.Println("Nothing queued")
+		}
+		return nil
+	}
+	if *wait {
+		fmt.Println("Waiting for the network")
+		if err = magic.WaitOnline(ctx, *interval); err != nil {
+			return err
+		}
+	} else if err = magic.Reachable(); err != nil {
+		return fmt.Errorf("still unable to reach Google: %v", err)
+	}
+	if d == nil {
+		if client, err = magic.Client(ctx, scopes()...); err != nil {
+			return err
+		}
+		if d, err = magic.Service(client); err != nil {
+			return err
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	held := 0
+	for _, op := range ops {
+		if op.Op == "upload" && !*force {
+			f, err := changedSince(ctx, d, op)
+			if err != nil {
+				return err
+			}
+			if f != nil {
+				fmt.Printf("#%d held back: %s changed on Drive at %s, after it was queued; use -force or -drop %d\n", op.ID, f.Name, f.ModifiedTime, op.ID)
+				held++
+				continue
+			}
+		}
+		fmt.Printf("#%d replaying the %s of %s\n", op.ID, op.Op, op.Path)
+		cmd := exec.Command(exe, op.Args...)
+		cmd.Dir, cmd.Stdin, cmd.Stdout, cmd.Stderr = op.Dir, os.Stdin, os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil {
+			if magic.Reachable() != nil {
+				return fmt.Errorf("offline again: %v", err)
+			}
+			fmt.Printf("#%d failed: %v; left queued\n", op.ID, err)
+			held++
+			continue
+		}
+		if err := magic.DropOffline(op.ID); err != nil {
+			return err
+		}
+	}
+	if held > 0 {
+		return fmt.Errorf("%d runs left queued", held)
+	}
+	return nil
+}
+
+// changedSince returns the file the queued upload op would land next to
+// when it was modified on Drive after op was queued.
+func changedSince(ctx context.Context, d *drive.Service, op magic.OfflineOp) (*drive.File, error) {
+	if info, err := os.Stat(filepath.Join(op.Dir, op.Path)); err == nil && info.IsDir() {
+		return nil, nil
+	}
+	q := fmt.Sprintf("name = '%s' and trashed = false and modifiedTime > '%s'", magic.QueryString(magic.Names.Encrypt(op.Title)), op.Queued.UTC().Format(time.RFC3339))
+	if op.Folder == "" {
+		q += " and 'root' in parents"
+	}
+	it := magic.ListIter(ctx, d, q, "id, name, parents, modifiedTime")
+	for it.Next() {
+		f := it.File()
+		if op.Folder == "" {
+			return f, nil
+		}
+		for _, p := range f.Parents {
+			parent, err := d.Files.Get(p).Fields("name").Context(ctx).Do()
+			if err == nil && parent.Name == op.Folder {
+				return f, nil
+			}
+		}
+	}
+	return nil, it.Err()
+}
+
+// appendSheetCommand appends the CSV rows of a file, or of stdin when the
+// file is omitted or "-", to a spreadsheet.
+func appendSheetCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("append-sheet", flag.ContinueOnError)
+	sheet := fs.String("range", "Sheet1", "sheet or A1 range whose table the rows are appended to")
+	raw := fs.Bool("raw", false, "store values as given instead of parsing numbers, dates and formulas")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return fmt.Errorf("usage: append-sheet [-range Sheet1] [-raw] <spreadsheetId> [file.csv]")
+	}
+	in := io.Reader(os.Stdin)
+	if name := fs.Arg(1); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	n, err := magic.AppendCSV(ctx, client, fs.Arg(0), *sheet, in, *raw)
+	if n > 0 {
+		fmt.Printf("Appended %d rows to %s\n", n, fs.Arg(0))
+	}
+	return err
+}
+
+// photosCommand runs "photos upload", adding image and video files, or
+// those found in directories, to Google Photos.
+func photosCommand(ctx context.Context, d *drive.Service, args []string) error {
+	if len(args) == 0 || args[0] != "upload" {
+		return fmt.Errorf("usage: photos upload [-album title | -album-id id] <file|dir>...")
+	}
+	fs := flag.NewFlagSet("photos upload", flag.ContinueOnError)
+	album := fs.String("album", "", "create an album with this title and add the uploads to it")
+	albumID := fs.String("album-id", "", "add the uploads to this existing album")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 || *album != "" && *albumID != "" {
+		return fmt.Errorf("usage: photos upload [-album title | -album-id id] <file|dir>...")
+	}
+	var paths []string
+	for _, arg := range fs.Args() {
+		err := filepath.Walk(arg, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && (p == arg || isMedia(p)) {
+				paths = append(paths, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	id := *albumID
+	if *album != "" {
+		var err error
+		if id, err = magic.CreateAlbum(ctx, client, *album); err != nil {
+			return err
+		}
+		fmt.Printf("Created album '%s'\n", *album)
+	}
+	var failed error
+	magic.UploadPhotos(ctx, client, id, paths, func(r magic.PhotoResult) {
+		if r.Err != nil {
+			fmt.Printf("An error occurred uploading %s: %v\n", r.Path, r.Err)
+			run.Failed()
+			failed = r.Err
+			return
+		}
+		if info, err := os.Stat(r.Path); err == nil {
+			run.Uploaded(info.Size())
+		}
+		fmt.Printf("Uploaded '%s' to Photos: %s\n", r.Path, r.URL)
+	})
+	return failed
+}
+
+// isMedia reports whether Photos accepts the file by its extension.
+func isMedia(filename string) bool {
+	t := mimeTypeOf(filename)
+	return strings.HasPrefix(t, "image/") || strings.HasPrefix(t, "video/")
+}
+
+// publishCommand uploads release artifacts into a folder named after the
+// version, with a checksum manifest, shares the folder with anyone who has
+// the link and prints Markdown download links.
+func publishCommand(ctx context.Context, d *drive.Service, args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ContinueOnError)
+	releases := fs.String("folder", "releases", "Drive folder the version folders are created in")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return fmt.Errorf("usage: publish [-folder releases] <version> <artifact>...")
+	}
+	version := fs.Arg(0)
+	folder := getOrCreateFolder(d, version, getOrCreateFolder(d, *releases, ""))
+	if *sumsName == "" {
+		*sumsName = "SHA256SUMS"
+	}
+	algo := *checksum
+	if algo == "" {
+		algo = magic.ChecksumSHA256
+	}
+	sums = magic.NewSums(algo, "")
+	var files []*drive.File
+	for _, p := range fs.Args()[1:] {
+		f, err := uploadFile(d, filepath.Base(p), "", folder, mimeTypeOf(p), p)
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	m, err := uploadSums(d, folder)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		files = append(files, m)
+	}
+	if err = magic.ShareLink(ctx, d, folder, "reader", time.Time{}); err != nil {
+		return err
+	}
+	magic.Audit(magic.AuditEntry{
+		Actor:  currentUser(),
+		Op:     "share",
+		FileID: folder,
+		Params: map[string]string{"role": "reader", "release": version},
+	})
+	l, err := magic.Links(ctx, d, folder)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\n### [%s](%s)\n\n", version, l.WebViewLink)
+	for _, f := range files {
+		l, err := magic.Links(ctx, d, f.Id)
+		if err != nil {
+			return err
+		}
+		link := l.WebContentLink
+		if link == "" {
+			link = l.WebViewLink
+		}
+		fmt.Printf("- [%s](%s)\n", f.Name, link)
+	}
+	return nil
+}
+
+func main() {
+
+	inputPath = flag.String("i", "./index.html", "input file path")
+	outputFile = flag.String("o", "", "output filename")
+	folderName = flag.String("f", "./user1", "folder name, or an existing folder as "+magic.DrivePrefix+"/path")
+	auditLog = flag.String("audit-log", "", "append a JSON line per mutating operation to this file")
+	auditSyslog := flag.Bool("audit-syslog", false, "also send audit entries to syslog")
+	posix = flag.Bool("posix", false, "store mode, owner, mtime, symlink target and xattrs as appProperties and restore them on download")
+	downloadID = flag.String("d", "", "download the file with this ID to the output filename instead of uploading")
+	links = flag.String("links", magic.LinksFollow, "symlinks in directory uploads: follow, skip or preserve")
+	sparse = flag.Bool("sparse", false, "upload only the data extents of files with holes")
+	atomic = flag.Bool("atomic", false, "upload under a temporary name and rename into place only after the checksum (md5 unless -checksum) is verified")
+	ignore = flag.Bool("ignore", true, "skip paths matched by "+magic.IgnoreFile+" files (gitignore syntax) in directory uploads")
+	minSize := flag.String("min-size", "", "skip files smaller than this, e.g. 10K")
+	maxSize := flag.String("max-size", "", "skip files larger than this, e.g. 2G")
+	minAge := flag.String("min-age", "", "skip files modified more recently than this, e.g. 1d")
+	maxAge := flag.String("max-age", "", "skip files modified longer ago than this, e.g. 30d")
+	maxDepth = flag.Int("max-depth", 0, "refuse directory uploads deeper than this many levels (0 is unlimited)")
+	maxFiles = flag.Int("max-files", 0, "refuse directory uploads with more entries than this (0 is unlimited)")
+	limitWarn = flag.Bool("limit-warn", false, "warn and truncate instead of aborting when -max-depth or -max-files is hit")
+	scanners = flag.Int("scan-workers", 8, "directories read concurrently while scanning directory uploads")
+	transfers = flag.Int("transfers", 4, "files uploaded concurrently in directory uploads")
+	packSmall := flag.String("pack-small-files", "", "bundle small files of directory uploads into tar packs of about this size, e.g. 64M")
+	packThreshold := flag.String("pack-threshold", "1M", "files smaller than this are packed with -pack-small-files")
+	unpackID = flag.String("unpack", "", "restore files from the pack manifest with this ID into the output directory")
+	packPath = flag.String("pack-path", "", "with -unpack, restore only this packed path")
+	downloadTransfers := flag.Int("download-transfers", 4, "ranged streams used to download files of at least 64M")
+	downloadLimit := flag.String("download-bwlimit", "", "cap download bandwidth to this many bytes per second, e.g. 10M")
+	uploadLimit := flag.String("upload-bwlimit", "", "cap upload bandwidth to this many bytes per second")
+	totalLimit := flag.String("bwlimit", "", "cap the combined upload and download bandwidth, a rate or a schedule such as \"Mon-Fri 09:00-17:00 1M, else off\"")
+	configFile := flag.String("config", magic.ConfigFile, "YAML settings file, overridden by flags")
+	checksum = flag.String("checksum", "", "verify transfers against Drive's md5, sha1 or sha256 checksum")
+	immutable = flag.String("immutable", "", "leave existing Drive files alone in backups: revisions (pin changes as revisions) or snapshot (upload changes into a new "+magic.SnapshotsName+" folder); nothing is deleted")
+	conflicts = flag.String("conflict-default", "", "settle sync conflicts without asking: local, remote, both or skip")
+	queueFile := flag.String("queue", filepath.Join("cache", "upload-queue.json"), "keep pending uploads in this file so an interrupted run resumes where it stopped")
+	asGoogleDoc = flag.Bool("as-google-doc", false, "convert .docx, .xlsx, .pptx, .csv, .md and similar uploads into native Google documents")
+	authFlow := flag.String("auth-flow", magic.AuthFlowCode, "how to authorize when no token is cached: code (paste from the browser) or device (enter a short code on another device)")
+	flag.StringVar(&magic.SecretFile, "client-secret", magic.SecretFile, "OAuth client secret JSON file; $"+magic.SecretEnv+" holds the JSON itself")
+	flag.StringVar(&magic.TokenFile, "token", magic.TokenFile, "cached OAuth token file; $"+magic.TokenEnv+" holds the JSON itself")
+	flag.BoolVar(&magic.TokenEncrypt, "token-encrypt", false, "encrypt the cached token with a passphrase from -token-key-file, $"+magic.TokenPassphraseEnv+" or a prompt")
+	tokenKeyFile := flag.String("token-key-file", "", "read the token passphrase from the first line of this file")
+	nameKeyFile := flag.String("encrypt-names", "", "encrypt the names of uploaded files and folders with the passphrase on the first line of this file, and decrypt them in ls and downloads")
+	keepExt := flag.Bool("keep-extensions", false, "with -encrypt-names, leave file extensions readable")
+	var encryptTo listFlag
+	flag.Var(&encryptTo, "encrypt-to", "encrypt uploaded content to this recipient, or the recipients in this file (see keygen); repeatable")
+	identityFile := flag.String("identity", "", "decrypt downloads, and rekey, with the identities in this file")
+	flag.StringVar(&magic.CredentialsFile, "credentials", "", "authorize with this workload identity federation config JSON instead of the client secret and cached token")
+	signKey = flag.String("sign-key", "", "upload a detached GPG signature (.asc) made with this key next to every uploaded file")
+	sumsName = flag.String("checksum-manifest", "", "write a manifest of this name, e.g. SHA256SUMS, with the -checksum (default sha256) of every uploaded file into the destination")
+	timestampFolder = flag.Bool("timestamp-folder", false, "upload into a new subfolder of the destination named after the time of the run")
+	timestampFormat = flag.String("timestamp-format", "2006-01-02_150405", "name of -timestamp-folder subfolders, as a Go time layout")
+	flag.DurationVar(&magic.OutageAfter, "outage-after", magic.OutageAfter, "retry transfers failing on network errors this long, then hold them until Google is reachable again and resume (0 fails them at once)")
+	pluginDir := flag.String("plugin-dir", magic.PluginDir, "run the external plugins in this directory as filters, notifiers and upload stores")
+	copyWhat = flag.String("copy", "", "copy the id or view link of the uploaded file or folder, or of link's file, to the clipboard")
+	columns := flag.String("columns", magic.DefaultColumns, "columns of file listings: id, name, size, bytes, mtime, owner, type, link and parents")
+	long := flag.Bool("long", false, "list files with -columns "+magic.LongColumns+", like ls -l")
+	sortBy := flag.String("sort", "", "sort file listings by name, size, mtime, ctime or folder, comma separated, - reversing one")
+	progressKind := flag.String("progress", "plain", "how transfer progress is shown: plain, json (lines on stderr) or none")
+	flag.BoolVar(&magic.DebugHTTP, "debug-http", false, "log every call to Google: method, URL, status, latency and retries, with credentials redacted")
+	flag.BoolVar(&magic.DebugHTTPBody, "debug-http-body", false, "like -debug-http, also logging headers and text payloads")
+	flag.DurationVar(&magic.StallTimeout, "stall-timeout", magic.StallTimeout, "abort and retry a transfer request that moves no data for this long (0 waits forever)")
+	quotaResume = flag.Bool("quota-resume", false, "when Drive storage or the daily quota runs out, wait until it is back and go on with the queued uploads instead of stopping")
+	offlineQueue := flag.Bool("offline-queue", false, "when Google cannot be reached, queue uploads and syncs in the state DB for \"replay\", exiting with status 3, instead of failing")
+	readOnly := flag.Bool("read-only", false, "refuse every call that would change Drive; allow_folders in -config limits changes to those folders instead")
+	fullAccess = flag.Bool("full-access", false, "authorize the full Drive scope instead of drive.file, to reach files this tool did not create")
+	flag.Var(&labelFlags, "label", "apply a Drive label to uploads, Label[.Field]=Value; repeatable")
+	flag.Parse()
+	magic.TokenPassphrase = magic.Passphrase(*tokenKeyFile)
+
+	var err error
+	if *nameKeyFile != "" {
+		if magic.Names, err = magic.LoadNameKey(*nameKeyFile); err != nil {
+			log.Fatal(err)
+		}
+		magic.Names.KeepExtension = *keepExt
+	}
+	if recipients, err = magic.ParseRecipients(encryptTo); err != nil {
+		log.Fatalf("Invalid -encrypt-to: %v", err)
+	}
+	if filter.MinSize, err = magic.ParseSize(*minSize); err != nil {
+		log.Fatalf("Invalid -min-size: %v", err)
+	}
+	if filter.MaxSize, err = magic.ParseSize(*maxSize); err != nil {
+		log.Fatalf("Invalid -max-size: %v", err)
+	}
+	if packSize, err = magic.ParseSize(*packSmall); err != nil {
+		log.Fatalf("Invalid -pack-small-files: %v", err)
+	}
+	if packBelow, err = magic.ParseSize(*packThreshold); err != nil {
+		log.Fatalf("Invalid -pack-threshold: %v", err)
+	}
+	if _, err = magic.ParseImmutable(*immutable); err != nil {
+		log.Fatalf("Invalid -immutable: %v", err)
+	}
+	switch *authFlow {
+	case magic.AuthFlowCode, magic.AuthFlowDevice:
+		magic.AuthFlow = *authFlow
+	default:
+		log.Fatalf("Invalid -auth-flow %q", *authFlow)
+	}
+	config, err := magic.LoadConfig(*configFile)
+	if err != nil {
+		log.Fatalf("Unable to read config: %v", err)
+	}
+	magic.SetGuard(*readOnly || config.ReadOnly, config.AllowFolders)
+	if rules, err = magic.CompileRules(config.Rules); err != nil {
+		log.Fatalf("Invalid rules in %s: %v", *configFile, err)
+	}
+	if *totalLimit == "" {
+		*totalLimit = config.BWLimit
+	}
+	bandwidth, err := magic.ParseBandwidth(*totalLimit)
+	if err != nil {
+		log.Fatalf("Invalid -bwlimit: %v", err)
+	}
+	downRate, err := magic.ParseSize(*downloadLimit)
+	if err != nil {
+		log.Fatalf("Invalid -download-bwlimit: %v", err)
+	}
+	upRate, err := magic.ParseSize(*uploadLimit)
+	if err != nil {
+		log.Fatalf("Invalid -upload-bwlimit: %v", err)
+	}
+	if *checksum != "" {
+		if _, err := magic.NewChecksum(*checksum); err != nil {
+			log.Fatalf("Invalid -checksum: %v", err)
+		}
+	}
+	if progress, err = newProgress(*progressKind); err != nil {
+		log.Fatalf("Invalid -progress: %v", err)
+	}
+	listColumns = *columns
+	if *long {
+		listColumns = magic.LongColumns
+	}
+	if _, err = magic.NewTable(io.Discard, listColumns); err != nil {
+		log.Fatalf("Invalid -columns: %v", err)
+	}
+	if *sortBy != "" {
+		if listOrder, err = magic.SortOrder(*sortBy); err != nil {
+			log.Fatalf("Invalid -sort: %v", err)
+		}
+	}
+	if *copyWhat != "" && *copyWhat != "id" && *copyWhat != "link" {
+		log.Fatalf("Invalid -copy %q: want id or link", *copyWhat)
+	}
+	downloads = magic.DownloadOptions{Posix: *posix, Streams: *downloadTransfers, Bandwidth: bandwidth.Child(downRate), Checksum: *checksum, Progress: progress}
+	if *identityFile != "" {
+		if downloads.Identities, err = magic.LoadIdentities(*identityFile); err != nil {
+			log.Fatalf("Invalid -identity: %v", err)
+		}
+	}
+	if filter.MinAge, err = magic.ParseAge(*minAge); err != nil {
+		log.Fatalf("Invalid -min-age: %v", err)
+	}
+	if filter.MaxAge, err = magic.ParseAge(*maxAge); err != nil {
+		log.Fatalf("Invalid -max-age: %v", err)
+	}
+
+	magic.HandlePauseSignals()
+
+	if queue, err = magic.OpenQueue(*queueFile); err != nil {
+		log.Fatalf("Unable to open transfer queue: %v", err)
+	}
+	queue.Bandwidth = bandwidth.Child(upRate)
+	queue.Progress = progress
+	if n := len(queue.Pending()); n > 0 {
+		fmt.Printf("%d uploads left over from an interrupted run will be resumed\n", n)
+	}
+
+	if err := magic.OpenAudit(*auditLog, *auditSyslog); err != nil {
+		log.Fatalf("Unable to open audit log: %v", err)
+	}
+	defer magic.CloseAudit()
+
+	if extPlugins, err = magic.LoadPlugins(*pluginDir); err != nil {
+		log.Fatalf("Unable to load plugins: %v", err)
+	}
+	defer magic.ClosePlugins(extPlugins)
+	magic.UsePlugins(extPlugins)
+
+	// fmt.Println("input: %s", *inputPath)
+	// fmt.Println("output: %s", *outputFile)
+	// fmt.Println("folder: %s", *folderName)
+
+	ctx := context.Background()
+
+	if name := flag.Arg(0); offline[name] {
+		if err := commands[name](ctx, nil, flag.Args()[1:]); err != nil {
+			log.Fatalf("Unable to %s: %v", name, err)
+		}
+		return
+	}
+
+	if *offlineQueue && queueOffline() {
+		// nothing was sent yet, which scripts must not take for success
+		os.Exit(3)
+	}
+
+	client, err = magic.Client(ctx, scopes()...)
+	if err != nil {
+		log.Fatalf("Unable to get drive client: %v", magic.ExplainAuthError(err))
+	}
+	if len(labelFlags) > 0 || rulesLabel() {
+		catalog, err := magic.LoadLabels(ctx, client)
+		if err != nil {
+			log.Fatalf("Unable to load Drive labels: %v", err)
+		}
+		for _, l := range labelFlags {
+			spec, err := catalog.Resolve(l)
+			if err != nil {
+				log.Fatalf("Invalid -label: %v", err)
+			}
+			labels = append(labels, spec)
+		}
+		ruleLabels = map[*magic.Rule][]*magic.LabelSpec{}
+		for _, r := range rules {
+			for _, l := range r.Labels {
+				spec, err := catalog.Resolve(l)
+				if err != nil {
+					log.Fatalf("Invalid label in rule %q: %v", r.When, err)
+				}
+				ruleLabels[r] = append(ruleLabels[r], spec)
+			}
+		}
+	}
+
+	srv, err := magic.Service(client)
+	if err != nil {
+		log.Fatalf("Unable to retrieve drive Client %v", err)
+	}
+
+	switch {
+	case flag.NArg() > 0:
+		run = magic.NewRun(flag.Arg(0))
+	case *unpackID != "":
+		run = magic.NewRun("unpack")
+	case *downloadID != "":
+		run = magic.NewRun("download")
+	default:
+		run = magic.NewRun("upload")
+	}
+	defer saveRun()
+
+	for _, id := range []*string{downloadID, unpackID} {
+		resolved, err := magic.ResolveID(ctx, srv, *id)
+		if err != nil {
+			fail("Unable to find %s: %v", *id, scopeHint(err))
+		}
+		*id = resolved
+	}
+
+	if flag.NArg() > 0 {
+		name := flag.Arg(0)
+		cmd, ok := commands[name]
+		if !ok {
+			log.Fatalf("Unknown command %q", name)
+		}
+		args := flag.Args()[1:]
+		if name != "resolve" {
+			if args, err = resolveArgs(ctx, srv, args); err != nil {
+				fail("Unable to %s: %v", name, scopeHint(err))
+			}
+		}
+		if err := cmd(ctx, srv, args); err != nil {
+			fail("Unable to %s: %v", name, scopeHint(err))
+		}
+		return
+	}
+
+	if *unpackID != "" {
+		dest := *outputFile
+		if dest == "" {
+			dest = "."
+		}
+		if err := unpack(srv, *unpackID, *packPath, dest); err != nil {
+			fail("Unable to unpack: %v", scopeHint(err))
+		}
+		return
+	}
+
+	if *downloadID != "" {
+		dest := *outputFile
+		if dest == "" {
+			dest = *downloadID
+			// the ID tells less than the name it hides
+			if magic.Names != nil {
+				f, err := srv.Files.Get(*downloadID).Fields("name").Do()
+				if err != nil {
+					fail("Unable to download file: %v", scopeHint(err))
+				}
+				if name, err := magic.Names.Decrypt(f.Name); err == nil {
+					dest = magic.LocalName(name)
+				}
+			}
+		}
+		f, err := magic.Download(srv, *downloadID, dest, downloads)
+		if err != nil {
+			fail("Unable to download file: %v", scopeHint(err))
+		}
+		run.Downloaded(f.Size)
+		fmt.Printf("Downloaded '%s' to %s\n", magic.Names.Display(f.Name), dest)
+		return
+	}
+
+	fmt.Printf("Read file: %s\n", *inputPath)
+	outputTitle := *outputFile
+	if outputTitle == "" {
+		outputTitle = filepath.Base(*inputPath)
+	}
+	fmt.Printf("Output name: %s\n", outputTitle)
+
+	var parentId string
+	if magic.IsDrivePath(*folderName) {
+		if parentId, err = magic.ResolveID(ctx, srv, *folderName); err != nil {
+			fail("Unable to find %s: %v", *folderName, scopeHint(err))
+		}
+	} else {
+		parentId = getOrCreateFolder(srv, *folderName, "")
+	}
+	if destConfig, err = magic.LoadFolderConfig(ctx, srv, parentId); err != nil {
+		fail("Unable to read %s of the destination: %v", magic.FolderConfigName, err)
+	}
+	destId := parentId
+	if *timestampFolder {
+		parentId = getOrCreateFolder(srv, time.Now().Format(*timestampFormat), parentId)
+	}
+
+	sumsAlgo := *checksum
+	if sumsAlgo == "" {
+		sumsAlgo = magic.ChecksumSHA256
+	}
+	if info, err := os.Stat(*inputPath); err == nil && info.IsDir() {
+		if *sumsName != "" {
+			sums = magic.NewSums(sumsAlgo, filepath.Clean(*inputPath))
+		}
+		if err := uploadTree(srv, *inputPath, outputTitle, parentId); err != nil {
+			fail("Unable to upload directory: %v", magic.ExplainAuthError(err))
+		}
+		if *copyWhat != "" {
+			copyOut(ctx, srv, getOrCreateFolder(srv, magic.Names.Encrypt(outputTitle), parentId))
+		}
+	} else {
+		mimeType := mimeTypeOf(*inputPath)
+		fmt.Printf("Mime : %s\n", mimeType)
+		if !destConfig.Allows(mimeType) {
+			fail("Unable to upload: type %s is not allowed in the destination", mimeType)
+		}
+
+		if *sumsName != "" {
+			sums = magic.NewSums(sumsAlgo, "")
+		}
+		r, err := uploadFile(srv, outputTitle, "", parentId, mimeType, *inputPath)
+		if err != nil {
+			fail("Unable to upload: %v", magic.ExplainAuthError(err))
+		}
+		if *copyWhat != "" {
+			copyOut(ctx, srv, r.Id)
+		}
+		if _, err := uploadSums(srv, parentId); err != nil {
+			fail("Unable to write %s: %v", *sumsName, err)
+		}
+	}
+	if *immutable != "" && destConfig.Retention != "" {
+		fmt.Printf("Not applying the destination's retention of %s: -immutable\n", destConfig.Retention)
+		destConfig = nil
+	}
+	if run.Failures > 0 && destConfig != nil && destConfig.Retention != "" {
+		fmt.Printf("Not applying the destination's retention of %s: %d uploads failed\n", destConfig.Retention, run.Failures)
+		destConfig = nil
+	}
+	trashed, err := magic.ApplyRetention(ctx, srv, destId, destConfig)
+	for _, p := range trashed {
+		fmt.Printf("Trashed %s, past the destination's retention of %s\n", p, destConfig.Retention)
+	}
+	if err != nil {
+		fail("Unable to apply retention: %v", err)
+	}
 
-	r, err := srv.Files.List().MaxResults(10).Do()
+	call := srv.Files.List().PageSize(10).Q(filter.Query())
+	listCall(call)
+	r, err := call.Do()
 	if err != nil {
-		log.Fatalf("Unable to retrieve files.", err)
+		log.Fatalf("Unable to retrieve files: %v", err)
 	}
 	fmt.Println("Files:")
-	if len(r.Items) > 0 {
-		for _, i := range r.Items {
-			fmt.Printf("%s (%s)-(%s)\n", i.Title, i.Id, i.DownloadUrl)
+	if len(r.Files) > 0 {
+		table, _ := magic.NewTable(os.Stdout, listColumns)
+		for _, i := range r.Files {
+			mtime, _ := time.Parse(time.RFC3339, i.ModifiedTime)
+			if !filter.Match(i.Size, mtime) {
+				continue
+			}
+			table.Write(i)
 		}
+		table.Flush()
 	} else {
 		fmt.Print("No files found.")
 	}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	magic "../plugins"
+	"github.com/labstack/echo"
+)
+
+// localOnly restricts operator endpoints to requests from this machine.
+func localOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			return c.NoContent(http.StatusForbidden)
+		}
+		return next(c)
+	}
+}
+
+func transfersState(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]bool{"paused": magic.TransfersPaused(), "offline": magic.TransfersOffline()})
+}
+
+// transfersPause stops Drive chunk uploads until transfersResume; tus
+// PATCH requests block meanwhile instead of failing.
+func transfersPause(c echo.Context) error {
+	magic.PauseTransfers()
+	magic.Audit(magic.AuditEntry{Actor: "admin", Op: "pause", Params: map[string]string{"ip": c.RealIP()}, RequestID: requestID(c)})
+	return transfersState(c)
+}
+
+func transfersResume(c echo.Context) error {
+	magic.ResumeTransfers()
+	magic.Audit(magic.AuditEntry{Actor: "admin", Op: "resume", Params: map[string]string{"ip": c.RealIP()}, RequestID: requestID(c)})
+	return transfersState(c)
+}
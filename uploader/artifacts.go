@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	magic "../plugins"
+	"github.com/labstack/echo"
+	"google.golang.org/api/drive/v3"
+)
+
+// artifacts serves the files of Config.Routes.
+var (
+	artifacts     *magic.Artifacts
+	artifactDrive *drive.Service
+	artifactTTL   time.Duration
+)
+
+// setupArtifacts serves the routes from every path no other handler
+// takes, so reload can change them, and flushes the download counts every
+// minute.
+func setupArtifacts(e *echo.Echo, srv *drive.Service, routes []magic.Route, ttl time.Duration) {
+	artifacts, artifactDrive, artifactTTL = magic.NewArtifacts(srv, routes, ttl), srv, ttl
+	e.GET("/*", artifact)
+	e.HEAD("/*", artifact)
+	go func() {
+		for range time.Tick(time.Minute) {
+			if err := artifacts.Flush(); err != nil {
+				log.Printf("unable to save download counts: %v", err)
+			}
+		}
+	}()
+}
+
+// artifact streams the Drive file behind a clean URL, passing Range
+// requests through and answering conditional ones from the cached
+// metadata.
+func artifact(c echo.Context) error {
+	req := c.Request()
+	f, err := artifacts.Resolve(req.Context(), req.URL.Path)
+	if err == magic.ErrNoRoute || err == nil && magic.IsGoogleDoc(f) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	h := c.Response().Header()
+	etag := `"` + f.Md5Checksum + `"`
+	mtime, _ := time.Parse(time.RFC3339, f.ModifiedTime)
+	h.Set("ETag", etag)
+	if !mtime.IsZero() {
+		h.Set("Last-Modified", mtime.UTC().Format(http.TimeFormat))
+	}
+	h.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(artifactTTL.Seconds())))
+	h.Set("Accept-Ranges", "bytes")
+	if notModified(req, etag, mtime) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	h.Set("Content-Type", f.MimeType)
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", f.Name))
+	rng := req.Header.Get("Range")
+	if !ifRange(req, etag, mtime) {
+		rng = ""
+	}
+	start, end, ok, err := byteRange(rng, f.Size)
+	if err != nil {
+		h.Set("Content-Range", fmt.Sprintf("bytes */%d", f.Size))
+		return c.NoContent(http.StatusRequestedRangeNotSatisfiable)
+	}
+	if req.Method == http.MethodHead {
+		if !ok {
+			h.Set("Content-Length", fmt.Sprint(f.Size))
+			return c.NoContent(http.StatusOK)
+		}
+		h.Set("Content-Length", fmt.Sprint(end-start+1))
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, f.Size))
+		return c.NoContent(http.StatusPartialContent)
+	}
+	call := artifactDrive.Files.Get(f.Id).Context(req.Context())
+	if ok {
+		// Drive gets the range resolved, so suffix and open-ended ones
+		// behave the same whatever it accepts
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	}
+	res, err := call.Download()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	for _, k := range []string{"Content-Length", "Content-Range"} {
+		if v := res.Header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+	c.Response().WriteHeader(res.StatusCode)
+	n, err := io.Copy(c.Response(), res.Body)
+	// a download counts once, not for every range of a resumed one
+	artifacts.Served(req.URL.Path, !ok || start == 0, n)
+	return err
+}
+
+// ifRange reports whether a Range request is to be honoured: always
+// without If-Range, else only while its ETag or date still matches the
+// file, so a client resuming an outdated copy gets the whole new one.
+func ifRange(req *http.Request, etag string, mtime time.Time) bool {
+	ir := req.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, `"`) {
+		return ir == etag
+	}
+	t, err := http.ParseTime(ir)
+	return err == nil && !mtime.IsZero() && mtime.Truncate(time.Second).Equal(t)
+}
+
+// errUnsatisfiable is returned by byteRange for ranges starting past the
+// end of the file.
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+// byteRange parses a single "bytes=" range of a file of size bytes into
+// inclusive offsets. ok is false when the whole file is to be served:
+// without a Range header, for malformed ones, which RFC 7233 says to
+// ignore, and for multiple ranges, which Drive cannot serve as one.
+func byteRange(rng string, size int64) (start, end int64, ok bool, err error) {
+	if !strings.HasPrefix(rng, "bytes=") || strings.Contains(rng, ",") {
+		return 0, 0, false, nil
+	}
+	parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(rng, "bytes=")), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false, nil
+	}
+	first, last := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if first == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errUnsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errUnsatisfiable
+	}
+	return start, end, true, nil
+}
+
+// notModified evaluates If-None-Match, or else If-Modified-Since, against
+// the file's md5 ETag and modification time (RFC 7232).
+func notModified(req *http.Request, etag string, mtime time.Time) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	// HTTP dates have no sub-second part
+	return err == nil && !mtime.IsZero() && !mtime.Truncate(time.Second).After(ims)
+}
+
+func artifactStats() (map[string]magic.ArtifactStats, error) {
+	if artifacts == nil {
+		return map[string]magic.ArtifactStats{}, nil
+	}
+	return artifacts.Stats()
+}
+
+// statsAPI returns the downloads and bytes served per artifact path.
+func statsAPI(c echo.Context) error {
+	stats, err := artifactStats()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// metrics exposes the artifact stats in the Prometheus text format.
+func metrics(c echo.Context) error {
+	stats, err := artifactStats()
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(stats))
+	for p := range stats {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	b.WriteString("# HELP magicserver_artifact_downloads_total Downloads of routed artifacts.\n")
+	b.WriteString("# TYPE magicserver_artifact_downloads_total counter\n")
+	for _, p := range paths {
+		fmt.Fprintf(&b, "magicserver_artifact_downloads_total{path=%q} %d\n", p, stats[p].Downloads)
+	}
+	b.WriteString("# HELP magicserver_artifact_bytes_total Bytes served for routed artifacts.\n")
+	b.WriteString("# TYPE magicserver_artifact_bytes_total counter\n")
+	for _, p := range paths {
+		fmt.Fprintf(&b, "magicserver_artifact_bytes_total{path=%q} %d\n", p, stats[p].Bytes)
+	}
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+}
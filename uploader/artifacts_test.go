@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestByteRange(t *testing.T) {
+	tests := []struct {
+		rng        string
+		size       int64
+		start, end int64
+		ok         bool
+		err        error
+	}{
+		{"", 100, 0, 0, false, nil},
+		{"bytes=0-9", 100, 0, 9, true, nil},
+		{"bytes=10-", 100, 10, 99, true, nil},
+		{"bytes= 10 - 19", 100, 10, 19, true, nil},
+		{"bytes=90-200", 100, 90, 99, true, nil},
+		{"bytes=99-99", 100, 99, 99, true, nil},
+		// suffix ranges
+		{"bytes=-10", 100, 90, 99, true, nil},
+		{"bytes=-100", 100, 0, 99, true, nil},
+		{"bytes=-500", 100, 0, 99, true, nil},
+		{"bytes=-0", 100, 0, 0, false, errUnsatisfiable},
+		{"bytes=-5", 0, 0, 0, false, errUnsatisfiable},
+		// multiple ranges are served whole
+		{"bytes=0-9,20-29", 100, 0, 0, false, nil},
+		{"bytes=-5, 0-1", 100, 0, 0, false, nil},
+		// unsatisfiable
+		{"bytes=100-", 100, 0, 0, false, errUnsatisfiable},
+		{"bytes=150-160", 100, 0, 0, false, errUnsatisfiable},
+		{"bytes=0-", 0, 0, 0, false, errUnsatisfiable},
+		// malformed ones are ignored
+		{"items=0-9", 100, 0, 0, false, nil},
+		{"bytes=9-0", 100, 0, 0, false, nil},
+		{"bytes=a-9", 100, 0, 0, false, nil},
+		{"bytes=0-b", 100, 0, 0, false, nil},
+		{"bytes=-x", 100, 0, 0, false, nil},
+		{"bytes=5", 100, 0, 0, false, nil},
+		{"bytes=--5", 100, 0, 0, false, nil},
+	}
+	for _, tt := range tests {
+		start, end, ok, err := byteRange(tt.rng, tt.size)
+		if err != tt.err || ok != tt.ok || ok && (start != tt.start || end != tt.end) {
+			t.Errorf("byteRange(%q, %d) = %d, %d, %v, %v; want %d, %d, %v, %v",
+				tt.rng, tt.size, start, end, ok, err, tt.start, tt.end, tt.ok, tt.err)
+		}
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	etag := `"abc"`
+	tests := []struct {
+		ifRange string
+		mtime   time.Time
+		want    bool
+	}{
+		{"", mtime, true},
+		{`"abc"`, mtime, true},
+		{`"abd"`, mtime, false},
+		{`W/"abc"`, mtime, false},
+		{mtime.Format(http.TimeFormat), mtime, true},
+		{mtime.Add(-time.Second).Format(http.TimeFormat), mtime, false},
+		{mtime.Format(http.TimeFormat), time.Time{}, false},
+		{"yesterday", mtime, false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, "/a", nil)
+		if tt.ifRange != "" {
+			req.Header.Set("If-Range", tt.ifRange)
+		}
+		if got := ifRange(req, etag, tt.mtime); got != tt.want {
+			t.Errorf("If-Range %q = %v, want %v", tt.ifRange, got, tt.want)
+		}
+	}
+}
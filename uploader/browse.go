@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	magic "../plugins"
+	"github.com/labstack/echo"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// browseDrive serves the list and zip APIs, which resolve paths in the
+// Config.Browse folder.
+var browseDrive *drive.Service
+
+const (
+	listDefaultSize = 100
+	listMaxSize     = 1000
+)
+
+type listEntry struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	MTime string `json:"mtime"`
+	Mime  string `json:"mime"`
+}
+
+type listResponse struct {
+	Path  string      `json:"path"`
+	Files []listEntry `json:"files"`
+	Next  string      `json:"next_page_token,omitempty"`
+}
+
+// listAPI returns a page of the folder at ?path= below the browse folder. Pages
+// hold ?page_size= entries, 100 by default, and the next one is fetched by
+// passing back next_page_token as ?page_token=.
+func listAPI(c echo.Context) error {
+	size := int64(listDefaultSize)
+	if s := c.QueryParam("page_size"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 1 || n > listMaxSize {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "page_size must be 1 to " + strconv.Itoa(listMaxSize)})
+		}
+		size = n
+	}
+	ctx := c.Request().Context()
+	p := c.QueryParam("path")
+	dir, err := browseFolder(c, p)
+	if err == magic.ErrNoPath {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no such folder"})
+	}
+	if err != nil {
+		return err
+	}
+	if dir.MimeType != magic.FolderMime {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "not a folder"})
+	}
+	files, next, err := magic.ListPage(ctx, browseDrive, dir.Id, c.QueryParam("page_token"), size)
+	if err != nil {
+		// an expired or foreign page token
+		var e *googleapi.Error
+		if errors.As(err, &e) && e.Code == http.StatusBadRequest {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid page_token"})
+		}
+		return err
+	}
+	res := listResponse{Path: p, Files: make([]listEntry, 0, len(files)), Next: next}
+	for _, f := range files {
+		// uploads not yet published, see magic.StagingName
+		if magic.IsStaging(f.Name) {
+			continue
+		}
+		res.Files = append(res.Files, listEntry{ID: f.Id, Name: f.Name, Size: f.Size, MTime: f.ModifiedTime, Mime: f.MimeType})
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// zipAPI streams the folder at ?folder= below the browse folder as a zip archive
+// assembled while it is sent. A failure midway can only cut the archive
+// short, which clients see as a truncated download.
+func zipAPI(c echo.Context) error {
+	ctx := c.Request().Context()
+	dir, err := browseFolder(c, c.QueryParam("folder"))
+	if err == magic.ErrNoPath {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no such folder"})
+	}
+	if err != nil {
+		return err
+	}
+	if dir.MimeType != magic.FolderMime {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "not a folder"})
+	}
+	h := c.Response().Header()
+	h.Set("Content-Type", "application/zip")
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", dir.Name+".zip"))
+	c.Response().WriteHeader(http.StatusOK)
+	return magic.WriteZip(ctx, browseDrive, dir.Id, c.Response())
+}
+
+// browseFolder resolves p in the browse folder; with browsing off nothing
+// exists.
+func browseFolder(c echo.Context, p string) (*drive.File, error) {
+	root := current().browse
+	if root == "" {
+		return nil, magic.ErrNoPath
+	}
+	return magic.ResolvePath(c.Request().Context(), browseDrive, root, p)
+}
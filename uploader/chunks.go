@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+// Chunked uploads for plain browser clients, which cannot PATCH tus
+// requests through every proxy. POST /chunks with the form fields of the
+// tus metadata plus size starts an upload; each chunk is then sent to
+// /chunks/:id, by PUT or POST, with a "Content-Range: bytes a-b/size"
+// header and either the raw bytes or a multipart/form-data body whose
+// first file holds them, as jQuery File Upload and Resumable.js send.
+// GET /chunks/:id, or a chunk of "Content-Range: bytes */size", tells the
+// offset to resume from. Uploads share the tus table, sessions and
+// scanning.
+
+// chunkState is the JSON answer of every chunk request.
+type chunkState struct {
+	ID      string `json:"id"`
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+	DriveID string `json:"drive_id,omitempty"` // once complete
+}
+
+func chunksCreate(c echo.Context) error {
+	length, err := strconv.ParseInt(c.FormValue("size"), 10, 64)
+	if err != nil || length < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid size")
+	}
+	if length > tusMaxSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "upload too large")
+	}
+	meta := map[string]string{}
+	for _, k := range []string{"id", "filename", "filetype", "folder", "priority", "profile"} {
+		meta[k] = c.FormValue(k)
+	}
+	up, err := newUpload(c, "/chunks", meta, length)
+	if err != nil {
+		return rejectUpload(c, err)
+	}
+	c.Response().Header().Set("Location", "/chunks/"+up.ID)
+	return c.JSON(http.StatusCreated, chunkState{ID: up.ID, Length: length})
+}
+
+func chunksState(c echo.Context) error {
+	up := tusLookup(c)
+	if up == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown upload")
+	}
+	defer up.Unlock()
+	return c.JSON(http.StatusOK, chunkState{ID: up.ID, Offset: up.Offset, Length: up.Length})
+}
+
+func chunksPut(c echo.Context) error {
+	up := tusLookup(c)
+	if up == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown upload")
+	}
+	defer up.Unlock()
+	r := c.Request()
+	start, end, total, err := parseContentRange(r.Header.Get("Content-Range"), up.Length)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if total != up.Length {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("upload is %d bytes, not %d", up.Length, total))
+	}
+	state := chunkState{ID: up.ID, Offset: up.Offset, Length: up.Length}
+	if start < 0 {
+		return c.JSON(http.StatusOK, state)
+	}
+	if start != up.Offset {
+		// a chunk sent twice, or one sent before its predecessor arrived
+		return c.JSON(http.StatusConflict, state)
+	}
+	body, err := chunkBody(r)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	// a short chunk shows in the offset, from which the client resends
+	state.Offset, err = up.write(io.LimitReader(body, end-start+1))
+	if err != nil {
+		return err
+	}
+	if up.Offset == up.Length {
+		f, err := up.finish(c, "chunks")
+		if err != nil {
+			return rejectUpload(c, err)
+		}
+		state.DriveID = f.Id
+	}
+	return c.JSON(http.StatusOK, state)
+}
+
+func chunksDelete(c echo.Context) error {
+	up := tusLookup(c)
+	if up == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "unknown upload")
+	}
+	defer up.Unlock()
+	tusDiscard(up)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// parseContentRange parses "bytes a-b/size". Without the header the body
+// is taken to be the whole upload of length bytes. "bytes */size", which
+// clients send empty to ask where to resume, has start and end -1.
+func parseContentRange(s string, length int64) (start, end, total int64, err error) {
+	if s == "" {
+		return 0, length - 1, length, nil
+	}
+	bad := fmt.Errorf("invalid Content-Range %q", s)
+	r := strings.TrimSpace(s)
+	if !strings.HasPrefix(r, "bytes ") {
+		return 0, 0, 0, bad
+	}
+	r = strings.TrimLeft(strings.TrimPrefix(r, "bytes "), " ")
+	slash := strings.IndexByte(r, '/')
+	if slash < 0 || !digits(r[slash+1:]) {
+		return 0, 0, 0, bad
+	}
+	if total, err = strconv.ParseInt(r[slash+1:], 10, 64); err != nil {
+		return 0, 0, 0, bad
+	}
+	if r[:slash] == "*" {
+		return -1, -1, total, nil
+	}
+	dash := strings.IndexByte(r[:slash], '-')
+	if dash < 0 || !digits(r[:dash]) || !digits(r[dash+1:slash]) {
+		return 0, 0, 0, bad
+	}
+	start, err = strconv.ParseInt(r[:dash], 10, 64)
+	if err == nil {
+		end, err = strconv.ParseInt(r[dash+1:slash], 10, 64)
+	}
+	if err != nil || end < start || end >= total {
+		return 0, 0, 0, bad
+	}
+	return start, end, total, nil
+}
+
+// digits reports whether s is a non-empty run of ASCII digits.
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// chunkBody returns the chunk bytes of r: the first file of a multipart
+// form, read as it streams in, or else the raw body.
+func chunkBody(r *http.Request) (io.Reader, error) {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if t != "multipart/form-data" {
+		return r.Body, nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no file in form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+// uploadCORS allows browser pages of origins to use the upload, list and
+// artifact endpoints. Plain OPTIONS requests still reach tusOptions; only
+// preflights are answered here.
+func uploadCORS(origins []string) echo.MiddlewareFunc {
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		Skipper: func(c echo.Context) bool {
+			r := c.Request()
+			return r.Header.Get(echo.HeaderOrigin) == "" ||
+				r.Method == http.MethodOptions && r.Header.Get(echo.HeaderAccessControlRequestMethod) == ""
+		},
+		AllowOrigins: origins,
+		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		AllowHeaders: []string{"Content-Type", "Content-Range", "Range", "If-Range", "X-Requested-With", "X-Request-ID",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Defer-Length"},
+		ExposeHeaders: []string{"Location", "ETag", "X-Request-ID", "Content-Range", "Content-Length", "Accept-Ranges",
+			"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Upload-Offset", "Upload-Length", "Upload-Drive-Id"},
+		MaxAge: 24 * 60 * 60,
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header            string
+		length            int64
+		start, end, total int64
+	}{
+		{"", 100, 0, 99, 100},
+		{"bytes 0-99/100", 100, 0, 99, 100},
+		{"bytes 10-19/100", 100, 10, 19, 100},
+		{" bytes  99-99/100 ", 100, 99, 99, 100},
+		// the total is checked against the upload by the caller
+		{"bytes 0-9/50", 100, 0, 9, 50},
+		{"bytes */100", 100, -1, -1, 100},
+		{"bytes */0", 0, -1, -1, 0},
+	}
+	for _, tt := range tests {
+		start, end, total, err := parseContentRange(tt.header, tt.length)
+		if err != nil || start != tt.start || end != tt.end || total != tt.total {
+			t.Errorf("%q = %d, %d, %d, %v; want %d, %d, %d",
+				tt.header, start, end, total, err, tt.start, tt.end, tt.total)
+		}
+	}
+	for _, bad := range []string{
+		"0-99/100",
+		"items 0-99/100",
+		"bytes=0-99/100",
+		"bytes 0-99",
+		"bytes 0-99/",
+		"bytes 0-99/*",
+		"bytes 0-100/100",
+		"bytes 9-0/100",
+		"bytes -5/100",
+		"bytes 5-/100",
+		"bytes +1-5/100",
+		"bytes 0-5/100 junk",
+		"bytes 0 - 5/100",
+		"bytes */",
+		"bytes */-1",
+		"bytes a-b/c",
+	} {
+		if _, _, _, err := parseContentRange(bad, 100); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	magic "../plugins"
+	"github.com/labstack/echo"
+)
+
+// settings is the part of the configuration that reload swaps while the
+// gateway runs. Transfers in flight keep the bandwidth caps and Drive
+// sessions they started with; requests after the swap see the new values.
+type settings struct {
+	bandwidth *magic.Bandwidth // gateway-wide cap
+	profiles  map[string]*magic.Bandwidth
+	policies  magic.Policies
+	routes    []magic.Route
+	browse    string
+	cors      echo.MiddlewareFunc // nil without origins
+	readOnly  bool
+	allow     []string // folders changes are limited to
+	rules     magic.Rules
+}
+
+var live struct {
+	sync.RWMutex
+	settings
+}
+
+func current() settings {
+	live.RLock()
+	defer live.RUnlock()
+	return live.settings
+}
+
+// settingsSource is where settings come from: the config file, and the
+// flags that override it.
+type settingsSource struct {
+	config        string
+	bwlimit       string
+	profileLimits string
+	policyFile    string
+	corsOrigins   string
+	readOnly      bool
+}
+
+func (src settingsSource) load() (settings, error) {
+	var s settings
+	config, err := magic.LoadConfig(src.config)
+	if err != nil {
+		return s, err
+	}
+	bwlimit := src.bwlimit
+	if bwlimit == "" {
+		bwlimit = config.BWLimit
+	}
+	if s.bandwidth, err = magic.ParseBandwidth(bwlimit); err != nil {
+		return s, err
+	}
+	if s.profiles, err = magic.ParseProfiles(src.profileLimits, s.bandwidth); err != nil {
+		return s, err
+	}
+	if src.policyFile != "" {
+		if s.policies, err = magic.LoadPolicies(src.policyFile); err != nil {
+			return s, err
+		}
+	}
+	s.routes, s.browse = config.Routes, config.Browse
+	if s.rules, err = magic.CompileRules(config.Rules); err != nil {
+		return s, err
+	}
+	s.readOnly, s.allow = src.readOnly || config.ReadOnly, config.AllowFolders
+	if src.corsOrigins != "" {
+		config.CORSOrigins = strings.Split(src.corsOrigins, ",")
+	}
+	if len(config.CORSOrigins) > 0 {
+		s.cors = uploadCORS(config.CORSOrigins)
+	}
+	return s, nil
+}
+
+// readAll is set when the gateway was authorized to read all of Drive,
+// which routes and browsing need.
+var readAll bool
+
+// reload re-reads the settings and swaps them in; invalid ones leave the
+// running settings alone.
+func reload(src settingsSource) error {
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
+	s, err := src.load()
+	if err != nil {
+		return err
+	}
+	live.Lock()
+	live.settings = s
+	live.Unlock()
+	magic.SetGuard(s.readOnly, s.allow)
+	artifacts.SetRoutes(s.routes)
+	if !readAll && (len(s.routes) > 0 || s.browse != "") {
+		log.Printf("routes and browse need read access to all of Drive; restart to authorize it")
+	}
+	log.Printf("configuration reloaded from %s", src.config)
+	return nil
+}
+
+// handleReloadSignal reloads on SIGHUP, which never arrives where it does
+// not exist.
+func handleReloadSignal(src settingsSource) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			if err := reload(src); err != nil {
+				log.Printf("unable to reload configuration: %v", err)
+			}
+		}
+	}()
+}
+
+// reloadHandler is the admin endpoint doing what SIGHUP does.
+func reloadHandler(src settingsSource) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := reload(src)
+		entry := magic.AuditEntry{Actor: "admin", Op: "reload", Params: map[string]string{"ip": c.RealIP()}, RequestID: requestID(c)}
+		if err != nil {
+			entry.Error = err.Error()
+			magic.Audit(entry)
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		magic.Audit(entry)
+		return c.JSON(http.StatusOK, map[string]bool{"reloaded": true})
+	}
+}
+
+// liveCORS applies the CORS settings in force.
+func liveCORS(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if cors := current().cors; cors != nil {
+			return cors(next)(c)
+		}
+		return next(c)
+	}
+}
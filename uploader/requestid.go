@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	magic "../plugins"
+	"github.com/labstack/echo"
+)
+
+// validRequestID keeps client supplied ids from garbling logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDs accepts the client's X-Request-ID or makes one up, echoes it
+// in the response and puts it in the request context, so the logger, audit
+// entries and the Drive calls of the request all carry it.
+func requestIDs(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		id := req.Header.Get(echo.HeaderXRequestID)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+			req.Header.Set(echo.HeaderXRequestID, id)
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		c.SetRequest(req.WithContext(magic.WithRequestID(req.Context(), id)))
+		return next(c)
+	}
+}
+
+// tenantHeader, when set, names the header identifying the client a
+// request is made for, as set by a proxy in front of the gateway.
+var tenantHeader string
+
+// quotaUsers counts the Drive calls of each request against the quota of
+// its client: the tenantHeader value, or else the client address.
+func quotaUsers(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		user := ""
+		if tenantHeader != "" {
+			user = req.Header.Get(tenantHeader)
+		}
+		if !validRequestID.MatchString(user) {
+			user = c.RealIP()
+		}
+		c.SetRequest(req.WithContext(magic.WithQuotaUser(req.Context(), user)))
+		return next(c)
+	}
+}
+
+// requestID returns the id requestIDs gave the request.
+func requestID(c echo.Context) string {
+	return magic.RequestID(c.Request().Context())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	magic "../plugins"
+	"github.com/labstack/echo"
+)
+
+// scanners run over every upload before it is committed.
+var scanners []magic.Scanner
+
+// setupScanners builds the scanner chain from the command line options:
+// cmd is a shell-style command line, clamd is "tcp:host:port" or
+// "unix:/path", denied is a comma separated list of content types.
+func setupScanners(cmd, clamd, denied string) {
+	if denied != "" {
+		var types []string
+		for _, t := range strings.Split(denied, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+		scanners = append(scanners, magic.TypeScanner{Denied: types})
+	}
+	if clamd != "" {
+		parts := strings.SplitN(clamd, ":", 2)
+		if len(parts) != 2 || parts[0] != "tcp" && parts[0] != "unix" || parts[1] == "" {
+			log.Fatalf("invalid -clamd %q: want tcp:host:port or unix:/path", clamd)
+		}
+		scanners = append(scanners, magic.ClamdScanner{Network: parts[0], Address: parts[1]})
+	}
+	if cmd != "" {
+		args := strings.Fields(cmd)
+		scanners = append(scanners, magic.CommandScanner{Command: args[0], Args: args[1:]})
+	}
+}
+
+// rejectUpload answers a failed scan or policy check. Rejections are the
+// client's fault and get a 422 with the reason, and the policy rule when
+// one was broken; anything else is a server error.
+func rejectUpload(c echo.Context, err error) error {
+	if rej, ok := err.(*magic.Rejection); ok {
+		body := map[string]string{
+			"error": "rejected",
+			"file":  rej.Name,
+			"cause": rej.Reason,
+		}
+		if rej.Rule != "" {
+			body["rule"] = rej.Rule
+		}
+		return c.JSON(http.StatusUnprocessableEntity, body)
+	}
+	return err
+}
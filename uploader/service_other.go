@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "errors"
+
+var errNoService = errors.New("services can only be installed on Windows; use a systemd unit here")
+
+func installService(args []string) error {
+	return errNoService
+}
+
+func uninstallService() error {
+	return errNoService
+}
+
+// runService does nothing outside Windows.
+func runService() {}
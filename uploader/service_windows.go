@@ -0,0 +1,124 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const serviceName = "magicServer"
+
+// installService registers the running executable as an automatically
+// started Windows service run with args, and its event log source.
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.Abs(exe); err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "magicServer Drive gateway",
+		Description: "Uploads to and serves files from Google Drive.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return fmt.Errorf("unable to create service: %v", err)
+	}
+	defer s.Close()
+	if err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		s.Delete()
+		return fmt.Errorf("unable to set up event log source: %v", err)
+	}
+	return nil
+}
+
+// uninstallService removes what installService registered.
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("unable to connect to the service manager: %v", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", serviceName)
+	}
+	defer s.Close()
+	if err = s.Delete(); err != nil {
+		return err
+	}
+	eventlog.Remove(serviceName)
+	return nil
+}
+
+// runService, when started by the service manager, reports the gateway
+// running, sends the log to the event log and exits on Stop. Relative
+// paths, such as the config and token files, are then taken from the
+// executable's folder rather than the system one services start in. It
+// returns at once otherwise.
+func runService() {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return
+	}
+	if exe, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exe))
+	}
+	if el, err := eventlog.Open(serviceName); err == nil {
+		log.SetOutput(eventWriter{el})
+	}
+	go func() {
+		if err := svc.Run(serviceName, service{}); err != nil {
+			log.Printf("service failed: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+}
+
+type service struct{}
+
+func (service) Execute(args []string, r <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			status <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// eventWriter sends log lines to the event log as information.
+type eventWriter struct {
+	el *eventlog.Log
+}
+
+func (w eventWriter) Write(p []byte) (int, error) {
+	if err := w.el.Info(1, strings.TrimRight(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
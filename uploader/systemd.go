@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// systemd integration without the go-systemd dependency: socket activation
+// hands the gateway its listening socket as fd 3 (LISTEN_FDS), and
+// sd_notify datagrams to $NOTIFY_SOCKET report readiness, reloads and
+// watchdog pings for Type=notify units. Outside systemd all of it is a
+// no-op.
+
+const listenFdsStart = 3
+
+// listener returns the socket systemd passed in, or else one listening on
+// addr.
+func listener(addr string) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err == nil && pid == os.Getpid() {
+		n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		// child processes must not take the socket for theirs
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		if n > 0 {
+			if n > 1 {
+				log.Printf("systemd passed %d sockets; serving on the first", n)
+			}
+			f := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+			l, err := net.FileListener(f)
+			f.Close()
+			return l, err
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// sdNotify sends state, such as "READY=1", to the service manager and
+// reports whether there is one.
+func sdNotify(state string) bool {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false
+	}
+	if name[0] == '@' {
+		name = "\x00" + name[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		log.Printf("unable to notify systemd: %v", err)
+		return false
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		log.Printf("unable to notify systemd: %v", err)
+		return false
+	}
+	return true
+}
+
+// sdWatchdog pings the watchdog at half the WatchdogSec= interval for as
+// long as the process runs.
+func sdWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid, err := strconv.Atoi(os.Getenv("WATCHDOG_PID")); err == nil && pid != os.Getpid() {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	magic "../plugins"
+	"github.com/labstack/echo"
+	"google.golang.org/api/drive/v3"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// tus.io 1.0.0 resumable uploads. Every upload owns a Drive resumable
+// session; PATCH bodies are streamed into it as they arrive. When scanners
+// are configured the body is also spooled to disk, and the final chunk is
+// only released to Drive once the spooled copy passes. The optional
+// "priority" metadata (high, normal, low) ranks the upload's chunks when
+// -chunk-slots is set, and "profile" picks a -profile-bwlimit cap.
+
+const (
+	tusVersion = "1.0.0"
+	tusMaxSize = 50 << 30
+)
+
+type tusUpload struct {
+	sync.Mutex
+	ID     string
+	Length int64
+	Offset int64
+	Meta   map[string]string
+	// RequestID is the one of the creating request, under which the
+	// whole upload is traced.
+	RequestID string
+
+	session *magic.Session
+	spool   *os.File
+	seen    time.Time // of the last chunk, for reapUploads
+	gone    bool      // finished or discarded while a request waited
+}
+
+var tusUploads = struct {
+	sync.Mutex
+	m map[string]*tusUpload
+}{m: map[string]*tusUpload{}}
+
+// profileBandwidth returns the cap for an upload of profile; unknown and
+// unnamed profiles only share the global one.
+func profileBandwidth(profile string) *magic.Bandwidth {
+	s := current()
+	if b, ok := s.profiles[profile]; ok {
+		return b
+	}
+	return s.bandwidth
+}
+
+func tusHeaders(c echo.Context) {
+	h := c.Response().Header()
+	h.Set("Tus-Resumable", tusVersion)
+	h.Set("Cache-Control", "no-store")
+}
+
+// parseTusMeta decodes the Upload-Metadata header ("key base64,key base64").
+func parseTusMeta(s string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if kv[0] == "" {
+			continue
+		}
+		if len(kv) == 1 {
+			meta[kv[0]] = ""
+			continue
+		}
+		v, err := base64.StdEncoding.DecodeString(kv[1])
+		if err == nil {
+			meta[kv[0]] = string(v)
+		}
+	}
+	return meta
+}
+
+func tusOptions(c echo.Context) error {
+	tusHeaders(c)
+	h := c.Response().Header()
+	h.Set("Tus-Version", tusVersion)
+	h.Set("Tus-Extension", "creation,termination")
+	h.Set("Tus-Max-Size", strconv.FormatInt(tusMaxSize, 10))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func tusCreate(c echo.Context) error {
+	tusHeaders(c)
+	r := c.Request()
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return c.String(http.StatusBadRequest, "invalid Upload-Length")
+	}
+	if length > tusMaxSize {
+		return c.String(http.StatusRequestEntityTooLarge, "upload too large")
+	}
+	up, err := newUpload(c, "/files", parseTusMeta(r.Header.Get("Upload-Metadata")), length)
+	if he, ok := err.(*echo.HTTPError); ok {
+		return c.String(he.Code, fmt.Sprint(he.Message))
+	}
+	if err != nil {
+		return rejectUpload(c, err)
+	}
+	c.Response().Header().Set("Location", "/files/"+up.ID)
+	return c.NoContent(http.StatusCreated)
+}
+
+// newUpload opens the Drive session of an upload of length bytes to
+// route, traced under the id of the request c. meta holds the tus
+// Upload-Metadata keys: id of the user, filename, filetype, folder,
+// priority and profile. Bad metadata and folders the guard protects are
+// an *echo.HTTPError, and a file the route's policy refuses a
+// *magic.Rejection.
+func newUpload(c echo.Context, route string, meta map[string]string, length int64) (*tusUpload, error) {
+	if err := current().policies.For(route).Check(meta["filename"], meta["filetype"], length); err != nil {
+		return nil, err
+	}
+	priority, err := magic.ParsePriority(meta["priority"])
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if !bson.IsObjectIdHex(meta["id"]) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "missing user id")
+	}
+	if _, err = findUser(meta["id"]); err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unknown user")
+	}
+
+	f := &drive.File{Name: meta["filename"], MimeType: meta["filetype"]}
+	if meta["folder"] != "" {
+		f.Parents = []string{meta["folder"]}
+	}
+	// the client sends no mtime, so rules see an age of 0
+	rf := magic.RuleFile{Path: meta["filename"], Size: length, Host: c.RealIP()}
+	if r := current().rules.Match(rf); r != nil && r.Folder != "" {
+		f.Parents = []string{r.Folder}
+	}
+	// the session's chunks count against the user's quota, not the caller's
+	ctx := magic.WithQuotaUser(c.Request().Context(), meta["id"])
+	session, err := magic.NewSession(magic.RequestClient(ctx, driveClient), f, length)
+	if magic.Refused(err) {
+		return nil, echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	if err != nil {
+		return nil, magic.ExplainAuthError(err)
+	}
+	session.Priority = priority
+	session.Bandwidth = profileBandwidth(meta["profile"])
+
+	id, err := newUploadID()
+	if err != nil {
+		session.Cancel()
+		return nil, err
+	}
+	up := &tusUpload{ID: id, Length: length, Meta: meta, RequestID: requestID(c), session: session, seen: time.Now()}
+	if len(scanners) > 0 {
+		if up.spool, err = ioutil.TempFile("", "tus-"); err != nil {
+			session.Cancel()
+			return nil, err
+		}
+	}
+	tusUploads.Lock()
+	tusUploads.m[up.ID] = up
+	tusUploads.Unlock()
+	return up, nil
+}
+
+// newUploadID returns a random upload ID: knowing one lets a client
+// write to or delete the upload, so it must not be guessable.
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// write streams body into the upload at its offset, up to its length, and
+// returns the new offset. Whatever made it into the session before a
+// broken connection counts, so the client can resume from there. The
+// caller holds the upload's lock.
+func (up *tusUpload) write(body io.Reader) (int64, error) {
+	var dst io.Writer = up.session
+	if up.spool != nil {
+		dst = io.MultiWriter(up.session, up.spool)
+	}
+	n, err := io.Copy(dst, io.LimitReader(body, up.Length-up.Offset))
+	up.Offset += n
+	up.seen = time.Now()
+	return up.Offset, err
+}
+
+// finish scans and commits a complete upload, auditing it as sent via.
+// A scanner rejection discards it and is returned as the *magic.Rejection.
+func (up *tusUpload) finish(c echo.Context, via string) (*drive.File, error) {
+	if up.spool != nil {
+		if err := magic.Scanners(up.Meta["filename"], up.spool, scanners...); err != nil {
+			tusDiscard(up)
+			return nil, err
+		}
+	}
+	f, err := up.session.Close()
+	entry := magic.AuditEntry{
+		Actor:     up.Meta["id"],
+		Op:        "upload",
+		Name:      up.Meta["filename"],
+		Params:    map[string]string{"folder": up.Meta["folder"], "ip": c.RealIP(), "via": via},
+		RequestID: up.RequestID,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		magic.Audit(entry)
+		return nil, err
+	}
+	entry.FileID = f.Id
+	magic.Audit(entry)
+	tusForget(up)
+	return f, nil
+}
+
+// tusLookup returns the upload of c's id param locked, or nil when there
+// is none.
+func tusLookup(c echo.Context) *tusUpload {
+	tusUploads.Lock()
+	up := tusUploads.m[c.Param("id")]
+	tusUploads.Unlock()
+	if up == nil {
+		return nil
+	}
+	up.Lock()
+	if up.gone {
+		up.Unlock()
+		return nil
+	}
+	return up
+}
+
+func tusHead(c echo.Context) error {
+	tusHeaders(c)
+	up := tusLookup(c)
+	if up == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	defer up.Unlock()
+	h := c.Response().Header()
+	h.Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	return c.NoContent(http.StatusOK)
+}
+
+func tusPatch(c echo.Context) error {
+	tusHeaders(c)
+	r := c.Request()
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return c.NoContent(http.StatusUnsupportedMediaType)
+	}
+	up := tusLookup(c)
+	if up == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	defer up.Unlock()
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != up.Offset {
+		return c.NoContent(http.StatusConflict)
+	}
+
+	n, err := up.write(r.Body)
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(n, 10))
+	if err != nil {
+		return err
+	}
+	if up.Offset == up.Length {
+		f, err := up.finish(c, "tus")
+		if err != nil {
+			return rejectUpload(c, err)
+		}
+		c.Response().Header().Set("Upload-Drive-Id", f.Id)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func tusDelete(c echo.Context) error {
+	tusHeaders(c)
+	up := tusLookup(c)
+	if up == nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	defer up.Unlock()
+	tusDiscard(up)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// tusForget drops a finished upload and its spool file. The caller holds
+// the upload's lock.
+func tusForget(up *tusUpload) {
+	up.gone = true
+	tusUploads.Lock()
+	delete(tusUploads.m, up.ID)
+	tusUploads.Unlock()
+	if up.spool != nil {
+		up.spool.Close()
+		os.Remove(up.spool.Name())
+	}
+}
+
+// tusDiscard drops an upload and abandons its Drive session.
+func tusDiscard(up *tusUpload) {
+	tusForget(up)
+	up.session.Cancel()
+}
+
+// reapUploads discards, every minute, the uploads no chunk reached for
+// idle: each holds a Drive session buffer and maybe a spool file.
+func reapUploads(idle time.Duration) {
+	if idle <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Minute) {
+			tusUploads.Lock()
+			list := make([]*tusUpload, 0, len(tusUploads.m))
+			for _, up := range tusUploads.m {
+				list = append(list, up)
+			}
+			tusUploads.Unlock()
+			for _, up := range list {
+				up.Lock()
+				if !up.gone && time.Since(up.seen) > idle {
+					log.Printf("discarding upload %s of %s, idle since %s", up.ID, up.Meta["filename"], up.seen.Format(time.RFC3339))
+					tusDiscard(up)
+				}
+				up.Unlock()
+			}
+		}
+	}()
+}
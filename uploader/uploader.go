@@ -2,38 +2,51 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	magic "../plugins"
 	magic_struct "./pkg"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/api/drive/v3"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-func upload(c echo.Context) error {
-
-	// Read form fields
+var driveClient *http.Client
 
-	U := &magic_struct.UpDT{ID: c.FormValue("ID"), Path: c.FormValue("path")}
+func findUser(id string) (magic_struct.Userdata, error) {
+	result := magic_struct.Userdata{}
 
 	//mongo connect
 	session, err := mgo.Dial("127.0.0.1")
 	if err != nil {
-		panic(err)
+		return result, err
 	}
 	defer session.Close()
 	session.SetMode(mgo.Monotonic, true)
-	////////
-	result := magic_struct.Userdata{}
+
 	cc := session.DB("magic").C("userInfo")
+	err = cc.FindId(bson.ObjectIdHex(id)).One(&result)
+	return result, err
+}
+
+func upload(c echo.Context) error {
+
+	// Read form fields
+
+	U := &magic_struct.UpDT{ID: c.FormValue("ID"), Path: c.FormValue("path")}
 
 	log.Print(U.ID)
-	err = cc.FindId(bson.ObjectIdHex(U.ID)).One(&result)
+	result, err := findUser(U.ID)
 	if err != nil {
 		//redirect here
 		log.Fatal(err)
@@ -62,6 +75,13 @@ func upload(c echo.Context) error {
 		}
 		defer src.Close()
 
+		if err = current().policies.For("/upload").Check(file.Filename, file.Header.Get("Content-Type"), file.Size); err != nil {
+			return rejectUpload(c, err)
+		}
+		if err = magic.Scanners(file.Filename, src, scanners...); err != nil {
+			return rejectUpload(c, err)
+		}
+
 		// Destination
 		dst, err := os.Create(file.Filename)
 		if err != nil {
@@ -89,17 +109,154 @@ func upload(c echo.Context) error {
 		if err = writer.Flush(); err != nil {
 			panic(err)
 		}
+		magic.Audit(magic.AuditEntry{
+			Actor:     U.ID,
+			Op:        "upload",
+			Name:      file.Filename,
+			Params:    map[string]string{"path": U.Path, "ip": c.RealIP()},
+			RequestID: requestID(c),
+		})
 
 	}
 
 	return c.HTML(http.StatusOK, fmt.Sprintf("<p>Uploaded successfully %d files with fields name=%s and email=%s.</p>", len(files), U.ID, U.Path))
 }
 
+// serviceArgs drops -install-service from args, leaving the flags the
+// service is to run with.
+func serviceArgs(args []string) []string {
+	var out []string
+	for _, a := range args {
+		switch strings.TrimLeft(a, "-") {
+		case "install-service", "install-service=true":
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
 func main() {
+	scanCmd := flag.String("scan-cmd", "", "command that reads an upload on stdin and exits non-zero to reject it")
+	clamd := flag.String("clamd", "", "clamd address to scan uploads with, tcp:host:port or unix:/path")
+	denyTypes := flag.String("deny-types", "", "comma separated content types rejected on upload")
+	policyFile := flag.String("upload-policy", "", "YAML file of accepted extensions, types and sizes per upload route")
+	auditLog := flag.String("audit-log", "", "append a JSON line per mutating operation to this file")
+	auditSyslog := flag.Bool("audit-syslog", false, "also send audit entries to syslog")
+	uploadIdle := flag.Duration("upload-idle", 24*time.Hour, "discard tus and chunked uploads no chunk reached for this long (0 keeps them)")
+	chunkSlots := flag.Int("chunk-slots", 0, "Drive chunks in flight at once, handed out by upload priority (0 is unlimited)")
+	bwlimit := flag.String("bwlimit", "", "cap the combined upload bandwidth to Drive, a rate such as 20M or a schedule")
+	configFile := flag.String("config", magic.ConfigFile, "YAML settings file, overridden by flags")
+	flag.StringVar(&magic.SecretFile, "client-secret", magic.SecretFile, "OAuth client secret JSON file; $"+magic.SecretEnv+" holds the JSON itself")
+	flag.StringVar(&magic.TokenFile, "token", magic.TokenFile, "cached OAuth token file; $"+magic.TokenEnv+" holds the JSON itself")
+	flag.BoolVar(&magic.TokenEncrypt, "token-encrypt", false, "encrypt the cached token with a passphrase from -token-key-file, $"+magic.TokenPassphraseEnv+" or a prompt")
+	tokenKeyFile := flag.String("token-key-file", "", "read the token passphrase from the first line of this file")
+	flag.StringVar(&magic.CredentialsFile, "credentials", "", "authorize with this workload identity federation config JSON instead of the client secret and cached token")
+	routeTTL := flag.Duration("artifact-ttl", 5*time.Minute, "how long routed artifact paths are cached, and their Cache-Control max-age")
+	corsOrigins := flag.String("cors-origins", "", "comma separated web origins allowed to call the gateway from browsers, * for any")
+	readOnly := flag.Bool("read-only", false, "refuse every change to Drive, uploads included")
+	profileLimits := flag.String("profile-bwlimit", "", "per-profile upload caps within -bwlimit, e.g. backup=2M,interactive=off")
+	flag.BoolVar(&magic.DebugHTTP, "debug-http", false, "log every call to Google: method, URL, status, latency and retries, with credentials redacted")
+	flag.BoolVar(&magic.DebugHTTPBody, "debug-http-body", false, "like -debug-http, also logging headers and text payloads")
+	flag.StringVar(&tenantHeader, "tenant-header", "", "share Drive rate limits per client named by this request header, e.g. X-Tenant, instead of per client address")
+	install := flag.Bool("install-service", false, "install as a Windows service started with the other flags given, then exit")
+	uninstall := flag.Bool("uninstall-service", false, "remove the Windows service, then exit")
+	flag.Parse()
+	switch {
+	case *install:
+		if err := installService(serviceArgs(os.Args[1:])); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case *uninstall:
+		if err := uninstallService(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	runService()
+	magic.TokenPassphrase = magic.Passphrase(*tokenKeyFile)
+	magic.Slots = magic.NewScheduler(*chunkSlots)
+	src := settingsSource{
+		config:        *configFile,
+		bwlimit:       *bwlimit,
+		profileLimits: *profileLimits,
+		policyFile:    *policyFile,
+		corsOrigins:   *corsOrigins,
+		readOnly:      *readOnly,
+	}
+	s, err := src.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	live.settings = s
+	magic.SetGuard(s.readOnly, s.allow)
+	setupScanners(*scanCmd, *clamd, *denyTypes)
+	if err := magic.OpenAudit(*auditLog, *auditSyslog); err != nil {
+		log.Fatal(err)
+	}
+	defer magic.CloseAudit()
+
+	// routes and listings reach files the gateway did not create
+	var scopes []string
+	if readAll = len(s.routes) > 0 || s.browse != ""; readAll {
+		scopes = []string{drive.DriveFileScope, drive.DriveReadonlyScope}
+	}
+	driveClient, err = magic.Client(context.Background(), scopes...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv, err := magic.Service(driveClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	e := echo.New()
 
+	e.Use(requestIDs)
+	e.Use(quotaUsers)
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(liveCORS)
 	e.POST("/upload", upload)
-	e.Logger.Fatal(e.Start(":1323"))
+
+	//tus resumable uploads
+	reapUploads(*uploadIdle)
+	e.OPTIONS("/files", tusOptions)
+	e.POST("/files", tusCreate)
+	e.HEAD("/files/:id", tusHead)
+	e.PATCH("/files/:id", tusPatch)
+	e.DELETE("/files/:id", tusDelete)
+
+	//chunked browser uploads
+	e.POST("/chunks", chunksCreate)
+	e.GET("/chunks/:id", chunksState)
+	e.PUT("/chunks/:id", chunksPut)
+	e.POST("/chunks/:id", chunksPut)
+	e.DELETE("/chunks/:id", chunksDelete)
+
+	//operator controls, loopback only
+	magic.HandlePauseSignals()
+	admin := e.Group("/admin", localOnly)
+	admin.GET("/transfers", transfersState)
+	admin.POST("/transfers/pause", transfersPause)
+	admin.POST("/transfers/resume", transfersResume)
+	admin.POST("/reload", reloadHandler(src))
+	handleReloadSignal(src)
+	e.GET("/api/stats", statsAPI, localOnly)
+	e.GET("/metrics", metrics, localOnly)
+
+	//folder listings and downloads
+	browseDrive = srv
+	e.GET("/api/list", listAPI)
+	e.GET("/api/zip", zipAPI)
+
+	//artifact site
+	setupArtifacts(e, srv, s.routes, *routeTTL)
+	if e.Listener, err = listener(":1323"); err != nil {
+		log.Fatal(err)
+	}
+	sdNotify("READY=1")
+	sdWatchdog()
+	e.Logger.Fatal(e.Start(""))
 }